@@ -0,0 +1,33 @@
+package domain
+
+type (
+	// ExcludeFilter removes the products with any of the values from the result, the negative counterpart of a
+	// searchDomain.KeyValueFilter. Like for the KeyValueFilter the key "category" filters by category code
+	ExcludeFilter struct {
+		key    string
+		values []string
+	}
+)
+
+// NewExcludeFilter returns a new ExcludeFilter
+func NewExcludeFilter(key string, values []string) *ExcludeFilter {
+	return &ExcludeFilter{
+		key:    key,
+		values: values,
+	}
+}
+
+// Value of the filter
+func (f *ExcludeFilter) Value() (string, []string) {
+	return f.key, f.values
+}
+
+// Key of the filter
+func (f *ExcludeFilter) Key() string {
+	return f.key
+}
+
+// Values of the filter
+func (f *ExcludeFilter) Values() []string {
+	return f.values
+}
@@ -0,0 +1,43 @@
+package domain
+
+type (
+	// Highlights contains the highlighted fragments of search hits, keyed by marketplace code and field
+	Highlights map[string]map[string][]string
+
+	// HighlightFilter requests highlighted fragments for the matched fields of the search hits, see Highlights
+	HighlightFilter struct {
+		hitResultCollector
+	}
+)
+
+const (
+	// HighlightFieldTitle is the highlight field for the product title
+	HighlightFieldTitle = "title"
+	// HighlightFieldDescription is the highlight field for the product description
+	HighlightFieldDescription = "description"
+)
+
+// NewHighlightFilter returns a new HighlightFilter
+func NewHighlightFilter() *HighlightFilter {
+	return &HighlightFilter{}
+}
+
+// Value of the filter
+func (f *HighlightFilter) Value() (string, []string) {
+	return "highlight", nil
+}
+
+// AddFragments adds highlighted fragments for a field of the hit with the given marketplace code
+func (f *HighlightFilter) AddFragments(marketPlaceCode string, field string, fragments []string) {
+	f.add(marketPlaceCode, field, fragments)
+}
+
+// Highlights returns the highlighted fragments of the last Find the filter was passed to
+func (f *HighlightFilter) Highlights() Highlights {
+	return f.all()
+}
+
+// Fragments returns the highlighted fragments of a hit for the given field (HighlightFieldTitle, HighlightFieldDescription or an attribute code)
+func (h Highlights) Fragments(marketPlaceCode string, field string) []string {
+	return h[marketPlaceCode][field]
+}
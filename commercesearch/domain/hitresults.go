@@ -0,0 +1,44 @@
+package domain
+
+import (
+	"sync"
+)
+
+// hitResultCollector collects values per search hit and key for the filters which return additional results of a search,
+// like HighlightFilter and MatchedVariantsFilter. Repositories supporting such a filter call Reset at the start of each
+// Find, so the results belong to the last search. Repositories without support ignore the filter
+type hitResultCollector struct {
+	mutex   sync.Mutex
+	results map[string]map[string][]string
+}
+
+// Reset removes the values collected by a previous search
+func (c *hitResultCollector) Reset() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.results = make(map[string]map[string][]string)
+}
+
+// add appends values for the key of the hit with the given marketplace code
+func (c *hitResultCollector) add(marketPlaceCode string, key string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.results == nil {
+		c.results = make(map[string]map[string][]string)
+	}
+	if c.results[marketPlaceCode] == nil {
+		c.results[marketPlaceCode] = make(map[string][]string)
+	}
+	c.results[marketPlaceCode][key] = append(c.results[marketPlaceCode][key], values...)
+}
+
+// all returns the collected values by marketplace code and key
+func (c *hitResultCollector) all() map[string]map[string][]string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.results
+}
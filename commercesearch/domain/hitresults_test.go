@@ -0,0 +1,27 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHitResultCollector_Reset(t *testing.T) {
+	highlightFilter := NewHighlightFilter()
+	highlightFilter.Reset()
+	highlightFilter.AddFragments("p1", HighlightFieldTitle, []string{"<mark>red</mark> shoe"})
+	highlightFilter.AddFragments("p1", HighlightFieldTitle, nil)
+	assert.Equal(t, []string{"<mark>red</mark> shoe"}, highlightFilter.Highlights().Fragments("p1", HighlightFieldTitle))
+
+	highlightFilter.Reset()
+	assert.Empty(t, highlightFilter.Highlights().Fragments("p1", HighlightFieldTitle), "a new search does not keep the fragments of the last one")
+
+	matchedVariantsFilter := NewMatchedVariantsFilter()
+	matchedVariantsFilter.Reset()
+	matchedVariantsFilter.AddVariants("shirt", []string{"shirt-red"})
+	assert.Equal(t, MatchedVariants{"shirt": {"shirt-red"}}, matchedVariantsFilter.MatchedVariants())
+
+	matchedVariantsFilter.Reset()
+	matchedVariantsFilter.AddVariants("shirt", []string{"shirt-blue"})
+	assert.Equal(t, []string{"shirt-blue"}, matchedVariantsFilter.MatchedVariants().Variants("shirt"), "the variants of the last search are not accumulated")
+}
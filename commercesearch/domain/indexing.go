@@ -53,6 +53,19 @@ type (
 	IndexUpdater interface {
 		Index(ctx context.Context, rep *Indexer) error
 	}
+
+	// SourceFingerprinter - optional interface of an IndexUpdater that identifies the state of its source data, e.g. the modification times of the CSV files
+	SourceFingerprinter interface {
+		SourceFingerprint(ctx context.Context) (string, error)
+	}
+
+	// SnapshotRepository - optional interface of a ProductRepository that can restore its index from a snapshot instead of running the IndexUpdater
+	SnapshotRepository interface {
+		// RestoreSnapshot restores the index from a snapshot of the same source data, returns false if there is no such snapshot
+		RestoreSnapshot(ctx context.Context, sourceFingerprint string) (bool, error)
+		// IndexFinished is called after the IndexUpdater indexed all products, e.g. to save a snapshot
+		IndexFinished(ctx context.Context, sourceFingerprint string) error
+	}
 )
 
 var (
@@ -151,6 +164,23 @@ func (p *IndexProcess) Run(ctx context.Context) error {
 	mutex.Lock()
 	defer mutex.Unlock()
 
+	snapshotRepository, hasSnapshot := p.indexer.ProductRepository().(SnapshotRepository)
+	var sourceFingerprint string
+	if hasSnapshot {
+		sourceFingerprint = p.sourceFingerprint(ctx)
+	}
+	// without a fingerprint a changed source can't be detected, so the snapshot is not restored
+	if hasSnapshot && sourceFingerprint != "" {
+		restored, err := snapshotRepository.RestoreSnapshot(ctx, sourceFingerprint)
+		if err != nil {
+			p.logger.Warn("Restoring snapshot failed, running registered Indexer: ", err)
+		}
+		if restored {
+			p.logger.Info("Restored index from snapshot, skipping registered Indexer..")
+			return nil
+		}
+	}
+
 	p.logger.Info("Prepareing Indexes..")
 	err := p.indexer.PrepareIndex(ctx)
 	if err != nil {
@@ -165,9 +195,30 @@ func (p *IndexProcess) Run(ctx context.Context) error {
 
 	p.logger.Info("Indexing finished..")
 
+	if hasSnapshot {
+		// the index is complete, a failing snapshot only slows down the next start
+		err = snapshotRepository.IndexFinished(ctx, sourceFingerprint)
+		if err != nil {
+			p.logger.Warn("Saving snapshot failed: ", err)
+		}
+	}
 	return nil
 }
 
+// sourceFingerprint returns the fingerprint of the source data of the IndexUpdater, empty if it has none
+func (p *IndexProcess) sourceFingerprint(ctx context.Context) string {
+	fingerprinter, ok := p.indexUpdater.(SourceFingerprinter)
+	if !ok {
+		return ""
+	}
+	fingerprint, err := fingerprinter.SourceFingerprint(ctx)
+	if err != nil {
+		p.logger.Warn("Source fingerprint failed, snapshots are not restored: ", err)
+		return ""
+	}
+	return fingerprint
+}
+
 // AddCategoryData to the builder.. Call this as often as you want to add before calling BuildTree
 func (h *CategoryTreeBuilder) AddCategoryData(code string, name string, parentCode string) {
 	if h.categoryTreeIndex == nil {
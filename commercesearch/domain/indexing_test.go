@@ -1,12 +1,53 @@
 package domain
 
 import (
+	"context"
+	"errors"
+	"testing"
+
 	"flamingo.me/flamingo-commerce/v3/category/domain"
+	"flamingo.me/flamingo/v3/framework/flamingo"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	"testing"
 )
 
+type (
+	snapshotRepositoryMock struct {
+		ProductRepository
+		snapshotFingerprint string
+		indexedFingerprint  string
+		prepared            bool
+	}
+
+	indexUpdaterMock struct {
+		fingerprint string
+		indexed     bool
+	}
+)
+
+func (m *snapshotRepositoryMock) PrepareIndex(_ context.Context) error {
+	m.prepared = true
+	return nil
+}
+
+func (m *snapshotRepositoryMock) RestoreSnapshot(_ context.Context, sourceFingerprint string) (bool, error) {
+	return m.snapshotFingerprint == sourceFingerprint, nil
+}
+
+func (m *snapshotRepositoryMock) IndexFinished(_ context.Context, sourceFingerprint string) error {
+	m.indexedFingerprint = sourceFingerprint
+	return errors.New("snapshot not writable")
+}
+
+func (m *indexUpdaterMock) Index(_ context.Context, _ *Indexer) error {
+	m.indexed = true
+	return nil
+}
+
+func (m *indexUpdaterMock) SourceFingerprint(_ context.Context) (string, error) {
+	return m.fingerprint, nil
+}
+
 func TestCategoryTreeBuilder_BuildTreeWithoutExplicitGivenRoot(t *testing.T) {
 
 	h := &CategoryTreeBuilder{}
@@ -104,3 +145,30 @@ func TestCategoryTreeToCategoryTeaser(t *testing.T) {
 	assert.Equal(t, "sub", teaser.Code)
 	assert.Equal(t, "root", teaser.Parent.Code)
 }
+
+func TestIndexProcess_RunWithSnapshot(t *testing.T) {
+	run := func(repository *snapshotRepositoryMock, indexUpdater IndexUpdater) error {
+		indexer := new(Indexer).Inject(flamingo.NullLogger{}, repository, nil)
+		indexProcess := new(IndexProcess)
+		indexProcess.Inject(indexUpdater, flamingo.NullLogger{}, indexer, &struct {
+			EnableIndexing bool `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.enableIndexing,optional"`
+		}{EnableIndexing: true})
+		return indexProcess.Run(context.Background())
+	}
+
+	repository := &snapshotRepositoryMock{snapshotFingerprint: "v1"}
+	indexUpdater := &indexUpdaterMock{fingerprint: "v1"}
+	require.NoError(t, run(repository, indexUpdater))
+	assert.False(t, indexUpdater.indexed, "the snapshot of the same source is restored")
+
+	indexUpdater = &indexUpdaterMock{fingerprint: "v2"}
+	require.NoError(t, run(repository, indexUpdater), "a failing snapshot after indexing is only logged")
+	assert.True(t, repository.prepared)
+	assert.True(t, indexUpdater.indexed, "the source changed since the snapshot")
+	assert.Equal(t, "v2", repository.indexedFingerprint)
+
+	repository = &snapshotRepositoryMock{}
+	indexUpdater = &indexUpdaterMock{}
+	require.NoError(t, run(repository, indexUpdater))
+	assert.True(t, indexUpdater.indexed, "without a source fingerprint the snapshot is not restored")
+}
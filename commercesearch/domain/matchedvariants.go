@@ -0,0 +1,43 @@
+package domain
+
+type (
+	// MatchedVariants contains the marketplace codes of the variants that match the filters, keyed by the marketplace code of the configurable hit
+	MatchedVariants map[string][]string
+
+	// MatchedVariantsFilter requests the variants of configurable hits that match the attribute filters, see MatchedVariants
+	MatchedVariantsFilter struct {
+		hitResultCollector
+	}
+)
+
+// matchedVariantsKey is the only key the variants of a hit are collected for
+const matchedVariantsKey = "variants"
+
+// NewMatchedVariantsFilter returns a new MatchedVariantsFilter
+func NewMatchedVariantsFilter() *MatchedVariantsFilter {
+	return &MatchedVariantsFilter{}
+}
+
+// Value of the filter
+func (f *MatchedVariantsFilter) Value() (string, []string) {
+	return "matchedVariants", nil
+}
+
+// AddVariants adds matched variants of the configurable hit with the given marketplace code
+func (f *MatchedVariantsFilter) AddVariants(marketPlaceCode string, variantMarketPlaceCodes []string) {
+	f.add(marketPlaceCode, matchedVariantsKey, variantMarketPlaceCodes)
+}
+
+// MatchedVariants returns the matched variants of the last Find the filter was passed to
+func (f *MatchedVariantsFilter) MatchedVariants() MatchedVariants {
+	matchedVariants := make(MatchedVariants)
+	for marketPlaceCode, results := range f.all() {
+		matchedVariants[marketPlaceCode] = results[matchedVariantsKey]
+	}
+	return matchedVariants
+}
+
+// Variants returns the marketplace codes of the matched variants of a configurable hit
+func (m MatchedVariants) Variants(marketPlaceCode string) []string {
+	return m[marketPlaceCode]
+}
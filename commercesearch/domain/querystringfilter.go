@@ -0,0 +1,28 @@
+package domain
+
+type (
+	// QueryStringFilter searches with the bleve query string syntax (http://blevesearch.com/docs/Query-String-Query/),
+	// e.g. `+title:shoe -color:red`. The syntax can query every indexed field, so the filter is never created from
+	// request parameters: only trusted callers (e.g. an admin tool) should pass it and never with raw user input.
+	// Repositories without query string support ignore it
+	QueryStringFilter struct {
+		query string
+	}
+)
+
+// NewQueryStringFilter returns a new QueryStringFilter
+func NewQueryStringFilter(query string) *QueryStringFilter {
+	return &QueryStringFilter{
+		query: query,
+	}
+}
+
+// Value of the filter
+func (f *QueryStringFilter) Value() (string, []string) {
+	return "querystring", []string{f.query}
+}
+
+// Query of the filter
+func (f *QueryStringFilter) Query() string {
+	return f.query
+}
@@ -0,0 +1,514 @@
+// Package repositorytest provides a conformance test suite for implementations of the commercesearch ProductRepository
+// and CategoryRepository ports
+package repositorytest
+
+import (
+	"context"
+	"testing"
+
+	categoryDomain "flamingo.me/flamingo-commerce/v3/category/domain"
+	priceDomain "flamingo.me/flamingo-commerce/v3/price/domain"
+	productDomain "flamingo.me/flamingo-commerce/v3/product/domain"
+	searchDomain "flamingo.me/flamingo-commerce/v3/search/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"flamingo.me/flamingo-commerce-adapter-standalone/commercesearch/domain"
+)
+
+type (
+	// Factory returns new and empty repositories for a test, the repositories have to be configured with
+	// - a list facet for FacetAttribute, with multiSelect if features.MultiSelectFacets is set
+	// - the category tree facet
+	// - a numeric sort option for SortAttribute (asc and desc)
+	// - products assigned to the parent categories of their categories
+	// - a range facet for RangeFacetAttribute with the ranges "*-20", "20-100" and "100-*" if features.RangeFacets is set
+	// - hidden non saleable products if features.Visibility is set
+	// - collapseVariants "parent" if features.CollapseVariants is set
+	// The CategoryRepository is nil for adapters that only implement the ProductRepository
+	Factory func(t *testing.T, features Capabilities) (domain.ProductRepository, domain.CategoryRepository)
+
+	// Capabilities are the optional features of a repository adapter, the tests of unsupported features are skipped
+	Capabilities struct {
+		// ParentCategories products are found by the parent categories of their categories
+		ParentCategories bool
+		// MultiSelectFacets facets are counted without their own filter
+		MultiSelectFacets bool
+		// RangeFacets facets with numeric ranges
+		RangeFacets bool
+		// Visibility non saleable products can be hidden
+		Visibility bool
+		// CollapseVariants the hits of a configurable product and its variants are collapsed
+		CollapseVariants bool
+	}
+)
+
+const (
+	// FacetAttribute the attribute code of the list facet the repositories have to be configured with
+	FacetAttribute = "color"
+	// SortAttribute the attribute code of the numeric sort option the repositories have to be configured with
+	SortAttribute = "rank"
+	// RangeFacetAttribute the attribute code of the range facet the repositories have to be configured with
+	RangeFacetAttribute = "price"
+)
+
+// Run runs the conformance tests against the repositories of the factory, the tests of features the adapter does
+// not support are skipped
+func Run(t *testing.T, newRepositories Factory, supported Capabilities) {
+	t.Run("ProductRepository", func(t *testing.T) {
+		t.Run("UpdateProducts", func(t *testing.T) { testUpdateProducts(t, newRepositories) })
+		t.Run("FindByMarketplaceCode", func(t *testing.T) { testFindByMarketplaceCode(t, newRepositories) })
+		t.Run("Filter", func(t *testing.T) { testFilter(t, newRepositories) })
+		t.Run("Query", func(t *testing.T) { testQuery(t, newRepositories) })
+		t.Run("Sort", func(t *testing.T) { testSort(t, newRepositories) })
+		t.Run("Pagination", func(t *testing.T) { testPagination(t, newRepositories) })
+		t.Run("Facets", func(t *testing.T) { testFacets(t, newRepositories) })
+		t.Run("ParentCategories", func(t *testing.T) {
+			skipUnsupported(t, supported.ParentCategories)
+			testParentCategories(t, newRepositories)
+		})
+		t.Run("MultiSelectFacets", func(t *testing.T) {
+			skipUnsupported(t, supported.MultiSelectFacets)
+			testMultiSelectFacets(t, newRepositories)
+		})
+		t.Run("RangeFacets", func(t *testing.T) {
+			skipUnsupported(t, supported.RangeFacets)
+			testRangeFacets(t, newRepositories)
+		})
+		t.Run("Visibility", func(t *testing.T) {
+			skipUnsupported(t, supported.Visibility)
+			testVisibility(t, newRepositories)
+		})
+		t.Run("CollapseVariants", func(t *testing.T) {
+			skipUnsupported(t, supported.CollapseVariants)
+			testCollapseVariants(t, newRepositories, supported)
+		})
+	})
+	t.Run("CategoryRepository", func(t *testing.T) {
+		t.Run("CategoryTree", func(t *testing.T) { testCategoryTree(t, newRepositories) })
+	})
+}
+
+// skipUnsupported skips the test of a feature the adapter does not support
+func skipUnsupported(t *testing.T, supported bool) {
+	t.Helper()
+	if !supported {
+		t.Skip("not supported by the adapter")
+	}
+}
+
+// products returns the catalog of the conformance tests:
+//
+//	code   title              color  rank  category
+//	boots  Leather Boots      brown  1     shoes
+//	shirt  Running Shirt      red    2     shirts
+//	shoe   Running Shoe       red    3     shoes
+//	socks  Cotton Socks       white  -     accessories
+//	tee    Cotton Shirt       white  5     shirts
+func products() []productDomain.BasicProduct {
+	return []productDomain.BasicProduct{
+		newProduct("boots", "Leather Boots", "brown", "1", "shoes", 8999),
+		newProduct("shirt", "Running Shirt", "red", "2", "shirts", 2999),
+		newProduct("shoe", "Running Shoe", "red", "3", "shoes", 11999),
+		newProduct("socks", "Cotton Socks", "white", "", "accessories", 599),
+		newProduct("tee", "Cotton Shirt", "white", "5", "shirts", 1499),
+	}
+}
+
+func newProduct(marketPlaceCode string, title string, color string, rank string, category string, price int64) productDomain.SimpleProduct {
+	attributes := productDomain.Attributes{
+		FacetAttribute: productDomain.Attribute{Code: FacetAttribute, Label: color, RawValue: color},
+	}
+	if rank != "" {
+		attributes[SortAttribute] = productDomain.Attribute{Code: SortAttribute, RawValue: rank}
+	}
+	categoryTeaser := productDomain.CategoryTeaser{
+		Code:   category,
+		Name:   category,
+		Path:   "clothing/" + category,
+		Parent: &productDomain.CategoryTeaser{Code: "clothing", Name: "clothing", Path: "clothing"},
+	}
+	return productDomain.SimpleProduct{
+		Identifier: marketPlaceCode,
+		BasicProductData: productDomain.BasicProductData{
+			MarketPlaceCode: marketPlaceCode,
+			Title:           title,
+			MainCategory:    categoryTeaser,
+			Categories:      []productDomain.CategoryTeaser{categoryTeaser},
+			Attributes:      attributes,
+		},
+		Teaser: productDomain.TeaserData{
+			TeaserPrice: productDomain.PriceInfo{Default: priceDomain.NewFromInt(price, 100, "€")},
+		},
+	}
+}
+
+// indexedRepositories returns the repositories of the factory with the catalog indexed
+func indexedRepositories(t *testing.T, newRepositories Factory) (domain.ProductRepository, domain.CategoryRepository) {
+	t.Helper()
+	return indexedRepositoriesWithFeatures(t, newRepositories, Capabilities{}, products())
+}
+
+// indexedRepositoriesWithFeatures returns the repositories of the factory configured with the features and the products indexed
+func indexedRepositoriesWithFeatures(t *testing.T, newRepositories Factory, features Capabilities, products []productDomain.BasicProduct) (domain.ProductRepository, domain.CategoryRepository) {
+	t.Helper()
+	productRepository, categoryRepository := newRepositories(t, features)
+	require.NoError(t, productRepository.PrepareIndex(context.Background()))
+	if categoryRepository != nil {
+		require.NoError(t, categoryRepository.PrepareIndex(context.Background()))
+	}
+	require.NoError(t, productRepository.UpdateProducts(context.Background(), products))
+	if categoryRepository != nil {
+		var categoryTeasers []productDomain.CategoryTeaser
+		for _, product := range products {
+			categoryTeasers = append(categoryTeasers, product.BaseData().Categories...)
+		}
+		require.NoError(t, categoryRepository.UpdateByCategoryTeasers(context.Background(), categoryTeasers))
+	}
+	return productRepository, categoryRepository
+}
+
+// find returns the marketplace codes of the hits
+func find(t *testing.T, productRepository domain.ProductRepository, filters ...searchDomain.Filter) ([]string, *productDomain.SearchResult) {
+	t.Helper()
+	result, err := productRepository.Find(context.Background(), filters...)
+	require.NoError(t, err)
+	marketPlaceCodes := []string{}
+	for _, hit := range result.Hits {
+		marketPlaceCodes = append(marketPlaceCodes, hit.BaseData().MarketPlaceCode)
+	}
+	return marketPlaceCodes, result
+}
+
+func testUpdateProducts(t *testing.T, newRepositories Factory) {
+	productRepository, _ := newRepositories(t, Capabilities{})
+	require.NoError(t, productRepository.PrepareIndex(context.Background()))
+	require.NoError(t, productRepository.UpdateProducts(context.Background(), products()))
+	assert.Equal(t, int64(len(products())), productRepository.DocumentsCount())
+
+	assert.Error(t, productRepository.UpdateProducts(context.Background(), []productDomain.BasicProduct{productDomain.SimpleProduct{}}), "products need a marketplace code")
+
+	updated := newProduct("shirt", "Trail Shirt", "green", "2", "shirts", 2999)
+	require.NoError(t, productRepository.UpdateProducts(context.Background(), []productDomain.BasicProduct{updated}))
+	assert.Equal(t, int64(len(products())), productRepository.DocumentsCount(), "an update replaces the product")
+
+	product, err := productRepository.FindByMarketplaceCode(context.Background(), "shirt")
+	require.NoError(t, err)
+	assert.Equal(t, "Trail Shirt", product.BaseData().Title)
+
+	marketPlaceCodes, _ := find(t, productRepository, searchDomain.NewKeyValueFilter(FacetAttribute, []string{"red"}))
+	assert.ElementsMatch(t, []string{"shoe"}, marketPlaceCodes, "the previous values of an updated product do not match")
+	marketPlaceCodes, _ = find(t, productRepository, searchDomain.NewKeyValueFilter(FacetAttribute, []string{"green"}))
+	assert.ElementsMatch(t, []string{"shirt"}, marketPlaceCodes)
+}
+
+func testFindByMarketplaceCode(t *testing.T, newRepositories Factory) {
+	productRepository, _ := indexedRepositories(t, newRepositories)
+
+	product, err := productRepository.FindByMarketplaceCode(context.Background(), "shoe")
+	require.NoError(t, err)
+	assert.Equal(t, "shoe", product.BaseData().MarketPlaceCode)
+	assert.Equal(t, "Running Shoe", product.BaseData().Title)
+	assert.Equal(t, "red", product.BaseData().Attributes[FacetAttribute].Value())
+	assert.Equal(t, "shoes", product.BaseData().MainCategory.Code)
+	assert.Equal(t, 119.99, product.TeaserData().TeaserPrice.Default.FloatAmount())
+
+	_, err = productRepository.FindByMarketplaceCode(context.Background(), "unknown")
+	assert.Error(t, err)
+}
+
+func testFilter(t *testing.T, newRepositories Factory) {
+	productRepository, _ := indexedRepositories(t, newRepositories)
+
+	marketPlaceCodes, result := find(t, productRepository)
+	assert.ElementsMatch(t, []string{"boots", "shirt", "shoe", "socks", "tee"}, marketPlaceCodes, "without filters all products match")
+	assert.Equal(t, 5, result.SearchMeta.NumResults)
+
+	marketPlaceCodes, _ = find(t, productRepository, searchDomain.NewKeyValueFilter(FacetAttribute, []string{"red", "brown"}))
+	assert.ElementsMatch(t, []string{"boots", "shirt", "shoe"}, marketPlaceCodes, "the values of a filter are alternatives")
+
+	marketPlaceCodes, _ = find(t, productRepository, searchDomain.NewKeyValueFilter(FacetAttribute, []string{"red", "white"}), searchDomain.NewKeyValueFilter("category", []string{"shirts"}))
+	assert.ElementsMatch(t, []string{"shirt", "tee"}, marketPlaceCodes, "all filters have to match")
+
+	marketPlaceCodes, _ = find(t, productRepository, categoryDomain.NewCategoryFacet("shoes"))
+	assert.ElementsMatch(t, []string{"boots", "shoe"}, marketPlaceCodes)
+
+	marketPlaceCodes, _ = find(t, productRepository, domain.NewExcludeFilter(FacetAttribute, []string{"red", "white"}))
+	assert.ElementsMatch(t, []string{"boots"}, marketPlaceCodes)
+
+	marketPlaceCodes, _ = find(t, productRepository, searchDomain.NewKeyValueFilter(FacetAttribute, []string{"white"}), domain.NewExcludeFilter("category", []string{"accessories"}))
+	assert.ElementsMatch(t, []string{"tee"}, marketPlaceCodes)
+
+	marketPlaceCodes, result = find(t, productRepository, searchDomain.NewKeyValueFilter(FacetAttribute, []string{"purple"}))
+	assert.Empty(t, marketPlaceCodes)
+	assert.Equal(t, 0, result.SearchMeta.NumResults)
+}
+
+func testQuery(t *testing.T, newRepositories Factory) {
+	productRepository, _ := indexedRepositories(t, newRepositories)
+
+	marketPlaceCodes, _ := find(t, productRepository, searchDomain.NewQueryFilter("running"))
+	assert.ElementsMatch(t, []string{"shirt", "shoe"}, marketPlaceCodes)
+
+	marketPlaceCodes, _ = find(t, productRepository, searchDomain.NewQueryFilter("Cotton"), searchDomain.NewKeyValueFilter("category", []string{"shirts"}))
+	assert.ElementsMatch(t, []string{"tee"}, marketPlaceCodes, "queries are case insensitive and combined with filters")
+
+	marketPlaceCodes, _ = find(t, productRepository, searchDomain.NewQueryFilter("running shirt"))
+	require.NotEmpty(t, marketPlaceCodes)
+	assert.Equal(t, "shirt", marketPlaceCodes[0], "the product matching all terms is ranked first")
+}
+
+func testSort(t *testing.T, newRepositories Factory) {
+	productRepository, _ := indexedRepositories(t, newRepositories)
+
+	marketPlaceCodes, _ := find(t, productRepository, searchDomain.NewSortFilter(SortAttribute, searchDomain.SortDirectionAscending))
+	assert.Equal(t, []string{"boots", "shirt", "shoe", "tee", "socks"}, marketPlaceCodes, "products without a value are sorted last")
+
+	marketPlaceCodes, _ = find(t, productRepository, searchDomain.NewSortFilter(SortAttribute, searchDomain.SortDirectionDescending))
+	assert.Equal(t, []string{"tee", "shoe", "shirt", "boots", "socks"}, marketPlaceCodes, "products without a value are sorted last in both directions")
+
+	marketPlaceCodes, _ = find(t, productRepository, searchDomain.NewSortFilter("price", searchDomain.SortDirectionAscending))
+	assert.Equal(t, []string{"socks", "tee", "shirt", "boots", "shoe"}, marketPlaceCodes)
+
+	marketPlaceCodes, _ = find(t, productRepository, searchDomain.NewKeyValueFilter(FacetAttribute, []string{"red"}), searchDomain.NewSortFilter("price", searchDomain.SortDirectionDescending))
+	assert.Equal(t, []string{"shoe", "shirt"}, marketPlaceCodes, "sorting applies to the filtered products")
+
+	defaultOrder, _ := find(t, productRepository)
+	marketPlaceCodes, _ = find(t, productRepository, searchDomain.NewSortFilter(FacetAttribute, searchDomain.SortDirectionAscending))
+	assert.Equal(t, defaultOrder, marketPlaceCodes, "attributes without a sort config fall back to the default order")
+}
+
+func testPagination(t *testing.T, newRepositories Factory) {
+	productRepository, _ := indexedRepositories(t, newRepositories)
+
+	sortFilter := searchDomain.NewSortFilter("price", searchDomain.SortDirectionAscending)
+	marketPlaceCodes, result := find(t, productRepository, sortFilter, searchDomain.NewPaginationPageSizeFilter(2))
+	assert.Equal(t, []string{"socks", "tee"}, marketPlaceCodes, "without a page the first page is returned")
+	assert.Equal(t, 5, result.SearchMeta.NumResults, "the number of results counts all pages")
+	assert.Equal(t, 3, result.SearchMeta.NumPages)
+	assert.Equal(t, 1, result.SearchMeta.Page)
+
+	marketPlaceCodes, result = find(t, productRepository, sortFilter, searchDomain.NewPaginationPageSizeFilter(2), searchDomain.NewPaginationPageFilter(2))
+	assert.Equal(t, []string{"shirt", "boots"}, marketPlaceCodes)
+	assert.Equal(t, 2, result.SearchMeta.Page)
+
+	marketPlaceCodes, _ = find(t, productRepository, sortFilter, searchDomain.NewPaginationPageSizeFilter(2), searchDomain.NewPaginationPageFilter(3))
+	assert.Equal(t, []string{"shoe"}, marketPlaceCodes, "the last page contains the remaining products")
+
+	marketPlaceCodes, result = find(t, productRepository, sortFilter, searchDomain.NewPaginationPageSizeFilter(2), searchDomain.NewPaginationPageFilter(4))
+	assert.Empty(t, marketPlaceCodes, "pages after the last page are empty")
+	assert.Equal(t, 5, result.SearchMeta.NumResults)
+}
+
+func testFacets(t *testing.T, newRepositories Factory) {
+	productRepository, _ := indexedRepositories(t, newRepositories)
+
+	_, result := find(t, productRepository, searchDomain.NewKeyValueFilter("category", []string{"shirts", "shoes"}))
+	require.Contains(t, result.Facets, FacetAttribute)
+	facet := result.Facets[FacetAttribute]
+	assert.Equal(t, searchDomain.ListFacet, facet.Type)
+	assert.Equal(t, map[string]int64{"red": 2, "white": 1, "brown": 1}, facetCounts(facet), "the facet counts the matching products")
+
+	_, result = find(t, productRepository, searchDomain.NewKeyValueFilter(FacetAttribute, []string{"red"}))
+	for _, item := range result.Facets[FacetAttribute].Items {
+		assert.Equal(t, item.Value == "red", item.Selected, "the filtered value is selected")
+	}
+
+	_, result = find(t, productRepository)
+	require.Contains(t, result.Facets, "category")
+	assert.Equal(t, searchDomain.TreeFacet, result.Facets["category"].Type)
+	categoryCounts := make(map[string]int64)
+	var collectCounts func(items []*searchDomain.FacetItem)
+	collectCounts = func(items []*searchDomain.FacetItem) {
+		for _, item := range items {
+			categoryCounts[item.Value] = item.Count
+			collectCounts(item.Items)
+		}
+	}
+	collectCounts(result.Facets["category"].Items)
+	assert.Equal(t, int64(2), categoryCounts["shirts"])
+	assert.Equal(t, int64(2), categoryCounts["shoes"])
+	assert.Equal(t, int64(1), categoryCounts["accessories"])
+}
+
+func testParentCategories(t *testing.T, newRepositories Factory) {
+	productRepository, _ := indexedRepositories(t, newRepositories)
+
+	marketPlaceCodes, _ := find(t, productRepository, searchDomain.NewKeyValueFilter("category", []string{"clothing"}))
+	assert.ElementsMatch(t, []string{"boots", "shirt", "shoe", "socks", "tee"}, marketPlaceCodes, "products are found by the parent category")
+
+	marketPlaceCodes, _ = find(t, productRepository, categoryDomain.NewCategoryFacet("clothing"), searchDomain.NewKeyValueFilter(FacetAttribute, []string{"red"}))
+	assert.ElementsMatch(t, []string{"shirt", "shoe"}, marketPlaceCodes)
+}
+
+func testMultiSelectFacets(t *testing.T, newRepositories Factory) {
+	productRepository, _ := indexedRepositoriesWithFeatures(t, newRepositories, Capabilities{MultiSelectFacets: true}, products())
+
+	marketPlaceCodes, result := find(t, productRepository, searchDomain.NewKeyValueFilter(FacetAttribute, []string{"red"}))
+	assert.ElementsMatch(t, []string{"shirt", "shoe"}, marketPlaceCodes)
+	assert.Equal(t, map[string]int64{"red": 2, "white": 2, "brown": 1}, facetCounts(result.Facets[FacetAttribute]), "the facet is counted without its own filter")
+
+	_, result = find(t, productRepository, searchDomain.NewKeyValueFilter(FacetAttribute, []string{"red"}), searchDomain.NewKeyValueFilter("category", []string{"shoes"}))
+	assert.Equal(t, map[string]int64{"red": 1, "brown": 1}, facetCounts(result.Facets[FacetAttribute]), "the other filters still apply")
+}
+
+func testRangeFacets(t *testing.T, newRepositories Factory) {
+	productRepository, _ := indexedRepositoriesWithFeatures(t, newRepositories, Capabilities{RangeFacets: true}, products())
+
+	_, result := find(t, productRepository)
+	require.Contains(t, result.Facets, RangeFacetAttribute)
+	facet := result.Facets[RangeFacetAttribute]
+	assert.Equal(t, searchDomain.RangeFacet, facet.Type)
+	assert.Equal(t, map[string]int64{"*-20": 2, "20-100": 2, "100-*": 1}, facetCounts(facet))
+
+	for _, item := range facet.Items {
+		_, filtered := find(t, productRepository, searchDomain.NewKeyValueFilter(RangeFacetAttribute, []string{item.Value}))
+		assert.Equal(t, item.Count, int64(filtered.SearchMeta.NumResults), "the range %s counts the products of its filter", item.Value)
+	}
+
+	marketPlaceCodes, _ := find(t, productRepository, searchDomain.NewKeyValueFilter(RangeFacetAttribute, []string{"*-20", "100-*"}))
+	assert.ElementsMatch(t, []string{"shoe", "socks", "tee"}, marketPlaceCodes, "the ranges of a filter are alternatives")
+}
+
+func testVisibility(t *testing.T, newRepositories Factory) {
+	var catalog []productDomain.BasicProduct
+	for _, product := range products() {
+		simpleProduct := product.(productDomain.SimpleProduct)
+		// socks are not saleable
+		simpleProduct.Saleable.IsSaleable = simpleProduct.MarketPlaceCode != "socks"
+		catalog = append(catalog, simpleProduct)
+	}
+	productRepository, _ := indexedRepositoriesWithFeatures(t, newRepositories, Capabilities{Visibility: true}, catalog)
+
+	marketPlaceCodes, result := find(t, productRepository)
+	assert.ElementsMatch(t, []string{"boots", "shirt", "shoe", "tee"}, marketPlaceCodes, "non saleable products are hidden")
+	assert.Equal(t, 4, result.SearchMeta.NumResults)
+
+	marketPlaceCodes, _ = find(t, productRepository, searchDomain.NewKeyValueFilter("category", []string{"accessories"}))
+	assert.Empty(t, marketPlaceCodes)
+}
+
+func testCollapseVariants(t *testing.T, newRepositories Factory, supported Capabilities) {
+	// the configurable jacket is in the category accessories, its variants are shirts
+	jacket := productDomain.ConfigurableProduct{
+		Identifier: "jacket",
+		BasicProductData: productDomain.BasicProductData{
+			MarketPlaceCode: "jacket",
+			Title:           "Rain Jacket",
+			Categories:      newProduct("jacket", "", "", "", "accessories", 0).Categories,
+		},
+		VariantVariationAttributes: []string{FacetAttribute},
+	}
+	catalog := products()
+	for _, color := range []string{"red", "white"} {
+		variant := newProduct("jacket-"+color, "Rain Jacket", color, "", "shirts", 4999)
+		jacket.Variants = append(jacket.Variants, productDomain.Variant{BasicProductData: variant.BasicProductData, Saleable: variant.Saleable})
+		catalog = append(catalog, variant)
+	}
+	catalog = append([]productDomain.BasicProduct{jacket}, catalog...)
+	productRepository, _ := indexedRepositoriesWithFeatures(t, newRepositories, Capabilities{CollapseVariants: true}, catalog)
+	jacketVariants := []string{"jacket-red", "jacket-white"}
+
+	marketPlaceCodes, result := find(t, productRepository, searchDomain.NewQueryFilter("jacket"))
+	assert.Equal(t, []string{"jacket"}, marketPlaceCodes, "the configurable product replaces its variants")
+	assert.Equal(t, 1, result.SearchMeta.NumResults)
+
+	marketPlaceCodes, result = find(t, productRepository, searchDomain.NewKeyValueFilter(FacetAttribute, []string{"red"}))
+	assert.ElementsMatch(t, []string{"jacket", "shirt", "shoe"}, marketPlaceCodes)
+	assert.Equal(t, 3, result.SearchMeta.NumResults, "the configurable product and its variants count once")
+
+	t.Run("out-of-category parent", func(t *testing.T) {
+		categoryFacet := categoryDomain.NewCategoryFacet("shirts")
+		for name, filter := range map[string]searchDomain.Filter{
+			"category filter": searchDomain.NewKeyValueFilter("category", []string{"shirts"}),
+			"category facet":  categoryFacet,
+		} {
+			marketPlaceCodes, result := find(t, productRepository, filter)
+			assert.Equal(t, 3, result.SearchMeta.NumResults, name)
+			assert.NotContains(t, marketPlaceCodes, "jacket", "%s: the configurable product is not in the category", name)
+			assert.Len(t, intersection(marketPlaceCodes, jacketVariants), 1, "%s: the best variant is kept", name)
+		}
+
+		marketPlaceCodes, _ := find(t, productRepository, searchDomain.NewQueryFilter("jacket"), domain.NewExcludeFilter("category", []string{"accessories"}))
+		assert.Len(t, marketPlaceCodes, 1)
+		assert.Subset(t, jacketVariants, marketPlaceCodes, "the configurable product is excluded by its category")
+	})
+
+	t.Run("hidden parent", func(t *testing.T) {
+		skipUnsupported(t, supported.Visibility)
+		// the variants of the configurable coat are not saleable, the indexed variant products are
+		coat := productDomain.ConfigurableProduct{
+			Identifier: "coat",
+			BasicProductData: productDomain.BasicProductData{
+				MarketPlaceCode: "coat",
+				Title:           "Winter Coat",
+			},
+			VariantVariationAttributes: []string{FacetAttribute},
+		}
+		catalog := []productDomain.BasicProduct{coat}
+		for _, color := range []string{"brown", "white"} {
+			variant := newProduct("coat-"+color, "Winter Coat", color, "", "shirts", 19999)
+			coat.Variants = append(coat.Variants, productDomain.Variant{BasicProductData: variant.BasicProductData, Saleable: variant.Saleable})
+			variant.Saleable.IsSaleable = true
+			catalog = append(catalog, variant)
+		}
+		catalog[0] = coat
+		productRepository, _ := indexedRepositoriesWithFeatures(t, newRepositories, Capabilities{Visibility: true, CollapseVariants: true}, catalog)
+
+		marketPlaceCodes, result := find(t, productRepository, searchDomain.NewQueryFilter("coat"))
+		assert.Equal(t, 1, result.SearchMeta.NumResults)
+		assert.Len(t, marketPlaceCodes, 1)
+		assert.Subset(t, []string{"coat-brown", "coat-white"}, marketPlaceCodes, "the hidden configurable product is not returned")
+	})
+}
+
+// intersection returns the codes that are part of both lists
+func intersection(codes []string, other []string) []string {
+	var result []string
+	for _, code := range codes {
+		for _, otherCode := range other {
+			if code == otherCode {
+				result = append(result, code)
+			}
+		}
+	}
+	return result
+}
+
+// facetCounts returns the count of each facet value
+func facetCounts(facet searchDomain.Facet) map[string]int64 {
+	counts := make(map[string]int64)
+	for _, item := range facet.Items {
+		counts[item.Value] = item.Count
+	}
+	return counts
+}
+
+func testCategoryTree(t *testing.T, newRepositories Factory) {
+	_, categoryRepository := indexedRepositories(t, newRepositories)
+	if categoryRepository == nil {
+		t.Skip("no CategoryRepository")
+	}
+
+	category, err := categoryRepository.Category(context.Background(), "shirts")
+	require.NoError(t, err)
+	assert.Equal(t, "shirts", category.Code())
+	assert.Equal(t, "shirts", category.Name())
+
+	_, err = categoryRepository.Category(context.Background(), "unknown")
+	assert.Error(t, err)
+
+	tree, err := categoryRepository.CategoryTree(context.Background(), "")
+	require.NoError(t, err)
+	assert.Equal(t, "clothing", tree.Code(), "the root of the tree is returned without a code")
+	var subTreeCodes []string
+	for _, subTree := range tree.SubTrees() {
+		subTreeCodes = append(subTreeCodes, subTree.Code())
+	}
+	assert.ElementsMatch(t, []string{"shoes", "shirts", "accessories"}, subTreeCodes)
+
+	tree, err = categoryRepository.CategoryTree(context.Background(), "shoes")
+	require.NoError(t, err)
+	assert.Equal(t, "shoes", tree.Code())
+	assert.Empty(t, tree.SubTrees())
+}
@@ -0,0 +1,74 @@
+package domain
+
+import (
+	"context"
+	"strings"
+
+	categoryDomain "flamingo.me/flamingo-commerce/v3/category/domain"
+	product "flamingo.me/flamingo-commerce/v3/product/domain"
+)
+
+type (
+	// SuggestRepository port for repositories that support search-as-you-type
+	SuggestRepository interface {
+		// Suggest returns up to limit products, categories and query completions matching the given prefix
+		Suggest(ctx context.Context, prefix string, limit int) (*SuggestResult, error)
+	}
+
+	// SuggestResult contains the autocomplete matches for a prefix
+	SuggestResult struct {
+		Products    []product.BasicProduct
+		Categories  []categoryDomain.Category
+		Completions []string
+	}
+
+	// SuggestService provides search-as-you-type suggestions for the configured repository
+	SuggestService struct {
+		suggestRepository SuggestRepository
+		minPrefixLength   int
+		defaultLimit      int
+		maxLimit          int
+	}
+)
+
+// Inject dependencies
+func (s *SuggestService) Inject(suggestRepository SuggestRepository, config *struct {
+	MinPrefixLength float64 `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.suggest.minPrefixLength,optional"`
+	DefaultLimit    float64 `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.suggest.defaultLimit,optional"`
+	MaxLimit        float64 `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.suggest.maxLimit,optional"`
+}) *SuggestService {
+	s.suggestRepository = suggestRepository
+	s.minPrefixLength = 2
+	s.defaultLimit = 5
+	s.maxLimit = 20
+	if config != nil {
+		if config.MinPrefixLength > 0 {
+			s.minPrefixLength = int(config.MinPrefixLength)
+		}
+		if config.DefaultLimit > 0 {
+			s.defaultLimit = int(config.DefaultLimit)
+		}
+		if config.MaxLimit > 0 {
+			s.maxLimit = int(config.MaxLimit)
+		}
+	}
+
+	return s
+}
+
+// Suggest returns the suggestions for the given prefix, a limit <= 0 uses the configured default limit
+func (s *SuggestService) Suggest(ctx context.Context, prefix string, limit int) (*SuggestResult, error) {
+	prefix = strings.TrimLeft(prefix, " ")
+	if len([]rune(strings.TrimSpace(prefix))) < s.minPrefixLength {
+		return &SuggestResult{}, nil
+	}
+
+	if limit <= 0 {
+		limit = s.defaultLimit
+	}
+	if limit > s.maxLimit {
+		limit = s.maxLimit
+	}
+
+	return s.suggestRepository.Suggest(ctx, prefix, limit)
+}
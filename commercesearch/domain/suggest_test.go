@@ -0,0 +1,39 @@
+package domain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type suggestRepositoryMock struct {
+	prefix string
+	limit  int
+}
+
+func (m *suggestRepositoryMock) Suggest(_ context.Context, prefix string, limit int) (*SuggestResult, error) {
+	m.prefix = prefix
+	m.limit = limit
+	return &SuggestResult{Completions: []string{prefix}}, nil
+}
+
+func TestSuggestService_Suggest(t *testing.T) {
+	repository := &suggestRepositoryMock{}
+	service := new(SuggestService).Inject(repository, nil)
+
+	result, err := service.Suggest(context.Background(), " s", 0)
+	require.NoError(t, err)
+	assert.Empty(t, result.Completions, "prefix shorter than minPrefixLength")
+	assert.Equal(t, "", repository.prefix)
+
+	_, err = service.Suggest(context.Background(), "  sho", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "sho", repository.prefix)
+	assert.Equal(t, 5, repository.limit, "default limit")
+
+	_, err = service.Suggest(context.Background(), "sho", 100)
+	require.NoError(t, err)
+	assert.Equal(t, 20, repository.limit, "limit capped to maxLimit")
+}
@@ -1,7 +1,6 @@
 package commercesearch
 
 import (
-	"bytes"
 	"context"
 	"encoding/gob"
 	"errors"
@@ -10,6 +9,8 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
+	"unicode"
 
 	categoryDomain "flamingo.me/flamingo-commerce/v3/category/domain"
 	productDomain "flamingo.me/flamingo-commerce/v3/product/domain"
@@ -18,10 +19,17 @@ import (
 	"flamingo.me/flamingo/v3/framework/flamingo"
 	"github.com/blevesearch/bleve"
 	"github.com/blevesearch/bleve/analysis"
+	"github.com/blevesearch/bleve/analysis/token/edgengram"
+	"github.com/blevesearch/bleve/analysis/token/lowercase"
+	unicodeTokenizer "github.com/blevesearch/bleve/analysis/tokenizer/unicode"
 	"github.com/blevesearch/bleve/analysis/tokenizer/whitespace"
 	"github.com/blevesearch/bleve/document"
 	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/numeric"
 	"github.com/blevesearch/bleve/search"
+	"github.com/blevesearch/bleve/search/highlight/format/html"
+	simpleFragmenter "github.com/blevesearch/bleve/search/highlight/fragmenter/simple"
+	simpleHighlighter "github.com/blevesearch/bleve/search/highlight/highlighter/simple"
 	"github.com/blevesearch/bleve/search/query"
 
 	"flamingo.me/flamingo-commerce-adapter-standalone/commercesearch/domain"
@@ -37,21 +45,59 @@ type (
 		cacheMutex                       sync.RWMutex
 		cachedCategoryTree               categoryDomain.Tree
 		cachedCategories                 map[string]categoryDomain.Category
+		facetValueLabelsMutex            sync.RWMutex
+		facetValueLabels                 map[string]map[string]string
 		enableCategoryFacet              bool
+		categoryFacetSize                int
 		facetConfig                      []facetConfig
+		categoryFacetConfig              []categoryFacetConfig
 		sortConfig                       []sortConfig
+		queryConfig                      queryConfig
+		highlightConfig                  highlightConfig
+		visibilityConfig                 visibilityConfig
+		collapseVariants                 string
+		variantParents                   variantParentIndex
 	}
 
-	facetConfig struct {
-		AttributeCode string
-		Amount        int
+	// queryConfig defines how the human query of a QueryFilter is turned into a bleve query
+	queryConfig struct {
+		Operator              string
+		MinimumShouldMatch    int
+		TypoTolerance         bool
+		TypoPrefixLength      int
+		MinTermLengthOneTypo  int
+		MinTermLengthTwoTypos int
+		SuggestionThreshold   int
 	}
 
-	sortConfig struct {
+	// highlightConfig defines which fields are highlighted and how the fragments look like
+	highlightConfig struct {
+		Attributes   []string
+		FragmentSize int
+		MaxFragments int
+		MarkupBefore string
+		MarkupAfter  string
+	}
+
+	facetConfig struct {
 		AttributeCode string
-		AttributeType string
-		Asc           bool
-		Desc          bool
+		Amount        int
+		// Type is either facetTypeList (default) or facetTypeRange
+		Type string
+		// Ranges are the buckets of a range facet, without ranges Buckets are computed from min and max
+		Ranges  []facetRange
+		Buckets int
+		// MultiSelect facets are counted without their own filter, so further values can be selected
+		MultiSelect bool
+		// Label of the facet (e.g. a translation key), defaults to the attribute code
+		Label    string
+		Position int
+		// Sort of the facet items: facetSortCount (default), facetSortAlpha or facetSortCustom (by Order)
+		Sort  string
+		Order []string
+		// MinCount hides facet items with a lower count
+		MinCount        int
+		HideSingleValue bool
 	}
 
 	// bleveDocument envelop for indexed entities
@@ -65,23 +111,40 @@ const (
 	attributeTypeNumeric         = "numeric"
 	attributeTypeText            = "text"
 	attributeTypeBool            = "bool"
+	queryOperatorAnd             = "and"
+	queryOperatorOr              = "or"
 	productType                  = "product"
 	categoryType                 = "category"
 	categoryIDPrefix             = "cat_"
 	sourceFieldName              = "_source"
 	typeFieldName                = "_type"
 	fieldPrefixInIndexedDocument = "Product."
+	priceAttributeCode           = "price"
+
+	visibilitySaleableFieldName     = fieldPrefixInIndexedDocument + "Visibility.Saleable"
+	visibilitySaleableFromFieldName = fieldPrefixInIndexedDocument + "Visibility.SaleableFrom"
+	visibilitySaleableToFieldName   = fieldPrefixInIndexedDocument + "Visibility.SaleableTo"
+	visibilityInStockFieldName      = fieldPrefixInIndexedDocument + "Visibility.InStock"
+	collapseKeyFieldName            = fieldPrefixInIndexedDocument + "CollapseKey"
+	defaultRangeFacetBuckets        = 5
+	defaultCategoryFacetSize        = 100
 )
 
 var (
 	_ domain.ProductRepository  = &BleveRepository{}
 	_ domain.CategoryRepository = &BleveRepository{}
+	_ domain.SuggestRepository  = &BleveRepository{}
 	_ mapping.Classifier        = &bleveDocument{}
 )
 
 func init() {
 	gob.Register(&productDomain.SimpleProduct{})
 	gob.Register(&productDomain.ConfigurableProduct{})
+	// multi value attributes (e.g. csv attributesToSplit)
+	gob.Register([]interface{}{})
+	// entries of multi value attributes with their own label
+	gob.Register(map[string]interface{}{})
+	gob.Register(map[string]string{})
 }
 
 func (b *bleveDocument) Type() string {
@@ -101,13 +164,47 @@ func (b *bleveDocument) getTypeField() *document.TextField {
 func (r *BleveRepository) Inject(logger flamingo.Logger, config *struct {
 	AssignProductsToParentCategories bool         `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.productsToParentCategories,optional"`
 	EnableCategoryFacet              bool         `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.enableCategoryFacet,optional"`
+	CategoryFacetSize                float64      `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.categoryFacetSize,optional"`
 	FacetConfig                      config.Slice `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.facetConfig"`
+	CategoryFacetConfig              config.Slice `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.categoryFacetConfig,optional"`
 	SortConfig                       config.Slice `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.sortConfig"`
+	QueryOperator                    string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.query.operator,optional"`
+	QueryMinimumShouldMatch          float64      `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.query.minimumShouldMatch,optional"`
+	Query                            config.Map   `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.query,optional"`
+	QueryMinTermLengthOneTypo        float64      `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.query.minTermLengthOneTypo,optional"`
+	QueryMinTermLengthTwoTypos       float64      `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.query.minTermLengthTwoTypos,optional"`
+	QuerySuggestionThreshold         float64      `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.query.suggestionThreshold,optional"`
+	HighlightAttributes              config.Slice `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.highlight.attributes,optional"`
+	HighlightFragmentSize            float64      `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.highlight.fragmentSize,optional"`
+	HighlightMaxFragments            float64      `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.highlight.maxFragments,optional"`
+	HighlightMarkupBefore            string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.highlight.markupBefore,optional"`
+	HighlightMarkupAfter             string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.highlight.markupAfter,optional"`
+	VisibilityNonSaleable            string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.visibility.nonSaleable,optional"`
+	VisibilityOutOfStock             string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.visibility.outOfStock,optional"`
+	CollapseVariants                 string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.collapseVariants,optional"`
 }) *BleveRepository {
 	r.logger = logger.WithField(flamingo.LogKeyModule, "flamingoCommerceAdapterStandalone.commercesearch").WithField(flamingo.LogKeyCategory, "bleve")
+	r.queryConfig = queryConfig{
+		Operator:              queryOperatorOr,
+		MinimumShouldMatch:    1,
+		TypoTolerance:         true,
+		TypoPrefixLength:      1,
+		MinTermLengthOneTypo:  4,
+		MinTermLengthTwoTypos: 8,
+	}
+	r.categoryFacetSize = defaultCategoryFacetSize
+	r.highlightConfig = highlightConfig{
+		FragmentSize: 100,
+		MaxFragments: 1,
+		MarkupBefore: "<mark>",
+		MarkupAfter:  "</mark>",
+	}
 	if config != nil {
 		r.assignProductsToParentCategories = config.AssignProductsToParentCategories
 		r.enableCategoryFacet = config.EnableCategoryFacet
+		if config.CategoryFacetSize > 0 {
+			r.categoryFacetSize = int(config.CategoryFacetSize)
+		}
 		var facetConfig []facetConfig
 		err := config.FacetConfig.MapInto(&facetConfig)
 		if err != nil {
@@ -115,12 +212,64 @@ func (r *BleveRepository) Inject(logger flamingo.Logger, config *struct {
 		}
 		r.facetConfig = facetConfig
 
+		var categoryFacetConfig []categoryFacetConfig
+		err = config.CategoryFacetConfig.MapInto(&categoryFacetConfig)
+		if err != nil {
+			panic(err)
+		}
+		r.categoryFacetConfig = categoryFacetConfig
+
 		var sortConfig []sortConfig
 		err = config.SortConfig.MapInto(&sortConfig)
 		if err != nil {
 			panic(err)
 		}
 		r.sortConfig = sortConfig
+
+		if config.QueryOperator != "" {
+			r.queryConfig.Operator = config.QueryOperator
+		}
+		if config.QueryMinimumShouldMatch > 0 {
+			r.queryConfig.MinimumShouldMatch = int(config.QueryMinimumShouldMatch)
+		}
+		// false and 0 are valid values, so the keys are looked up to keep the defaults if they are not set
+		if typoTolerance, ok := config.Query["typoTolerance"].(bool); ok {
+			r.queryConfig.TypoTolerance = typoTolerance
+		}
+		if typoPrefixLength, ok := config.Query["typoPrefixLength"].(float64); ok {
+			r.queryConfig.TypoPrefixLength = int(typoPrefixLength)
+		}
+		if config.QueryMinTermLengthOneTypo > 0 {
+			r.queryConfig.MinTermLengthOneTypo = int(config.QueryMinTermLengthOneTypo)
+		}
+		if config.QueryMinTermLengthTwoTypos > 0 {
+			r.queryConfig.MinTermLengthTwoTypos = int(config.QueryMinTermLengthTwoTypos)
+		}
+		r.queryConfig.SuggestionThreshold = int(config.QuerySuggestionThreshold)
+
+		var highlightAttributes []string
+		err = config.HighlightAttributes.MapInto(&highlightAttributes)
+		if err != nil {
+			panic(err)
+		}
+		r.highlightConfig.Attributes = highlightAttributes
+		if config.HighlightFragmentSize > 0 {
+			r.highlightConfig.FragmentSize = int(config.HighlightFragmentSize)
+		}
+		if config.HighlightMaxFragments > 0 {
+			r.highlightConfig.MaxFragments = int(config.HighlightMaxFragments)
+		}
+		if config.HighlightMarkupBefore != "" {
+			r.highlightConfig.MarkupBefore = config.HighlightMarkupBefore
+		}
+		if config.HighlightMarkupAfter != "" {
+			r.highlightConfig.MarkupAfter = config.HighlightMarkupAfter
+		}
+		r.visibilityConfig = visibilityConfig{
+			NonSaleable: config.VisibilityNonSaleable,
+			OutOfStock:  config.VisibilityOutOfStock,
+		}
+		r.collapseVariants = config.CollapseVariants
 	}
 	return r
 }
@@ -221,6 +370,13 @@ func (r *BleveRepository) UpdateProducts(_ context.Context, products []productDo
 		if err != nil {
 			return err
 		}
+		r.variantParents.add(product)
+		// variants indexed before their configurable product get the collapse key of the configurable product
+		variantDocuments, err := r.variantsWithChangedCollapseKey(index, product)
+		if err != nil {
+			return err
+		}
+		bleveDocuments = append(bleveDocuments, variantDocuments...)
 		// index to bleve
 		batch := index.NewBatch()
 		for _, bleveDocument := range bleveDocuments {
@@ -238,6 +394,33 @@ func (r *BleveRepository) UpdateProducts(_ context.Context, products []productDo
 	return nil
 }
 
+// variantsWithChangedCollapseKey returns the documents of the already indexed variants of the configurable product
+// which were indexed with another collapse key
+func (r *BleveRepository) variantsWithChangedCollapseKey(index bleve.Index, product productDomain.BasicProduct) ([]*document.Document, error) {
+	var bleveDocuments []*document.Document
+	for _, variant := range productVariants(product) {
+		searchRequest := bleve.NewSearchRequest(query.NewDocIDQuery([]string{variant.MarketPlaceCode}))
+		searchRequest.Fields = []string{sourceFieldName, collapseKeyFieldName}
+		searchResult, err := index.Search(searchRequest)
+		if err != nil {
+			return nil, err
+		}
+		if len(searchResult.Hits) < 1 || searchResult.Hits[0].Fields[collapseKeyFieldName] == product.BaseData().MarketPlaceCode {
+			continue
+		}
+		variantProduct, err := r.bleveHitToProduct(searchResult.Hits[0])
+		if err != nil {
+			return nil, err
+		}
+		variantDocuments, err := r.productToBleveDocs(variantProduct)
+		if err != nil {
+			return nil, err
+		}
+		bleveDocuments = append(bleveDocuments, variantDocuments...)
+	}
+	return bleveDocuments, nil
+}
+
 // productToBleveDocs returns the Product and Category documents to be indexed
 func (r *BleveRepository) productToBleveDocs(product productDomain.BasicProduct) ([]*document.Document, error) {
 	var bleveDocuments []*document.Document
@@ -262,28 +445,74 @@ func (r *BleveRepository) productToBleveDocs(product productDomain.BasicProduct)
 		sourceFieldName, nil, productEncoded, document.StoreField)
 	bleveProductDocument = bleveProductDocument.AddField(field)
 
-	for _, sort := range r.sortConfig {
+	for _, sort := range r.indexedSortFields() {
 		var field document.Field
 		switch sort.AttributeType {
 		case attributeTypeNumeric:
-			val, _ := strconv.ParseFloat(product.BaseData().Attribute(sort.AttributeCode).Value(), 64)
+			val, err := strconv.ParseFloat(product.BaseData().Attribute(sort.AttributeCode).Value(), 64)
+			if err != nil {
+				// products without a valid number are sorted last
+				continue
+			}
 			field = document.NewNumericField(
 				fieldPrefixInIndexedDocument+"sort."+sort.AttributeCode, nil, val)
-		case attributeTypeText:
-			field = document.NewTextFieldCustom(
-				fieldPrefixInIndexedDocument+"sort."+sort.AttributeCode, nil, []byte(product.BaseData().Attribute(sort.AttributeCode).Value()), document.IndexField, nil)
 		case attributeTypeBool:
-			val, _ := strconv.ParseBool(product.BaseData().Attribute(sort.AttributeCode).Value())
+			val, err := strconv.ParseBool(product.BaseData().Attribute(sort.AttributeCode).Value())
+			if err != nil {
+				continue
+			}
 			field = document.NewBooleanField(
 				fieldPrefixInIndexedDocument+"sort."+sort.AttributeCode, nil, val)
+		case attributeTypeDate:
+			val, err := parseDateValue(product.BaseData().Attribute(sort.AttributeCode).Value(), sort.DateLayout)
+			if err != nil {
+				// products without a valid date are sorted last
+				continue
+			}
+			field, err = document.NewDateTimeField(
+				fieldPrefixInIndexedDocument+"sort."+sort.AttributeCode, nil, val)
+			if err != nil {
+				continue
+			}
+		default:
+			field = document.NewTextFieldCustom(
+				fieldPrefixInIndexedDocument+"sort."+sort.AttributeCode, nil, []byte(product.BaseData().Attribute(sort.AttributeCode).Value()), document.IndexField, nil)
 		}
 		bleveProductDocument = bleveProductDocument.AddField(field)
 	}
 
-	// Add price Field to support sorting by price
-	priceField := document.NewNumericField(
-		fieldPrefixInIndexedDocument+"sort.price", nil, product.TeaserData().TeaserPrice.GetFinalPrice().FloatAmount())
-	bleveProductDocument = bleveProductDocument.AddField(priceField)
+	// Add the collapse key to group a configurable product with its variants
+	bleveProductDocument = bleveProductDocument.AddField(document.NewTextFieldCustom(
+		collapseKeyFieldName, nil, []byte(r.variantParents.collapseKey(product.BaseData().MarketPlaceCode)), document.IndexField|document.StoreField|document.IncludeTermVectors, nil))
+
+	// Add numeric fields for range facets, configurable products add the value range of their variants
+	indexedFacetConfig := r.indexedFacetConfig()
+	numericFacetFields := make(map[string]struct{})
+	for _, facetConfig := range indexedFacetConfig {
+		if facetConfig.Type != facetTypeRange {
+			continue
+		}
+		if _, ok := numericFacetFields[facetConfig.AttributeCode]; ok {
+			continue
+		}
+		numericFacetFields[facetConfig.AttributeCode] = struct{}{}
+		values := productNumericValues(product, facetConfig.AttributeCode)
+		if len(values) == 0 {
+			continue
+		}
+		min, max := numericBounds(values)
+		bleveProductDocument = bleveProductDocument.AddField(document.NewNumericField(
+			numericRangeMinFieldName(facetConfig.AttributeCode), nil, min))
+		bleveProductDocument = bleveProductDocument.AddField(document.NewNumericField(
+			numericRangeMaxFieldName(facetConfig.AttributeCode), nil, max))
+	}
+
+	// Add price Field to support sorting by price, products without a price are sorted last
+	if price, ok := numericValue(product.BaseData(), product.TeaserData().TeaserPrice, priceAttributeCode); ok {
+		priceField := document.NewNumericField(
+			numericFieldName(priceAttributeCode), nil, price)
+		bleveProductDocument = bleveProductDocument.AddField(priceField)
+	}
 
 	//  Add category field for category facet and filter
 	tok, err := whitespace.TokenizerConstructor(nil, nil)
@@ -312,12 +541,59 @@ func (r *BleveRepository) productToBleveDocs(product productDomain.BasicProduct)
 		fieldPrefixInIndexedDocument+"Facet.CategoryPaths", nil, []byte(strings.Join(allCategoryPaths, " ")), document.IndexField|document.StoreField|document.IncludeTermVectors, analyser)
 	bleveProductDocument = bleveProductDocument.AddField(categoryPathField)
 
-	// Add Configured Facet Attributes
-	for _, facetConfig := range r.facetConfig {
-		attributeField := document.NewTextFieldCustom(
-			fieldPrefixInIndexedDocument+"Facet.Attribute."+facetConfig.AttributeCode, nil, []byte(product.BaseData().Attribute(facetConfig.AttributeCode).Label), document.IndexField|document.StoreField|document.IncludeTermVectors, analyser)
-		bleveProductDocument = bleveProductDocument.AddField(attributeField)
+	// Add "Product.Suggest" and "Product.SuggestTerms" - Used for search-as-you-type
+	suggestField := document.NewTextFieldCustom(
+		fieldPrefixInIndexedDocument+"Suggest", nil, []byte(product.BaseData().Title), document.IndexField, suggestAnalyzer())
+	bleveProductDocument = bleveProductDocument.AddField(suggestField)
+	suggestTermsField := document.NewTextFieldCustom(
+		fieldPrefixInIndexedDocument+"SuggestTerms", nil, []byte(product.BaseData().Title), document.IndexField, suggestTermsAnalyzer())
+	bleveProductDocument = bleveProductDocument.AddField(suggestTermsField)
+	excludedFromAll := []string{suggestField.Name(), suggestTermsField.Name()}
+
+	// Add "Product.Visibility.*" - Used to evaluate the visibility rules at the time of the request
+	availability := availabilityOf(product)
+	saleableFrom, saleableTo := -math.MaxFloat64, math.MaxFloat64
+	if !availability.SaleableFrom.IsZero() {
+		saleableFrom = unixSeconds(availability.SaleableFrom)
+	}
+	if !availability.SaleableTo.IsZero() {
+		saleableTo = unixSeconds(availability.SaleableTo)
+	}
+	visibilityFields := []document.Field{
+		document.NewBooleanField(visibilitySaleableFieldName, nil, availability.Saleable),
+		document.NewNumericField(visibilitySaleableFromFieldName, nil, saleableFrom),
+		document.NewNumericField(visibilitySaleableToFieldName, nil, saleableTo),
+		document.NewBooleanField(visibilityInStockFieldName, nil, availability.InStock),
+	}
+	for _, field := range visibilityFields {
+		bleveProductDocument = bleveProductDocument.AddField(field)
+		excludedFromAll = append(excludedFromAll, field.Name())
+	}
+
+	// Add Configured Facet Attributes - the (unanalyzed) values of the product and its variants are indexed, the labels are kept for the facet items
+	termFacetFields := make(map[string]struct{})
+	for _, facetConfig := range indexedFacetConfig {
+		if facetConfig.Type == facetTypeRange {
+			continue
+		}
+		if _, ok := termFacetFields[facetConfig.AttributeCode]; ok {
+			continue
+		}
+		termFacetFields[facetConfig.AttributeCode] = struct{}{}
+		facetValues := productFacetValues(product, facetConfig.AttributeCode)
+		if len(facetValues) == 0 {
+			continue
+		}
+		attributeFieldName := fieldPrefixInIndexedDocument + "Facet.Attribute." + facetConfig.AttributeCode
+		for _, facetValue := range facetValues {
+			attributeField := document.NewTextFieldCustom(
+				attributeFieldName, nil, []byte(facetValue.Value), document.IndexField|document.StoreField|document.IncludeTermVectors, nil)
+			bleveProductDocument = bleveProductDocument.AddField(attributeField)
+			r.addFacetValueLabel(facetConfig.AttributeCode, facetValue.Value, facetValue.Label)
+		}
+		excludedFromAll = append(excludedFromAll, attributeFieldName)
 	}
+	excludeFromAllField(bleveProductDocument, excludedFromAll...)
 
 	// Add Type Field
 	bleveProductDocument = bleveProductDocument.AddField(indexDocument.getTypeField())
@@ -358,6 +634,13 @@ func (r *BleveRepository) categoryTeaserToBleve(categoryTeaser productDomain.Cat
 		return nil, err
 	}
 	bleveCatDocument = bleveCatDocument.AddField(indexDocument.getTypeField())
+
+	// Add "Category.Suggest" - Used for search-as-you-type
+	suggestField := document.NewTextFieldCustom(
+		"Category.Suggest", nil, []byte(categoryTeaser.Name), document.IndexField, suggestAnalyzer())
+	bleveCatDocument = bleveCatDocument.AddField(suggestField)
+	excludeFromAllField(bleveCatDocument, suggestField.Name())
+
 	if categoryTeaser.Parent == nil || categoryTeaser.Parent.Code == categoryTeaser.Code {
 		isRootField := document.NewBooleanFieldWithIndexingOptions(
 			"Category.IsRoot", nil, true, document.IndexField|document.StoreField|document.IncludeTermVectors)
@@ -384,6 +667,32 @@ func (r *BleveRepository) categoryTeaserToBleve(categoryTeaser productDomain.Cat
 	return alreadyAddedBleveDocs, nil
 }
 
+// excludeFromAllField replaces the composite "_all" field (used by the human query) with one that ignores the given fields
+func excludeFromAllField(indexedDocument *document.Document, fieldNames ...string) {
+	indexedDocument.CompositeFields = []*document.CompositeField{
+		document.NewCompositeFieldWithIndexingOptions("_all", true, nil, fieldNames, document.IndexField|document.IncludeTermVectors),
+	}
+}
+
+// suggestAnalyzer indexes all prefixes (edge ngrams) of the lower cased terms
+func suggestAnalyzer() *analysis.Analyzer {
+	return &analysis.Analyzer{
+		Tokenizer: unicodeTokenizer.NewUnicodeTokenizer(),
+		TokenFilters: []analysis.TokenFilter{
+			lowercase.NewLowerCaseFilter(),
+			edgengram.NewEdgeNgramFilter(edgengram.FRONT, 1, suggestMaxPrefixLength),
+		},
+	}
+}
+
+// suggestTermsAnalyzer indexes the lower cased terms - used for query completions
+func suggestTermsAnalyzer() *analysis.Analyzer {
+	return &analysis.Analyzer{
+		Tokenizer:    unicodeTokenizer.NewUnicodeTokenizer(),
+		TokenFilters: []analysis.TokenFilter{lowercase.NewLowerCaseFilter()},
+	}
+}
+
 func (r *BleveRepository) bleveHitToProduct(hit *search.DocumentMatch) (productDomain.BasicProduct, error) {
 	b, ok := hit.Fields[sourceFieldName]
 
@@ -393,26 +702,106 @@ func (r *BleveRepository) bleveHitToProduct(hit *search.DocumentMatch) (productD
 	return r.decodeProduct([]byte(fmt.Sprintf("%v", b)))
 }
 
-// FindByMarketplaceCode returns a product struct for the given marketplaceCode
+// FindByMarketplaceCode returns a product struct for the given marketplaceCode,
+// the configurable product with the active variant for variant marketplace codes
 func (r *BleveRepository) FindByMarketplaceCode(_ context.Context, marketplaceCode string) (productDomain.BasicProduct, error) {
 	index, err := r.getIndex()
 	if err != nil {
 		return nil, err
 	}
 
-	docIDQuery := query.NewDocIDQuery([]string{marketplaceCode})
-	searchRequest := bleve.NewSearchRequest(docIDQuery)
-	searchRequest.Fields = append(searchRequest.Fields, sourceFieldName)
-	searchResult, err := index.Search(searchRequest)
+	if parentMarketplaceCode, ok := r.variantParents.parent(marketplaceCode); ok {
+		hit, err := r.documentMatchByID(index, parentMarketplaceCode)
+		if err != nil {
+			return nil, err
+		}
+		parent, err := r.bleveHitToProduct(hit)
+		if err != nil {
+			return nil, err
+		}
+		return configurableWithActiveVariant(parent, marketplaceCode)
+	}
+
+	hit, err := r.documentMatchByID(index, marketplaceCode)
+	if err != nil {
+		return nil, err
+	}
+	return r.bleveHitToProduct(hit)
+}
+
+// Suggest returns products, categories and query completions matching the given prefix (search-as-you-type)
+func (r *BleveRepository) Suggest(_ context.Context, prefix string, limit int) (*domain.SuggestResult, error) {
+	index, err := r.getIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	var terms []string
+	for _, token := range suggestTermsAnalyzer().Analyze([]byte(prefix)) {
+		terms = append(terms, truncateSuggestTerm(string(token.Term)))
+	}
+	if len(terms) == 0 {
+		return &domain.SuggestResult{}, nil
+	}
+
+	result := &domain.SuggestResult{}
+	productRequest := bleve.NewSearchRequestOptions(newSuggestQuery(terms, fieldPrefixInIndexedDocument+"Suggest", productType), limit, 0, false)
+	productRequest.Fields = append(productRequest.Fields, sourceFieldName)
+	productResults, err := index.Search(productRequest)
+	if err != nil {
+		return nil, err
+	}
+	for _, hit := range productResults.Hits {
+		product, err := r.bleveHitToProduct(hit)
+		if err != nil {
+			r.logger.Error(err)
+			continue
+		}
+		result.Products = append(result.Products, product)
+	}
+
+	categoryRequest := bleve.NewSearchRequestOptions(newSuggestQuery(terms, "Category.Suggest", categoryType), limit, 0, false)
+	categoryRequest.Fields = append(categoryRequest.Fields, "Category.Code", "Category.Name", "Category.Path")
+	categoryResults, err := index.Search(categoryRequest)
+	if err != nil {
+		return nil, err
+	}
+	for _, hit := range categoryResults.Hits {
+		result.Categories = append(result.Categories, mapHitToCategory(hit))
+	}
 
+	fieldDict, err := index.FieldDictPrefix(fieldPrefixInIndexedDocument+"SuggestTerms", []byte(terms[len(terms)-1]))
 	if err != nil {
 		return nil, err
 	}
-	if searchResult.Total < 1 {
-		return nil, productDomain.ProductNotFound{MarketplaceCode: marketplaceCode}
+	defer func() {
+		_ = fieldDict.Close()
+	}()
+	var candidates []dictionaryTerm
+	for {
+		entry, err := fieldDict.Next()
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			break
+		}
+		candidates = append(candidates, dictionaryTerm{Term: entry.Term, Count: entry.Count})
 	}
+	result.Completions = suggestCompletions(prefix, terms, candidates, limit)
+
+	return result, nil
+}
 
-	return r.bleveHitToProduct(searchResult.Hits[0])
+// newSuggestQuery matches documents of the given type where every term is a prefix of a term in the (edge ngram) field
+func newSuggestQuery(terms []string, field string, documentType string) query.Query {
+	queryParts := []query.Query{bleve.NewPhraseQuery([]string{documentType}, typeFieldName)}
+	for _, term := range terms {
+		termQuery := bleve.NewTermQuery(term)
+		termQuery.SetField(field)
+		queryParts = append(queryParts, termQuery)
+	}
+	return bleve.NewConjunctionQuery(queryParts...)
 }
 
 // CategoryTree returns tree
@@ -550,46 +939,100 @@ func (r *BleveRepository) Find(_ context.Context, filters ...searchDomain.Filter
 	}
 
 	var mainQuery query.Query
+	var highlightFilter *domain.HighlightFilter
+	var matchedVariantsFilter *domain.MatchedVariantsFilter
+	var keyValueFilters []*searchDomain.KeyValueFilter
+	userInput := ""
 
 	currentPage := 1
 	pageSize := 100
-	sortingField := ""
-	sortingDesc := true
+	var sortFilter *searchDomain.SortFilter
 
 	// First check if we have a human query filter:
 	for _, filter := range filters {
 		switch f := filter.(type) {
 		case *searchDomain.QueryFilter:
-			mainQuery = bleve.NewQueryStringQuery(f.Query())
+			userInput = f.Query()
+			mainQuery = r.newSafeQuery(userInput)
 		}
 	}
+	// the query string syntax is only available to trusted callers of the QueryStringFilter
+	for _, filter := range filters {
+		if f, ok := filter.(*domain.QueryStringFilter); ok {
+			if mainQuery == nil {
+				mainQuery = bleve.NewQueryStringQuery(f.Query())
+				continue
+			}
+			mainQuery = bleve.NewConjunctionQuery(mainQuery, bleve.NewQueryStringQuery(f.Query()))
+		}
+	}
+
+	// facets of the active category
+	facetConfigs := r.activeFacetConfig(index, filters)
+
+	// visibility rules are evaluated at the time of the request
+	now := time.Now()
 
 	var filterQueryParts []query.Query
+	// the visibility and category filters also apply to the configurable products of collapsed variants
+	var parentQueryParts []query.Query
+	if r.visibilityConfig.hasRule(visibilityHide) {
+		filterQueryParts = append(filterQueryParts, r.visibilityQuery(visibilityHide, now))
+		parentQueryParts = append(parentQueryParts, filterQueryParts...)
+	}
+	// filters of multi select facets are kept apart to count the facet without its own filter
+	multiSelectFilterQueryParts := make(map[string][]query.Query)
 
 	for _, filter := range filters {
 		r.logger.Info("Find ", fmt.Sprintf("%T %#v", filter, filter))
 		switch f := filter.(type) {
 		case *searchDomain.KeyValueFilter:
 			if f.Key() == "category" {
-				filterQueryParts = append(filterQueryParts, newDisjunctionTermQuery(f.KeyValues(), fieldPrefixInIndexedDocument+"Facet.Categorycode"))
+				categoryQuery := newDisjunctionTermQuery(f.KeyValues(), fieldPrefixInIndexedDocument+"Facet.Categorycode")
+				filterQueryParts = append(filterQueryParts, categoryQuery)
+				parentQueryParts = append(parentQueryParts, categoryQuery)
+				continue
+			}
+			keyValueFilters = append(keyValueFilters, f)
+			filterQuery := r.newAttributeFilterQuery(f.Key(), f.KeyValues())
+			if isMultiSelectFacet(facetConfigs, f.Key()) {
+				multiSelectFilterQueryParts[f.Key()] = append(multiSelectFilterQueryParts[f.Key()], filterQuery)
+				continue
+			}
+			filterQueryParts = append(filterQueryParts, filterQuery)
+		case *domain.ExcludeFilter:
+			excludeQuery := bleve.NewBooleanQuery()
+			if f.Key() == "category" {
+				excludeQuery.AddMustNot(newDisjunctionTermQuery(f.Values(), fieldPrefixInIndexedDocument+"Facet.Categorycode"))
+				parentQueryParts = append(parentQueryParts, excludeQuery)
 			} else {
-				filterQueryParts = append(filterQueryParts, newDisjunctionTermQuery(f.KeyValues(), fieldPrefixInIndexedDocument+"Facet.Attribute."+f.Key()))
+				excludeQuery.AddMustNot(r.newAttributeFilterQuery(f.Key(), f.Values()))
 			}
+			filterQueryParts = append(filterQueryParts, excludeQuery)
 		case categoryDomain.CategoryFacet:
-			filterQueryParts = append(filterQueryParts, newDisjunctionTermQuery([]string{f.CategoryCode}, fieldPrefixInIndexedDocument+"Facet.Categorycode"))
+			categoryQuery := newDisjunctionTermQuery([]string{f.CategoryCode}, fieldPrefixInIndexedDocument+"Facet.Categorycode")
+			filterQueryParts = append(filterQueryParts, categoryQuery)
+			parentQueryParts = append(parentQueryParts, categoryQuery)
 		case *categoryDomain.CategoryFacet:
-			filterQueryParts = append(filterQueryParts, newDisjunctionTermQuery([]string{f.CategoryCode}, fieldPrefixInIndexedDocument+"Facet.Categorycode"))
+			categoryQuery := newDisjunctionTermQuery([]string{f.CategoryCode}, fieldPrefixInIndexedDocument+"Facet.Categorycode")
+			filterQueryParts = append(filterQueryParts, categoryQuery)
+			parentQueryParts = append(parentQueryParts, categoryQuery)
 		case *searchDomain.PaginationPage:
 			currentPage = f.GetPage()
 		case *searchDomain.PaginationPageSize:
 			pageSize = f.GetPageSize()
 		case *searchDomain.SortFilter:
-			sortingField = fieldPrefixInIndexedDocument + "sort." + f.Field()
-			sortingDesc = f.Descending()
+			sortFilter = configuredSortFilter(r.sortConfig, f)
+		case *domain.HighlightFilter:
+			highlightFilter = f
+			highlightFilter.Reset()
+		case *domain.MatchedVariantsFilter:
+			matchedVariantsFilter = f
+			matchedVariantsFilter.Reset()
 		}
 	}
 
-	if mainQuery == nil && len(filterQueryParts) > 0 {
+	if mainQuery == nil && (len(filterQueryParts) > 0 || len(multiSelectFilterQueryParts) > 0) {
 		mainQuery = bleve.NewMatchAllQuery()
 	}
 	if mainQuery != nil {
@@ -598,26 +1041,32 @@ func (r *BleveRepository) Find(_ context.Context, filters ...searchDomain.Filter
 	filterQueryParts = append(filterQueryParts, bleve.NewPhraseQuery([]string{productType}, typeFieldName))
 	facetsRequests := make(bleve.FacetsRequest)
 	if r.enableCategoryFacet {
-		facetRequest := bleve.NewFacetRequest(fieldPrefixInIndexedDocument+"Facet.CategoryPaths", 100)
+		facetRequest := bleve.NewFacetRequest(fieldPrefixInIndexedDocument+"Facet.CategoryPaths", r.categoryFacetSize)
 		facetsRequests["category"] = facetRequest
 	}
-	for _, facetConfig := range r.facetConfig {
+	facetRanges := make(map[string][]facetRange)
+	for _, facetConfig := range facetConfigs {
+		if facetConfig.Type == facetTypeRange {
+			ranges := r.facetRanges(index, facetConfig)
+			if len(ranges) == 0 {
+				continue
+			}
+			// range facets are counted with the range filter queries after the search
+			facetRanges[facetConfig.AttributeCode] = ranges
+			continue
+		}
 		facetRequest := bleve.NewFacetRequest(fieldPrefixInIndexedDocument+"Facet.Attribute."+facetConfig.AttributeCode, facetConfig.Amount)
 		facetsRequests[facetConfig.AttributeCode] = facetRequest
 	}
-	conjunctionQuery := bleve.NewConjunctionQuery(filterQueryParts...)
+	conjunctionQuery := bleve.NewConjunctionQuery(withMultiSelectFilterQueryParts(filterQueryParts, multiSelectFilterQueryParts, "")...)
 	from := (currentPage - 1) * pageSize
 	searchRequest := bleve.NewSearchRequestOptions(conjunctionQuery, pageSize, from, false)
 	searchRequest.Facets = facetsRequests
 	searchRequest.Fields = append(searchRequest.Fields, sourceFieldName)
-	if sortingField != "" {
-		searchRequest.SortByCustom(search.SortOrder{
-			&search.SortField{
-				Field:   sortingField,
-				Missing: search.SortFieldMissingLast,
-				Desc:    sortingDesc,
-			},
-		})
+	// term locations are needed to highlight the matched fragments
+	searchRequest.IncludeLocations = highlightFilter != nil
+	if sortFilter != nil {
+		searchRequest.SortByCustom(r.sortOrder(sortFilter))
 	}
 
 	searchResults, err := index.Search(searchRequest)
@@ -626,12 +1075,648 @@ func (r *BleveRepository) Find(_ context.Context, filters ...searchDomain.Filter
 		return nil, err
 	}
 
-	result := r.mapBleveResultToResult(searchResults)
+	var lastQuery query.Query
+	if r.visibilityConfig.hasRule(visibilityLast) {
+		lastQuery = r.visibilityQuery(visibilityLast, now)
+	}
+	if r.collapseVariants == collapseVariantsParent || r.collapseVariants == collapseVariantsVariant {
+		searchResults.Hits, searchResults.Total, err = r.collapseHits(index, searchRequest, searchResults.Total, lastQuery, parentQueryParts)
+		if err != nil {
+			return nil, err
+		}
+	} else if lastQuery != nil {
+		searchResults.Hits, err = r.visibleFirstHits(index, searchRequest, lastQuery)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// count selected multi select facets against the result without their own filter
+	for facetName := range multiSelectFilterQueryParts {
+		facetRequest, ok := facetsRequests[facetName]
+		if !ok {
+			continue
+		}
+		facetSearchRequest := bleve.NewSearchRequestOptions(bleve.NewConjunctionQuery(withMultiSelectFilterQueryParts(filterQueryParts, multiSelectFilterQueryParts, facetName)...), 0, 0, false)
+		facetSearchRequest.Facets = bleve.FacetsRequest{facetName: facetRequest}
+		facetSearchResults, err := index.Search(facetSearchRequest)
+		if err != nil {
+			return nil, err
+		}
+		searchResults.Facets[facetName] = facetSearchResults.Facets[facetName]
+	}
+
+	for attributeCode, ranges := range facetRanges {
+		facetResult, err := r.rangeFacetResult(index, withMultiSelectFilterQueryParts(filterQueryParts, multiSelectFilterQueryParts, attributeCode), attributeCode, ranges)
+		if err != nil {
+			return nil, err
+		}
+		if searchResults.Facets == nil {
+			searchResults.Facets = make(search.FacetResults)
+		}
+		searchResults.Facets[attributeCode] = facetResult
+	}
+
+	result := r.mapBleveResultToResult(searchResults, facetConfigs, facetRanges)
 	markActiveFacets(filters, result)
+	markSelectedSortOption(result.SearchMeta.SortOptions, sortFilter)
+	applyFacetPresentation(facetConfigs, result)
+	if highlightFilter != nil {
+		r.highlightHits(index, searchResults.Hits, highlightFilter)
+	}
+	if matchedVariantsFilter != nil {
+		for _, hit := range result.Hits {
+			matchedVariantsFilter.AddVariants(hit.BaseData().MarketPlaceCode, matchedVariants(hit, keyValueFilters, r.variantMatches))
+		}
+	}
+	if userInput != "" && int(searchResults.Total) <= r.queryConfig.SuggestionThreshold {
+		result.Suggestion = r.spellingSuggestions(index, userInput)
+	}
 
 	return result, nil
 }
 
+// newSafeQuery treats the user input as plain terms, so query string syntax (field:, +, -, quotes) has no special meaning
+func (r *BleveRepository) newSafeQuery(userInput string) query.Query {
+	var termQueries []query.Query
+	for _, term := range strings.Fields(userInput) {
+		// skip terms without any letter or digit - they would not produce any token anyway
+		if strings.IndexFunc(term, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) < 0 {
+			continue
+		}
+		termQueries = append(termQueries, r.newTermQuery(term))
+	}
+	if len(termQueries) == 0 {
+		return bleve.NewMatchAllQuery()
+	}
+
+	if r.queryConfig.Operator == queryOperatorAnd {
+		return bleve.NewConjunctionQuery(termQueries...)
+	}
+
+	minimumShouldMatch := r.queryConfig.MinimumShouldMatch
+	if minimumShouldMatch > len(termQueries) {
+		minimumShouldMatch = len(termQueries)
+	}
+	disjunctionQuery := bleve.NewDisjunctionQuery(termQueries...)
+	disjunctionQuery.SetMin(float64(minimumShouldMatch))
+	return disjunctionQuery
+}
+
+// newTermQuery matches a single user term, misspelled terms are matched with an edit distance depending on the term length
+func (r *BleveRepository) newTermQuery(term string) query.Query {
+	exactQuery := bleve.NewMatchQuery(term)
+	maxEdits := r.queryConfig.allowedEdits(term)
+	if !r.queryConfig.TypoTolerance || maxEdits == 0 {
+		return exactQuery
+	}
+	fuzzyQuery := bleve.NewMatchQuery(term)
+	fuzzyQuery.SetFuzziness(maxEdits)
+	fuzzyQuery.SetPrefix(r.queryConfig.TypoPrefixLength)
+	// exact matches should score higher than fuzzy ones
+	fuzzyQuery.SetBoost(0.5)
+	return bleve.NewDisjunctionQuery(exactQuery, fuzzyQuery)
+}
+
+// spellingSuggestions returns "did you mean" suggestions for the user input based on the indexed term dictionary
+func (r *BleveRepository) spellingSuggestions(index bleve.Index, userInput string) []searchDomain.Suggestion {
+	// corrections keep the typo prefix, so only the dictionary ranges with the prefixes of the query terms are read
+	prefixes := make(map[string]bool)
+	var dictionary []dictionaryTerm
+	for _, term := range queryTerms(userInput) {
+		prefix := typoPrefix(term, r.queryConfig.TypoPrefixLength)
+		if prefixes[prefix] {
+			continue
+		}
+		prefixes[prefix] = true
+
+		terms, err := r.dictionaryTerms(index, prefix)
+		if err != nil {
+			r.logger.Warn("Cannot read term dictionary for suggestions ", err)
+			return nil
+		}
+		dictionary = append(dictionary, terms...)
+	}
+	return spellingSuggestions(userInput, dictionary, r.queryConfig)
+}
+
+// dictionaryTerms returns the terms of the _all field starting with the prefix
+func (r *BleveRepository) dictionaryTerms(index bleve.Index, prefix string) ([]dictionaryTerm, error) {
+	fieldDict, err := index.FieldDictPrefix("_all", []byte(prefix))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = fieldDict.Close()
+	}()
+
+	var dictionary []dictionaryTerm
+	for {
+		entry, err := fieldDict.Next()
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			return dictionary, nil
+		}
+		dictionary = append(dictionary, dictionaryTerm{Term: entry.Term, Count: entry.Count})
+	}
+}
+
+// highlightHits adds the highlighted fragments of the title, description and configured attributes to the filter
+func (r *BleveRepository) highlightHits(index bleve.Index, hits search.DocumentMatchCollection, highlightFilter *domain.HighlightFilter) {
+	highlighter := simpleHighlighter.NewHighlighter(
+		simpleFragmenter.NewFragmenter(r.highlightConfig.FragmentSize),
+		html.NewFragmentFormatter(r.highlightConfig.MarkupBefore, r.highlightConfig.MarkupAfter),
+		"",
+	)
+
+	highlightFields := map[string][]string{
+		domain.HighlightFieldTitle:       {fieldPrefixInIndexedDocument + "Title"},
+		domain.HighlightFieldDescription: {fieldPrefixInIndexedDocument + "Description"},
+	}
+	for _, attributeCode := range r.highlightConfig.Attributes {
+		highlightFields[attributeCode] = []string{
+			fieldPrefixInIndexedDocument + "Attributes." + attributeCode + ".Label",
+			fieldPrefixInIndexedDocument + "Attributes." + attributeCode + ".RawValue",
+		}
+	}
+
+	for _, hit := range hits {
+		if len(hit.Locations) == 0 {
+			continue
+		}
+		indexedDocument, err := index.Document(hit.ID)
+		if err != nil {
+			r.logger.Warn("Cannot load document for highlighting ", hit.ID, err)
+			continue
+		}
+		for highlightField, indexedFields := range highlightFields {
+			for _, indexedField := range indexedFields {
+				if _, matched := hit.Locations[indexedField]; !matched {
+					continue
+				}
+				highlightFilter.AddFragments(hit.ID, highlightField, highlighter.BestFragmentsInField(hit, indexedDocument, indexedField, r.highlightConfig.MaxFragments))
+				break
+			}
+		}
+	}
+}
+
+// isMultiSelectFacet checks if the attribute is configured as multi select facet
+func isMultiSelectFacet(facetConfigs []facetConfig, attributeCode string) bool {
+	for _, facetConfig := range facetConfigs {
+		if facetConfig.AttributeCode == attributeCode && facetConfig.MultiSelect {
+			return true
+		}
+	}
+	return false
+}
+
+// withMultiSelectFilterQueryParts adds the filters of all multi select facets except the excluded one to the query parts
+func withMultiSelectFilterQueryParts(queryParts []query.Query, multiSelectFilterQueryParts map[string][]query.Query, excludedFacet string) []query.Query {
+	result := append([]query.Query(nil), queryParts...)
+	for facetName, facetQueryParts := range multiSelectFilterQueryParts {
+		if facetName == excludedFacet {
+			continue
+		}
+		result = append(result, facetQueryParts...)
+	}
+	return result
+}
+
+// isRangeFacet checks if the attribute is configured as range facet
+func (r *BleveRepository) isRangeFacet(attributeCode string) bool {
+	for _, facetConfig := range r.indexedFacetConfig() {
+		if facetConfig.AttributeCode == attributeCode && facetConfig.Type == facetTypeRange {
+			return true
+		}
+	}
+	return false
+}
+
+// sortOrder returns the sort fields of the selected preset or attribute, ties are sorted by relevance
+func (r *BleveRepository) sortOrder(sortFilter *searchDomain.SortFilter) search.SortOrder {
+	sortFields := []sortField{{AttributeCode: sortFilter.Field()}}
+	if sortConfig, ok := findSortConfig(r.sortConfig, sortFilter.Field()); ok {
+		sortFields = sortConfig.sortFields()
+	}
+
+	var sortOrder search.SortOrder
+	hasScore := false
+	for _, sortField := range sortFields {
+		// a descending preset reverses each of its fields
+		desc := sortField.Desc != sortFilter.Descending()
+		if sortField.AttributeCode == sortFieldScore {
+			hasScore = true
+			// best matches first is the natural order of the relevance
+			sortOrder = append(sortOrder, &search.SortScore{Desc: !desc})
+			continue
+		}
+		sortOrder = append(sortOrder, &search.SortField{
+			Field:   fieldPrefixInIndexedDocument + "sort." + sortField.AttributeCode,
+			Missing: search.SortFieldMissingLast,
+			Desc:    desc,
+		})
+	}
+	if !hasScore {
+		sortOrder = append(sortOrder, &search.SortScore{Desc: true})
+	}
+	return sortOrder
+}
+
+// indexedSortFields returns the attribute fields of the sort config, the price is indexed anyway
+func (r *BleveRepository) indexedSortFields() []sortField {
+	var fields []sortField
+	for _, field := range indexedSortFields(r.sortConfig) {
+		if field.AttributeCode != priceAttributeCode {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// facetRanges returns the configured ranges of the facet or buckets between the indexed min and max value
+func (r *BleveRepository) facetRanges(index bleve.Index, facetConfig facetConfig) []facetRange {
+	if len(facetConfig.Ranges) > 0 {
+		return facetConfig.Ranges
+	}
+
+	min, _, found := r.numericFieldBounds(index, numericRangeMinFieldName(facetConfig.AttributeCode))
+	if !found {
+		return nil
+	}
+	_, max, _ := r.numericFieldBounds(index, numericRangeMaxFieldName(facetConfig.AttributeCode))
+	buckets := facetConfig.Buckets
+	if buckets <= 0 {
+		buckets = defaultRangeFacetBuckets
+	}
+	return automaticFacetRanges(min, max, buckets)
+}
+
+// numericFieldBounds returns the smallest and largest indexed value of a numeric field
+func (r *BleveRepository) numericFieldBounds(index bleve.Index, field string) (min float64, max float64, found bool) {
+	// full precision terms (shift 0) are sorted by their numeric value
+	fieldDict, err := index.FieldDictPrefix(field, []byte{numeric.ShiftStartInt64})
+	if err != nil {
+		r.logger.Warn("Cannot read numeric field ", field, err)
+		return 0, 0, false
+	}
+	defer func() {
+		_ = fieldDict.Close()
+	}()
+
+	for {
+		entry, err := fieldDict.Next()
+		if err != nil {
+			r.logger.Warn("Cannot read numeric field ", field, err)
+			return 0, 0, false
+		}
+		if entry == nil {
+			return min, max, found
+		}
+		value, err := numeric.PrefixCoded(entry.Term).Int64()
+		if err != nil {
+			continue
+		}
+		if !found {
+			min = numeric.Int64ToFloat64(value)
+			found = true
+		}
+		max = numeric.Int64ToFloat64(value)
+	}
+}
+
+// newAttributeFilterQuery creates the query matching products with any of the values of the attribute
+func (r *BleveRepository) newAttributeFilterQuery(attributeCode string, values []string) query.Query {
+	if dateField, ok := dateSortField(r.sortConfig, attributeCode); ok {
+		return r.newDisjunctionDateRangeQuery(values, dateField)
+	}
+	if r.isRangeFacet(attributeCode) {
+		return r.newDisjunctionRangeQuery(values, attributeCode)
+	}
+	return newDisjunctionTermQuery(values, fieldPrefixInIndexedDocument+"Facet.Attribute."+attributeCode)
+}
+
+// newDisjunctionRangeQuery creates a disjunctive numeric range query for range facet values like "10-20",
+// a product matches if its value range (the values of its variants) overlaps with the filtered range
+func (r *BleveRepository) newDisjunctionRangeQuery(values []string, attributeCode string) *query.DisjunctionQuery {
+	rangeQuery := bleve.NewDisjunctionQuery()
+	for _, value := range values {
+		facetRange, err := parseFacetRange(value)
+		if err != nil {
+			r.logger.Warn("Invalid range filter ", err)
+			continue
+		}
+		var overlapQueryParts []query.Query
+		if facetRange.To != nil {
+			minQuery := bleve.NewNumericRangeQuery(nil, facetRange.To)
+			minQuery.SetField(numericRangeMinFieldName(attributeCode))
+			overlapQueryParts = append(overlapQueryParts, minQuery)
+		}
+		if facetRange.From != nil {
+			maxQuery := bleve.NewNumericRangeQuery(facetRange.From, nil)
+			maxQuery.SetField(numericRangeMaxFieldName(attributeCode))
+			overlapQueryParts = append(overlapQueryParts, maxQuery)
+		}
+		rangeQuery.AddQuery(bleve.NewConjunctionQuery(overlapQueryParts...))
+	}
+	if len(rangeQuery.Disjuncts) == 0 {
+		// invalid filter values match nothing
+		rangeQuery.AddQuery(bleve.NewMatchNoneQuery())
+	}
+	return rangeQuery
+}
+
+// rangeFacetResult counts the products of each range with the same overlap query used by the range filter,
+// so a configurable product is counted in every range its variants fall into, like it is found by each of these filters
+func (r *BleveRepository) rangeFacetResult(index bleve.Index, filterQueryParts []query.Query, attributeCode string, ranges []facetRange) (*search.FacetResult, error) {
+	facetResult := &search.FacetResult{Field: attributeCode}
+	for _, facetRange := range ranges {
+		queryParts := append(append([]query.Query(nil), filterQueryParts...), r.newDisjunctionRangeQuery([]string{facetRange.Value()}, attributeCode))
+		countResults, err := index.Search(bleve.NewSearchRequestOptions(bleve.NewConjunctionQuery(queryParts...), 0, 0, false))
+		if err != nil {
+			return nil, err
+		}
+		if countResults.Total == 0 {
+			continue
+		}
+		facetResult.Total += int(countResults.Total)
+		facetResult.NumericRanges = append(facetResult.NumericRanges, &search.NumericRangeFacet{
+			Name:  facetRange.Value(),
+			Min:   facetRange.From,
+			Max:   facetRange.To,
+			Count: int(countResults.Total),
+		})
+	}
+	return facetResult, nil
+}
+
+// newDisjunctionDateRangeQuery matches any of the date ranges
+func (r *BleveRepository) newDisjunctionDateRangeQuery(values []string, dateField sortField) *query.DisjunctionQuery {
+	rangeQuery := bleve.NewDisjunctionQuery()
+	inclusiveStart, inclusiveEnd := true, false
+	for _, value := range values {
+		dateRange, err := parseDateRange(value, dateField.DateLayout)
+		if err != nil {
+			r.logger.Warn("Invalid date range filter ", err)
+			continue
+		}
+		dateRangeQuery := bleve.NewDateRangeInclusiveQuery(dateRange.From, dateRange.To, &inclusiveStart, &inclusiveEnd)
+		dateRangeQuery.SetField(fieldPrefixInIndexedDocument + "sort." + dateField.AttributeCode)
+		rangeQuery.AddQuery(dateRangeQuery)
+	}
+	if len(rangeQuery.Disjuncts) == 0 {
+		// invalid filter values match nothing
+		rangeQuery.AddQuery(bleve.NewMatchNoneQuery())
+	}
+	return rangeQuery
+}
+
+// variantMatches checks if the variant data matches any of the filter values
+func (r *BleveRepository) variantMatches(data productDomain.BasicProductData, price productDomain.PriceInfo, key string, values []string) bool {
+	if dateField, ok := dateSortField(r.sortConfig, key); ok {
+		date, err := parseDateValue(data.Attribute(key).Value(), dateField.DateLayout)
+		if err != nil {
+			return false
+		}
+		for _, value := range values {
+			if dateRange, err := parseDateRange(value, dateField.DateLayout); err == nil && dateRange.contains(date) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if r.isRangeFacet(key) {
+		numericValue, ok := numericValue(data, price, key)
+		if !ok {
+			return false
+		}
+		for _, value := range values {
+			if facetRange, err := parseFacetRange(value); err == nil && facetRange.contains(numericValue) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return hasFacetValue(data, key, values)
+}
+
+// visibilityQuery matches the products that pass all visibility rules with the given mode at the given time
+func (r *BleveRepository) visibilityQuery(mode string, now time.Time) query.Query {
+	var queryParts []query.Query
+	if r.visibilityConfig.NonSaleable == mode {
+		saleableQuery := bleve.NewBoolFieldQuery(true)
+		saleableQuery.SetField(visibilitySaleableFieldName)
+		nowSeconds := unixSeconds(now)
+		inclusive, exclusive := true, false
+		saleableFromQuery := bleve.NewNumericRangeInclusiveQuery(nil, &nowSeconds, nil, &exclusive)
+		saleableFromQuery.SetField(visibilitySaleableFromFieldName)
+		saleableToQuery := bleve.NewNumericRangeInclusiveQuery(&nowSeconds, nil, &exclusive, &inclusive)
+		saleableToQuery.SetField(visibilitySaleableToFieldName)
+		queryParts = append(queryParts, saleableQuery, saleableFromQuery, saleableToQuery)
+	}
+	if r.visibilityConfig.OutOfStock == mode {
+		inStockQuery := bleve.NewBoolFieldQuery(true)
+		inStockQuery.SetField(visibilityInStockFieldName)
+		queryParts = append(queryParts, inStockQuery)
+	}
+	return bleve.NewConjunctionQuery(queryParts...)
+}
+
+// visibleFirstHits returns the page of the search request with the products matching the visible query first
+func (r *BleveRepository) visibleFirstHits(index bleve.Index, searchRequest *bleve.SearchRequest, visibleQuery query.Query) (search.DocumentMatchCollection, error) {
+	visibleRequest := *searchRequest
+	visibleRequest.Query = bleve.NewConjunctionQuery(searchRequest.Query, visibleQuery)
+	visibleRequest.Facets = nil
+	visibleResults, err := index.Search(&visibleRequest)
+	if err != nil {
+		return nil, err
+	}
+	hits := visibleResults.Hits
+	if len(hits) >= searchRequest.Size {
+		return hits, nil
+	}
+
+	// fill the page with the other products, they start after the last visible product
+	otherQuery := bleve.NewBooleanQuery()
+	otherQuery.AddMust(searchRequest.Query)
+	otherQuery.AddMustNot(visibleQuery)
+	otherRequest := *searchRequest
+	otherRequest.Query = otherQuery
+	otherRequest.Facets = nil
+	otherRequest.From = searchRequest.From - int(visibleResults.Total)
+	if otherRequest.From < 0 {
+		otherRequest.From = 0
+	}
+	otherRequest.Size = searchRequest.Size - len(hits)
+	otherResults, err := index.Search(&otherRequest)
+	if err != nil {
+		return nil, err
+	}
+	return append(hits, otherResults.Hits...), nil
+}
+
+// collapseHits groups the hits of a configurable product and its variants and returns the page of the search request
+// together with the number of groups. The groups are counted with a facet on the collapse key, the hits are fetched in
+// growing batches until the page is complete. In parent mode the configurable product is listed if it passes the
+// parent query parts (visibility and category filters), otherwise its best variant is listed
+func (r *BleveRepository) collapseHits(index bleve.Index, searchRequest *bleve.SearchRequest, total uint64, lastQuery query.Query, parentQueryParts []query.Query) (search.DocumentMatchCollection, uint64, error) {
+	if total == 0 {
+		return nil, 0, nil
+	}
+	countRequest := bleve.NewSearchRequestOptions(searchRequest.Query, 0, 0, false)
+	countRequest.Facets = bleve.FacetsRequest{collapseKeyFieldName: bleve.NewFacetRequest(collapseKeyFieldName, int(total))}
+	countResults, err := index.Search(countRequest)
+	if err != nil {
+		return nil, 0, err
+	}
+	groupCount := uint64(countResults.Facets[collapseKeyFieldName].Missing + len(countResults.Facets[collapseKeyFieldName].Terms))
+
+	groupsNeeded := searchRequest.From + searchRequest.Size
+	batchRequest := *searchRequest
+	batchRequest.From = 0
+	batchRequest.Size = groupsNeeded
+	if batchRequest.Size < 1 {
+		batchRequest.Size = 1
+	}
+	batchRequest.Facets = nil
+	batchRequest.Fields = append(append([]string(nil), searchRequest.Fields...), collapseKeyFieldName)
+
+	// the first hit of a group is the best one
+	hitsByID := make(map[string]*search.DocumentMatch)
+	firstHits := make(map[string]*search.DocumentMatch)
+	var groupKeys []string
+	for len(groupKeys) < groupsNeeded {
+		var hits search.DocumentMatchCollection
+		if lastQuery != nil {
+			hits, err = r.visibleFirstHits(index, &batchRequest, lastQuery)
+		} else {
+			var batchResults *bleve.SearchResult
+			batchResults, err = index.Search(&batchRequest)
+			if batchResults != nil {
+				hits = batchResults.Hits
+			}
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, hit := range hits {
+			hitsByID[hit.ID] = hit
+			collapseKey, ok := hit.Fields[collapseKeyFieldName].(string)
+			if !ok {
+				collapseKey = hit.ID
+			}
+			if _, ok := firstHits[collapseKey]; ok {
+				continue
+			}
+			firstHits[collapseKey] = hit
+			groupKeys = append(groupKeys, collapseKey)
+		}
+		if len(hits) < batchRequest.Size {
+			break
+		}
+		batchRequest.From += batchRequest.Size
+		batchRequest.Size *= 2
+	}
+
+	start := searchRequest.From
+	if start > len(groupKeys) {
+		start = len(groupKeys)
+	}
+	stop := start + searchRequest.Size
+	if stop > len(groupKeys) {
+		stop = len(groupKeys)
+	}
+
+	var pageHits search.DocumentMatchCollection
+	for _, collapseKey := range groupKeys[start:stop] {
+		hit := firstHits[collapseKey]
+		if r.collapseVariants == collapseVariantsParent {
+			if parentHit, ok := hitsByID[collapseKey]; ok {
+				hit = parentHit
+			} else if parentHit, err := r.documentMatchByID(index, collapseKey, parentQueryParts...); err == nil {
+				// the configurable product itself did not match, only its variants
+				hit = parentHit
+			} else if _, notFound := err.(productDomain.ProductNotFound); !notFound {
+				return nil, 0, err
+			}
+		}
+		pageHits = append(pageHits, hit)
+	}
+	return pageHits, groupCount, nil
+}
+
+// documentMatchByID returns the document with the source field, if it matches all query parts
+func (r *BleveRepository) documentMatchByID(index bleve.Index, id string, queryParts ...query.Query) (*search.DocumentMatch, error) {
+	searchRequest := bleve.NewSearchRequest(bleve.NewConjunctionQuery(append([]query.Query{query.NewDocIDQuery([]string{id})}, queryParts...)...))
+	searchRequest.Fields = append(searchRequest.Fields, sourceFieldName)
+	searchResult, err := index.Search(searchRequest)
+	if err != nil {
+		return nil, err
+	}
+	if len(searchResult.Hits) < 1 {
+		return nil, productDomain.ProductNotFound{MarketplaceCode: id}
+	}
+	return searchResult.Hits[0], nil
+}
+
+// unixSeconds returns the time as fractional unix seconds, used for numeric range queries
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+// mapRangeFacet maps the numeric range facet result in the order of the ranges, empty ranges are skipped
+func mapRangeFacet(facetConfig facetConfig, ranges []facetRange, facetResult *search.FacetResult) searchDomain.Facet {
+	facet := searchDomain.Facet{
+		Type:     searchDomain.RangeFacet,
+		Name:     facetConfig.AttributeCode,
+		Label:    facetConfig.AttributeCode,
+		Position: 0,
+	}
+	if facetResult == nil {
+		return facet
+	}
+
+	for _, facetRange := range ranges {
+		for _, numericRange := range facetResult.NumericRanges {
+			if numericRange.Name != facetRange.Value() {
+				continue
+			}
+			item := &searchDomain.FacetItem{
+				Label: facetRange.DisplayLabel(),
+				Value: facetRange.Value(),
+				Count: int64(numericRange.Count),
+			}
+			if facetRange.From != nil {
+				item.Min = *facetRange.From
+			}
+			if facetRange.To != nil {
+				item.Max = *facetRange.To
+			}
+			facet.Items = append(facet.Items, item)
+		}
+	}
+	return facet
+}
+
+// numericFieldName returns the indexed numeric field of an attribute, written for sorting and range facets
+func numericFieldName(attributeCode string) string {
+	return fieldPrefixInIndexedDocument + "sort." + attributeCode
+}
+
+// numericRangeMinFieldName returns the indexed field of the lowest value of an attribute of the product and its variants
+func numericRangeMinFieldName(attributeCode string) string {
+	return fieldPrefixInIndexedDocument + "Range." + attributeCode + ".Min"
+}
+
+// numericRangeMaxFieldName returns the indexed field of the highest value of an attribute of the product and its variants
+func numericRangeMaxFieldName(attributeCode string) string {
+	return fieldPrefixInIndexedDocument + "Range." + attributeCode + ".Max"
+}
+
 // newDisjunctionTermQuery creates a disjunctive term query, meaning that any of the provided terms can match
 func newDisjunctionTermQuery(terms []string, field string) *query.DisjunctionQuery {
 	termQuery := bleve.NewDisjunctionQuery()
@@ -642,8 +1727,25 @@ func newDisjunctionTermQuery(terms []string, field string) *query.DisjunctionQue
 }
 
 func markActiveFacets(filters []searchDomain.Filter, result *productDomain.SearchResult) {
+	var activeCategoryCodes []string
+	for _, filter := range filters {
+		switch f := filter.(type) {
+		case categoryDomain.CategoryFacet:
+			activeCategoryCodes = append(activeCategoryCodes, f.CategoryCode)
+		case *categoryDomain.CategoryFacet:
+			activeCategoryCodes = append(activeCategoryCodes, f.CategoryCode)
+		case *searchDomain.KeyValueFilter:
+			if f.Key() == "category" {
+				activeCategoryCodes = append(activeCategoryCodes, f.KeyValues()...)
+			}
+		}
+	}
+	if categoryFacet, ok := result.Facets["category"]; ok && categoryFacet.Type == searchDomain.TreeFacet {
+		markActiveCategoryTreeFacetItems(categoryFacet.Items, activeCategoryCodes)
+	}
+
 	for _, filter := range filters {
-		if f, ok := filter.(*searchDomain.KeyValueFilter); ok {
+		if f, ok := filter.(*searchDomain.KeyValueFilter); ok && f.Key() != "category" {
 			for i, facetItem := range result.Facets[f.Key()].Items {
 				for _, selectedValue := range f.KeyValues() {
 					if facetItem.Value == selectedValue {
@@ -657,7 +1759,7 @@ func markActiveFacets(filters []searchDomain.Filter, result *productDomain.Searc
 	}
 }
 
-func (r *BleveRepository) mapBleveResultToResult(searchResults *bleve.SearchResult) *productDomain.SearchResult {
+func (r *BleveRepository) mapBleveResultToResult(searchResults *bleve.SearchResult, facetConfigs []facetConfig, facetRanges map[string][]facetRange) *productDomain.SearchResult {
 	pageAmount := 0
 	pageSize := searchResults.Request.Size
 	currentPage := 1
@@ -684,6 +1786,7 @@ func (r *BleveRepository) mapBleveResultToResult(searchResults *bleve.SearchResu
 			r.logger.Warn("No facet result for category facet ")
 		}
 		var constructedItems []*searchDomain.FacetItem
+		countedItems := make(map[*searchDomain.FacetItem]struct{})
 
 		for _, termFacetTerms := range facetResult.Terms {
 			pathSegments := strings.Split(termFacetTerms.Term, "/")
@@ -691,8 +1794,9 @@ func (r *BleveRepository) mapBleveResultToResult(searchResults *bleve.SearchResu
 				continue
 			}
 			pathSegments = pathSegments[1:]
-			constructedItems = r.constructCategoryTreeFacet(constructedItems, pathSegments, int64(termFacetTerms.Count))
+			constructedItems = r.constructCategoryTreeFacet(constructedItems, pathSegments, int64(termFacetTerms.Count), countedItems)
 		}
+		r.rollUpCategoryTreeFacetCounts(constructedItems, countedItems)
 
 		facet := searchDomain.Facet{
 			Type:     searchDomain.TreeFacet,
@@ -704,7 +1808,13 @@ func (r *BleveRepository) mapBleveResultToResult(searchResults *bleve.SearchResu
 		resultFacetCollection["category"] = facet
 	}
 
-	for _, facetConfig := range r.facetConfig {
+	for _, facetConfig := range facetConfigs {
+		if facetConfig.Type == facetTypeRange {
+			if ranges, ok := facetRanges[facetConfig.AttributeCode]; ok {
+				resultFacetCollection[facetConfig.AttributeCode] = mapRangeFacet(facetConfig, ranges, facetResultForConfiguredName(facetConfig.AttributeCode))
+			}
+			continue
+		}
 		facetResult := facetResultForConfiguredName(facetConfig.AttributeCode)
 		if facetResult == nil {
 			r.logger.Warn("No facet result for configured facet ", facetConfig.AttributeCode)
@@ -717,7 +1827,7 @@ func (r *BleveRepository) mapBleveResultToResult(searchResults *bleve.SearchResu
 		}
 		for _, termFacetTerms := range facetResult.Terms {
 			facet.Items = append(facet.Items, &searchDomain.FacetItem{
-				Label:    termFacetTerms.Term,
+				Label:    r.facetValueLabel(facetConfig.AttributeCode, termFacetTerms.Term),
 				Value:    termFacetTerms.Term,
 				Active:   false,
 				Selected: false,
@@ -746,24 +1856,7 @@ func (r *BleveRepository) mapBleveResultToResult(searchResults *bleve.SearchResu
 		},
 	}
 
-	for _, s := range r.sortConfig {
-		sortOptions = append(sortOptions, searchDomain.SortOption{
-			Label: s.AttributeCode,
-			Field: s.AttributeCode,
-			Asc: func() string {
-				if s.Asc {
-					return s.AttributeCode
-				}
-				return ""
-			}(),
-			Desc: func() string {
-				if s.Desc {
-					return s.AttributeCode
-				}
-				return ""
-			}(),
-		})
-	}
+	sortOptions = append(sortOptions, configSortOptions(r.sortConfig)...)
 
 	return &productDomain.SearchResult{
 		Hits: productResults,
@@ -779,23 +1872,10 @@ func (r *BleveRepository) mapBleveResultToResult(searchResults *bleve.SearchResu
 }
 
 func (r *BleveRepository) encodeProduct(product productDomain.BasicProduct) ([]byte, error) {
-	var mess bytes.Buffer
-	if sp, ok := product.(productDomain.SimpleProduct); ok {
-		product = &sp
-	}
-	if cp, ok := product.(productDomain.ConfigurableProduct); ok {
-		product = &cp
-	}
-
-	enc := gob.NewEncoder(&mess)
-	err := enc.Encode(&product)
-	if err != nil {
-		return nil, err
-	}
-	return mess.Bytes(), nil
+	return encodeGobProduct(product)
 }
 
-func (r *BleveRepository) constructCategoryTreeFacet(parentSlice []*searchDomain.FacetItem, remainingPathSegments []string, count int64) []*searchDomain.FacetItem {
+func (r *BleveRepository) constructCategoryTreeFacet(parentSlice []*searchDomain.FacetItem, remainingPathSegments []string, count int64, countedItems map[*searchDomain.FacetItem]struct{}) []*searchDomain.FacetItem {
 	currentSegment := remainingPathSegments[0]
 	isLast := len(remainingPathSegments) == 1
 
@@ -822,26 +1902,44 @@ func (r *BleveRepository) constructCategoryTreeFacet(parentSlice []*searchDomain
 	if isLast {
 		// use count if path matches
 		foundItem.Count = count
+		countedItems[foundItem] = struct{}{}
 		return parentSlice
 	}
-	foundItem.Items = r.constructCategoryTreeFacet(foundItem.Items, remainingPathSegments[1:], count)
+	foundItem.Items = r.constructCategoryTreeFacet(foundItem.Items, remainingPathSegments[1:], count, countedItems)
 	return parentSlice
 }
 
-func (r *BleveRepository) decodeProduct(b []byte) (productDomain.BasicProduct, error) {
-	buffer := bytes.NewBuffer(b)
-	dec := gob.NewDecoder(buffer)
-
-	var sp productDomain.BasicProduct
-	err := dec.Decode(&sp)
-	if err != nil {
-		return nil, err
-	}
-	if p, ok := sp.(*productDomain.SimpleProduct); ok {
-		sp = *p
+// rollUpCategoryTreeFacetCounts adds the counts of the sub categories to their parents.
+// If products are assigned to the parent categories the indexed count of a parent already contains its sub categories
+func (r *BleveRepository) rollUpCategoryTreeFacetCounts(items []*searchDomain.FacetItem, countedItems map[*searchDomain.FacetItem]struct{}) {
+	for _, item := range items {
+		r.rollUpCategoryTreeFacetCounts(item.Items, countedItems)
+		if _, counted := countedItems[item]; counted && r.assignProductsToParentCategories {
+			continue
+		}
+		for _, subItem := range item.Items {
+			item.Count += subItem.Count
+		}
 	}
-	if p, ok := sp.(*productDomain.ConfigurableProduct); ok {
-		sp = *p
+}
+
+// markActiveCategoryTreeFacetItems marks the items of the given categories as selected and their ancestors as active
+func markActiveCategoryTreeFacetItems(items []*searchDomain.FacetItem, categoryCodes []string) bool {
+	hasActiveItem := false
+	for _, item := range items {
+		if markActiveCategoryTreeFacetItems(item.Items, categoryCodes) {
+			item.Active = true
+			hasActiveItem = true
+		}
+		if inSlice(categoryCodes, item.Value) {
+			item.Selected = true
+			item.Active = true
+			hasActiveItem = true
+		}
 	}
-	return sp, nil
+	return hasActiveItem
+}
+
+func (r *BleveRepository) decodeProduct(b []byte) (productDomain.BasicProduct, error) {
+	return decodeGobProduct(b)
 }
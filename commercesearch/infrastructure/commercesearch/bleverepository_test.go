@@ -3,6 +3,7 @@ package commercesearch
 import (
 	"context"
 	"math/big"
+	"sort"
 	"testing"
 	"time"
 
@@ -15,8 +16,34 @@ import (
 	categoryDomain "flamingo.me/flamingo-commerce/v3/category/domain"
 	"flamingo.me/flamingo-commerce/v3/product/domain"
 	"github.com/stretchr/testify/assert"
+
+	commercesearchDomain "flamingo.me/flamingo-commerce-adapter-standalone/commercesearch/domain"
 )
 
+// bleveRepositoryConfig matches the config struct of BleveRepository.Inject
+type bleveRepositoryConfig = struct {
+	AssignProductsToParentCategories bool         `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.productsToParentCategories,optional"`
+	EnableCategoryFacet              bool         `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.enableCategoryFacet,optional"`
+	CategoryFacetSize                float64      `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.categoryFacetSize,optional"`
+	FacetConfig                      config.Slice `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.facetConfig"`
+	CategoryFacetConfig              config.Slice `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.categoryFacetConfig,optional"`
+	SortConfig                       config.Slice `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.sortConfig"`
+	QueryOperator                    string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.query.operator,optional"`
+	QueryMinimumShouldMatch          float64      `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.query.minimumShouldMatch,optional"`
+	Query                            config.Map   `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.query,optional"`
+	QueryMinTermLengthOneTypo        float64      `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.query.minTermLengthOneTypo,optional"`
+	QueryMinTermLengthTwoTypos       float64      `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.query.minTermLengthTwoTypos,optional"`
+	QuerySuggestionThreshold         float64      `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.query.suggestionThreshold,optional"`
+	HighlightAttributes              config.Slice `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.highlight.attributes,optional"`
+	HighlightFragmentSize            float64      `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.highlight.fragmentSize,optional"`
+	HighlightMaxFragments            float64      `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.highlight.maxFragments,optional"`
+	HighlightMarkupBefore            string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.highlight.markupBefore,optional"`
+	HighlightMarkupAfter             string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.highlight.markupAfter,optional"`
+	VisibilityNonSaleable            string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.visibility.nonSaleable,optional"`
+	VisibilityOutOfStock             string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.visibility.outOfStock,optional"`
+	CollapseVariants                 string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.collapseVariants,optional"`
+}
+
 func TestBleveProductRepository_AddProduct(t *testing.T) {
 	s := &BleveRepository{}
 	s.Inject(flamingo.NullLogger{}, nil)
@@ -197,12 +224,7 @@ func TestBleveRepository_FacetsSearch(t *testing.T) {
 		"attributeCode": "brand",
 		"amount":        10,
 	})
-	s.Inject(flamingo.NullLogger{}, &struct {
-		AssignProductsToParentCategories bool         `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.productsToParentCategories,optional"`
-		EnableCategoryFacet              bool         `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.enableCategoryFacet,optional"`
-		FacetConfig                      config.Slice `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.facetConfig"`
-		SortConfig                       config.Slice `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.bleveAdapter.sortConfig"`
-	}{
+	s.Inject(flamingo.NullLogger{}, &bleveRepositoryConfig{
 		AssignProductsToParentCategories: true,
 		EnableCategoryFacet:              true,
 		FacetConfig:                      configuration,
@@ -351,6 +373,1111 @@ func TestBleveRepository_FacetsSearch(t *testing.T) {
 	})
 }
 
+func TestBleveRepository_SafeQuery(t *testing.T) {
+	products := []domain.BasicProduct{
+		domain.SimpleProduct{
+			Identifier: "id1",
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: "id1",
+				Title:           "red shoe",
+			},
+		},
+		domain.SimpleProduct{
+			Identifier: "id2",
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: "id2",
+				Title:           "blue shoe",
+			},
+		},
+		domain.SimpleProduct{
+			Identifier: "id3",
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: "id3",
+				Title:           "red shirt",
+			},
+		},
+	}
+
+	t.Run("Query string syntax is treated as plain terms", func(t *testing.T) {
+		s := &BleveRepository{}
+		s.Inject(flamingo.NullLogger{}, nil)
+		require.NoError(t, s.PrepareIndex(context.Background()))
+		require.NoError(t, s.UpdateProducts(context.Background(), products))
+
+		result, err := s.Find(context.Background(), searchDomain.NewQueryFilter("-red"))
+		require.NoError(t, err)
+		assert.Equal(t, 2, result.SearchMeta.NumResults, "minus must not exclude red products")
+
+		result, err = s.Find(context.Background(), searchDomain.NewQueryFilter("\"shoe"))
+		require.NoError(t, err)
+		assert.Equal(t, 2, result.SearchMeta.NumResults, "unbalanced quote must not fail")
+
+		result, err = s.Find(context.Background(), searchDomain.NewQueryFilter("_type:category"))
+		require.NoError(t, err)
+		assert.Equal(t, 0, result.SearchMeta.NumResults, "internal fields must not be queryable")
+
+		result, err = s.Find(context.Background(), searchDomain.NewQueryFilter("+ :"))
+		require.NoError(t, err)
+		assert.Equal(t, 3, result.SearchMeta.NumResults, "input without terms should match all")
+	})
+
+	t.Run("Operator and minimum should match", func(t *testing.T) {
+		s := &BleveRepository{}
+		s.Inject(flamingo.NullLogger{}, &bleveRepositoryConfig{QueryOperator: "and"})
+		require.NoError(t, s.PrepareIndex(context.Background()))
+		require.NoError(t, s.UpdateProducts(context.Background(), products))
+
+		result, err := s.Find(context.Background(), searchDomain.NewQueryFilter("red shoe"))
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.SearchMeta.NumResults)
+
+		s.Inject(flamingo.NullLogger{}, &bleveRepositoryConfig{QueryMinimumShouldMatch: 2})
+		result, err = s.Find(context.Background(), searchDomain.NewQueryFilter("red shoe shirt"))
+		require.NoError(t, err)
+		assert.Equal(t, 2, result.SearchMeta.NumResults, "blue shoe only matches one term")
+
+		s.Inject(flamingo.NullLogger{}, nil)
+		result, err = s.Find(context.Background(), searchDomain.NewQueryFilter("red shoe"))
+		require.NoError(t, err)
+		assert.Equal(t, 3, result.SearchMeta.NumResults)
+	})
+
+	t.Run("Query string syntax is opt-in per request", func(t *testing.T) {
+		s := &BleveRepository{}
+		s.Inject(flamingo.NullLogger{}, nil)
+		require.NoError(t, s.PrepareIndex(context.Background()))
+		require.NoError(t, s.UpdateProducts(context.Background(), products))
+
+		result, err := s.Find(context.Background(), searchDomain.NewQueryFilter("shoe -red"))
+		require.NoError(t, err)
+		assert.Equal(t, 3, result.SearchMeta.NumResults, "the query filter must not use the query string syntax")
+
+		result, err = s.Find(context.Background(), commercesearchDomain.NewQueryStringFilter("shoe -red"))
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.SearchMeta.NumResults)
+	})
+}
+
+func TestBleveRepository_TypoTolerance(t *testing.T) {
+	products := []domain.BasicProduct{
+		domain.SimpleProduct{
+			Identifier: "id1",
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: "id1",
+				Title:           "leather shoe",
+			},
+		},
+		domain.SimpleProduct{
+			Identifier: "id2",
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: "id2",
+				Title:           "refrigerator",
+			},
+		},
+	}
+
+	t.Run("Misspelled terms match depending on term length", func(t *testing.T) {
+		s := &BleveRepository{}
+		s.Inject(flamingo.NullLogger{}, nil)
+		require.NoError(t, s.PrepareIndex(context.Background()))
+		require.NoError(t, s.UpdateProducts(context.Background(), products))
+
+		result, err := s.Find(context.Background(), searchDomain.NewQueryFilter("lether"))
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.SearchMeta.NumResults, "one typo allowed for terms with 4+ runes")
+
+		s.Inject(flamingo.NullLogger{}, &bleveRepositoryConfig{QuerySuggestionThreshold: 1})
+		result, err = s.Find(context.Background(), searchDomain.NewQueryFilter("lether"))
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.SearchMeta.NumResults, "typo tolerance is kept if it is not configured")
+
+		result, err = s.Find(context.Background(), searchDomain.NewQueryFilter("refridgerater"))
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.SearchMeta.NumResults, "two typos allowed for terms with 8+ runes")
+
+		result, err = s.Find(context.Background(), searchDomain.NewQueryFilter("sho"))
+		require.NoError(t, err)
+		assert.Equal(t, 0, result.SearchMeta.NumResults, "no typo allowed for short terms")
+	})
+
+	t.Run("Suggestions on zero hits", func(t *testing.T) {
+		s := &BleveRepository{}
+		s.Inject(flamingo.NullLogger{}, &bleveRepositoryConfig{Query: config.Map{"typoTolerance": false}})
+		require.NoError(t, s.PrepareIndex(context.Background()))
+		require.NoError(t, s.UpdateProducts(context.Background(), products))
+
+		result, err := s.Find(context.Background(), searchDomain.NewQueryFilter("lether shoe"))
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.SearchMeta.NumResults)
+		assert.Empty(t, result.Suggestion, "no suggestion if hits are above threshold")
+
+		s.Inject(flamingo.NullLogger{}, &bleveRepositoryConfig{Query: config.Map{"typoTolerance": false}, QuerySuggestionThreshold: 1})
+		result, err = s.Find(context.Background(), searchDomain.NewQueryFilter("Lether shoe"))
+		require.NoError(t, err)
+		require.Len(t, result.Suggestion, 1)
+		assert.Equal(t, "leather shoe", result.Suggestion[0].Text)
+		assert.Equal(t, "<em>leather</em> shoe", result.Suggestion[0].Highlight)
+	})
+}
+
+func TestBleveRepository_Suggest(t *testing.T) {
+	s := &BleveRepository{}
+	s.Inject(flamingo.NullLogger{}, nil)
+	require.NoError(t, s.PrepareIndex(context.Background()))
+
+	err := s.UpdateByCategoryTeasers(context.Background(), []domain.CategoryTeaser{
+		{Code: "shoes", Name: "Shoes", Path: "shoes"},
+		{Code: "shirts", Name: "Shirts", Path: "shirts"},
+	})
+	require.NoError(t, err)
+
+	err = s.UpdateProducts(context.Background(), []domain.BasicProduct{
+		domain.SimpleProduct{
+			Identifier: "id1",
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: "id1",
+				Title:           "Leather Shoe",
+			},
+		},
+		domain.SimpleProduct{
+			Identifier: "id2",
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: "id2",
+				Title:           "Running Shoe",
+			},
+		},
+		domain.SimpleProduct{
+			Identifier: "id3",
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: "id3",
+				Title:           "Leather Shirt",
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	result, err := s.Suggest(context.Background(), "sh", 10)
+	require.NoError(t, err)
+	assert.Len(t, result.Products, 3)
+	assert.Len(t, result.Categories, 2)
+	assert.Equal(t, []string{"shoe", "shirt"}, result.Completions, "more frequent completions first")
+
+	result, err = s.Suggest(context.Background(), "leather sho", 10)
+	require.NoError(t, err)
+	require.Len(t, result.Products, 1)
+	assert.Equal(t, "id1", result.Products[0].BaseData().MarketPlaceCode)
+	assert.Empty(t, result.Categories)
+	assert.Equal(t, []string{"leather shoe"}, result.Completions)
+
+	result, err = s.Suggest(context.Background(), "sh", 1)
+	require.NoError(t, err)
+	assert.Len(t, result.Products, 1)
+	assert.Len(t, result.Categories, 1)
+	assert.Len(t, result.Completions, 1)
+
+	result, err = s.Suggest(context.Background(), "shoe ", 10)
+	require.NoError(t, err)
+	assert.Len(t, result.Products, 2)
+	assert.Empty(t, result.Completions, "no completions for finished terms")
+}
+
+func TestBleveRepository_Highlight(t *testing.T) {
+	s := &BleveRepository{}
+	s.Inject(flamingo.NullLogger{}, &bleveRepositoryConfig{
+		HighlightAttributes:   config.Slice{"material"},
+		HighlightMarkupBefore: "<b>",
+		HighlightMarkupAfter:  "</b>",
+	})
+	require.NoError(t, s.PrepareIndex(context.Background()))
+	err := s.UpdateProducts(context.Background(), []domain.BasicProduct{
+		domain.SimpleProduct{
+			Identifier: "id1",
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: "id1",
+				Title:           "Leather Shoe",
+				Description:     "A comfortable shoe for every day",
+				Attributes: domain.Attributes{
+					"material": domain.Attribute{Code: "material", Label: "Leather", RawValue: "leather"},
+				},
+			},
+		},
+		domain.SimpleProduct{
+			Identifier: "id2",
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: "id2",
+				Title:           "Running Shirt",
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	highlightFilter := commercesearchDomain.NewHighlightFilter()
+	result, err := s.Find(context.Background(), searchDomain.NewQueryFilter("leather"), highlightFilter)
+	require.NoError(t, err)
+	require.Len(t, result.Hits, 1)
+
+	highlights := highlightFilter.Highlights()
+	assert.Equal(t, []string{"<b>Leather</b> Shoe"}, highlights.Fragments("id1", commercesearchDomain.HighlightFieldTitle))
+	assert.Equal(t, []string{"<b>Leather</b>"}, highlights.Fragments("id1", "material"))
+	assert.Empty(t, highlights.Fragments("id1", commercesearchDomain.HighlightFieldDescription), "description did not match")
+}
+
+func TestBleveRepository_RangeFacets(t *testing.T) {
+	newProduct := func(marketPlaceCode string, price int64, weight string) domain.SimpleProduct {
+		return domain.SimpleProduct{
+			Identifier: marketPlaceCode,
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: marketPlaceCode,
+				Title:           marketPlaceCode,
+				Attributes: domain.Attributes{
+					"weight": domain.Attribute{Code: "weight", Label: weight, RawValue: weight},
+				},
+			},
+			Teaser: domain.TeaserData{
+				TeaserPrice: domain.PriceInfo{
+					Default: commercePriceDomain.NewFromInt(price, 100, "€"),
+				},
+			},
+		}
+	}
+
+	s := &BleveRepository{}
+	s.Inject(flamingo.NullLogger{}, &bleveRepositoryConfig{
+		FacetConfig: config.Slice{
+			config.Map{
+				"attributeCode": "price",
+				"type":          "range",
+				"ranges": config.Slice{
+					config.Map{"to": 10.0, "label": "cheap"},
+					config.Map{"from": 10.0, "to": 50.0},
+					config.Map{"from": 50.0},
+				},
+			},
+			config.Map{
+				"attributeCode": "weight",
+				"type":          "range",
+				"buckets":       2.0,
+			},
+		},
+	})
+	require.NoError(t, s.PrepareIndex(context.Background()))
+	err := s.UpdateProducts(context.Background(), []domain.BasicProduct{
+		newProduct("p1", 599, "1.5"),
+		newProduct("p2", 1999, "12"),
+		newProduct("p3", 2999, "19"),
+		newProduct("p4", 9999, "unknown"),
+	})
+	require.NoError(t, err)
+
+	t.Run("Configured and automatic buckets", func(t *testing.T) {
+		result, err := s.Find(context.Background())
+		require.NoError(t, err)
+
+		priceFacet := result.Facets["price"]
+		assert.Equal(t, searchDomain.RangeFacet, priceFacet.Type)
+		require.Len(t, priceFacet.Items, 3)
+		assert.Equal(t, "cheap", priceFacet.Items[0].Label)
+		assert.Equal(t, "*-10", priceFacet.Items[0].Value)
+		assert.Equal(t, int64(1), priceFacet.Items[0].Count)
+		assert.Equal(t, "10-50", priceFacet.Items[1].Value)
+		assert.Equal(t, int64(2), priceFacet.Items[1].Count)
+		assert.Equal(t, 10.0, priceFacet.Items[1].Min)
+		assert.Equal(t, 50.0, priceFacet.Items[1].Max)
+
+		weightFacet := result.Facets["weight"]
+		require.Len(t, weightFacet.Items, 2)
+		assert.Equal(t, "0-10", weightFacet.Items[0].Value)
+		assert.Equal(t, int64(1), weightFacet.Items[0].Count)
+		assert.Equal(t, "10-20", weightFacet.Items[1].Value)
+		assert.Equal(t, int64(2), weightFacet.Items[1].Count)
+	})
+
+	t.Run("Range filter", func(t *testing.T) {
+		result, err := s.Find(context.Background(), searchDomain.NewKeyValueFilter("price", []string{"*-10", "50-*"}))
+		require.NoError(t, err)
+		assert.Equal(t, 2, result.SearchMeta.NumResults)
+
+		result, err = s.Find(context.Background(), searchDomain.NewKeyValueFilter("weight", []string{"10-20"}))
+		require.NoError(t, err)
+		assert.Equal(t, 2, result.SearchMeta.NumResults)
+		assert.True(t, result.Facets["weight"].Items[0].Selected)
+	})
+}
+
+func TestBleveRepository_MultiSelectFacets(t *testing.T) {
+	newProduct := func(marketPlaceCode string, color string, size string) domain.SimpleProduct {
+		return domain.SimpleProduct{
+			Identifier: marketPlaceCode,
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: marketPlaceCode,
+				Title:           marketPlaceCode,
+				Attributes: domain.Attributes{
+					"color": domain.Attribute{Code: "color", Label: color, RawValue: color},
+					"size":  domain.Attribute{Code: "size", Label: size, RawValue: size},
+				},
+			},
+		}
+	}
+
+	s := &BleveRepository{}
+	s.Inject(flamingo.NullLogger{}, &bleveRepositoryConfig{
+		FacetConfig: config.Slice{
+			config.Map{"attributeCode": "color", "amount": 10.0, "multiSelect": true},
+			config.Map{"attributeCode": "size", "amount": 10.0},
+		},
+	})
+	require.NoError(t, s.PrepareIndex(context.Background()))
+	err := s.UpdateProducts(context.Background(), []domain.BasicProduct{
+		newProduct("p1", "red", "s"),
+		newProduct("p2", "red", "l"),
+		newProduct("p3", "blue", "s"),
+		newProduct("p4", "green", "l"),
+	})
+	require.NoError(t, err)
+
+	facetCounts := func(facet searchDomain.Facet) map[string]int64 {
+		counts := make(map[string]int64)
+		for _, item := range facet.Items {
+			counts[item.Value] = item.Count
+		}
+		return counts
+	}
+
+	result, err := s.Find(context.Background(), searchDomain.NewKeyValueFilter("color", []string{"red"}))
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.SearchMeta.NumResults)
+	assert.Equal(t, map[string]int64{"red": 2, "blue": 1, "green": 1}, facetCounts(result.Facets["color"]), "multi select facet is counted without its own filter")
+	assert.Equal(t, map[string]int64{"s": 1, "l": 1}, facetCounts(result.Facets["size"]))
+	for _, item := range result.Facets["color"].Items {
+		assert.Equal(t, item.Value == "red", item.Selected, item.Value)
+	}
+
+	result, err = s.Find(context.Background(), searchDomain.NewKeyValueFilter("color", []string{"red", "blue"}), searchDomain.NewKeyValueFilter("size", []string{"s"}))
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.SearchMeta.NumResults)
+	assert.Equal(t, map[string]int64{"red": 1, "blue": 1}, facetCounts(result.Facets["color"]), "other filters still apply")
+	assert.Equal(t, map[string]int64{"s": 2}, facetCounts(result.Facets["size"]))
+}
+
+func TestBleveRepository_CategoryTreeFacet(t *testing.T) {
+	sub2 := domain.CategoryTeaser{Code: "Sub2", Parent: &domain.CategoryTeaser{Code: "Root"}}
+	newProduct := func(marketPlaceCode string, category domain.CategoryTeaser) domain.SimpleProduct {
+		return domain.SimpleProduct{
+			Identifier: marketPlaceCode,
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: marketPlaceCode,
+				Title:           marketPlaceCode,
+				MainCategory:    category,
+			},
+		}
+	}
+	products := []domain.BasicProduct{
+		newProduct("p1", domain.CategoryTeaser{Code: "Sub1", Parent: &domain.CategoryTeaser{Code: "Root"}}),
+		newProduct("p2", domain.CategoryTeaser{Code: "Sub2_1", Parent: &sub2}),
+		newProduct("p3", domain.CategoryTeaser{Code: "Sub2_2", Parent: &sub2}),
+		newProduct("p4", sub2),
+	}
+
+	categoryFacetItem := func(items []*searchDomain.FacetItem, code string) *searchDomain.FacetItem {
+		for _, item := range items {
+			if item.Value == code {
+				return item
+			}
+		}
+		return nil
+	}
+
+	for _, assignProductsToParentCategories := range []bool{true, false} {
+		s := &BleveRepository{}
+		s.Inject(flamingo.NullLogger{}, &bleveRepositoryConfig{
+			AssignProductsToParentCategories: assignProductsToParentCategories,
+			EnableCategoryFacet:              true,
+		})
+		require.NoError(t, s.PrepareIndex(context.Background()))
+		require.NoError(t, s.UpdateProducts(context.Background(), products))
+
+		result, err := s.Find(context.Background(), searchDomain.NewQueryFilter("*"))
+		require.NoError(t, err)
+		sub2Item := categoryFacetItem(result.Facets["category"].Items, "Sub2")
+		require.NotNil(t, sub2Item)
+		assert.Equal(t, int64(3), sub2Item.Count, "count of Sub2 contains its sub categories")
+		assert.Equal(t, int64(1), categoryFacetItem(sub2Item.Items, "Sub2_1").Count)
+
+		if !assignProductsToParentCategories {
+			continue
+		}
+		result, err = s.Find(context.Background(), categoryDomain.NewCategoryFacet("Sub2_1"))
+		require.NoError(t, err)
+		sub2Item = categoryFacetItem(result.Facets["category"].Items, "Sub2")
+		require.NotNil(t, sub2Item)
+		assert.True(t, sub2Item.Active, "ancestor of the selected category is active")
+		assert.False(t, sub2Item.Selected)
+		sub21Item := categoryFacetItem(sub2Item.Items, "Sub2_1")
+		require.NotNil(t, sub21Item)
+		assert.True(t, sub21Item.Active)
+		assert.True(t, sub21Item.Selected)
+	}
+}
+
+func TestBleveRepository_FacetValues(t *testing.T) {
+	s := &BleveRepository{}
+	s.Inject(flamingo.NullLogger{}, &bleveRepositoryConfig{
+		FacetConfig: config.Slice{
+			config.Map{"attributeCode": "color", "amount": 10.0},
+		},
+	})
+	require.NoError(t, s.PrepareIndex(context.Background()))
+	err := s.UpdateProducts(context.Background(), []domain.BasicProduct{
+		domain.SimpleProduct{
+			Identifier: "p1",
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: "p1",
+				Title:           "p1",
+				Attributes: domain.Attributes{
+					"color": domain.Attribute{Code: "color", Label: "Dark Red", RawValue: "dark-red"},
+				},
+			},
+		},
+		domain.SimpleProduct{
+			Identifier: "p2",
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: "p2",
+				Title:           "p2",
+				Attributes: domain.Attributes{
+					"color": domain.Attribute{Code: "color", Label: "Dark Red, Light Blue, matte", RawValue: []interface{}{
+						map[string]interface{}{"value": "dark-red", "label": "Dark Red"},
+						map[string]interface{}{"value": "light-blue", "label": "Light Blue, matte"},
+					}},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	result, err := s.Find(context.Background())
+	require.NoError(t, err)
+	require.Len(t, result.Facets["color"].Items, 2)
+	assert.Equal(t, "dark-red", result.Facets["color"].Items[0].Value)
+	assert.Equal(t, "Dark Red", result.Facets["color"].Items[0].Label)
+	assert.Equal(t, int64(2), result.Facets["color"].Items[0].Count)
+	assert.Equal(t, "light-blue", result.Facets["color"].Items[1].Value)
+	assert.Equal(t, "Light Blue, matte", result.Facets["color"].Items[1].Label, "labels with a comma are kept")
+
+	result, err = s.Find(context.Background(), searchDomain.NewKeyValueFilter("color", []string{"light-blue"}))
+	require.NoError(t, err)
+	require.Len(t, result.Hits, 1)
+	assert.Equal(t, "p2", result.Hits[0].BaseData().MarketPlaceCode)
+
+	result, err = s.Find(context.Background(), searchDomain.NewKeyValueFilter("color", []string{"Dark Red"}))
+	require.NoError(t, err)
+	assert.Len(t, result.Hits, 0, "labels are not used for filtering")
+}
+
+func TestBleveRepository_CategoryFacetConfig(t *testing.T) {
+	s := &BleveRepository{}
+	s.Inject(flamingo.NullLogger{}, &bleveRepositoryConfig{
+		FacetConfig: config.Slice{
+			config.Map{"attributeCode": "brand", "amount": 10.0},
+		},
+		CategoryFacetConfig: config.Slice{
+			config.Map{"categoryCode": "tv", "mode": "extend", "facets": config.Slice{
+				config.Map{"attributeCode": "screenSize", "amount": 10.0},
+			}},
+			config.Map{"categoryCode": "oled", "mode": "extend", "facets": config.Slice{
+				config.Map{"attributeCode": "brand", "amount": 10.0, "label": "OLED brand"},
+			}},
+			config.Map{"categoryCode": "shoes", "mode": "override", "facets": config.Slice{
+				config.Map{"attributeCode": "shoeSize", "amount": 10.0},
+			}},
+		},
+	})
+	require.NoError(t, s.PrepareIndex(context.Background()))
+
+	root := domain.CategoryTeaser{Code: "root"}
+	tv := domain.CategoryTeaser{Code: "tv", Parent: &root}
+	newProduct := func(marketPlaceCode string, category domain.CategoryTeaser, attributes domain.Attributes) domain.SimpleProduct {
+		attributes["brand"] = domain.Attribute{Code: "brand", Label: "ACME", RawValue: "acme"}
+		return domain.SimpleProduct{
+			Identifier: marketPlaceCode,
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: marketPlaceCode,
+				Title:           marketPlaceCode,
+				MainCategory:    category,
+				Attributes:      attributes,
+			},
+		}
+	}
+	oled := domain.CategoryTeaser{Code: "oled", Parent: &tv}
+	shoes := domain.CategoryTeaser{Code: "shoes", Parent: &root}
+	require.NoError(t, s.UpdateByCategoryTeasers(context.Background(), []domain.CategoryTeaser{oled, shoes}))
+	err := s.UpdateProducts(context.Background(), []domain.BasicProduct{
+		newProduct("tv1", oled, domain.Attributes{
+			"screenSize": domain.Attribute{Code: "screenSize", Label: "55", RawValue: "55"},
+		}),
+		newProduct("shoe1", shoes, domain.Attributes{
+			"shoeSize": domain.Attribute{Code: "shoeSize", Label: "42", RawValue: "42"},
+		}),
+	})
+	require.NoError(t, err)
+
+	facetNames := func(result *domain.SearchResult) []string {
+		var names []string
+		for name := range result.Facets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	result, err := s.Find(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"brand"}, facetNames(result), "global facets without category filter")
+
+	result, err = s.Find(context.Background(), categoryDomain.NewCategoryFacet("tv"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"brand", "screenSize"}, facetNames(result), "tv extends the global facets")
+
+	result, err = s.Find(context.Background(), categoryDomain.NewCategoryFacet("oled"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"brand", "screenSize"}, facetNames(result), "oled inherits the facets of tv")
+	assert.Equal(t, "OLED brand", result.Facets["brand"].Label, "oled replaces the brand facet")
+
+	result, err = s.Find(context.Background(), categoryDomain.NewCategoryFacet("shoes"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"shoeSize"}, facetNames(result), "shoes overrides the global facets")
+	require.Len(t, result.Facets["shoeSize"].Items, 1)
+	assert.Equal(t, "42", result.Facets["shoeSize"].Items[0].Value)
+}
+
+func TestBleveRepository_SortPresets(t *testing.T) {
+	s := &BleveRepository{}
+	s.Inject(flamingo.NullLogger{}, &bleveRepositoryConfig{
+		SortConfig: config.Slice{
+			config.Map{"attributeCode": "name", "attributeType": "text", "asc": true, "desc": true},
+			config.Map{"name": "recommended", "label": "Recommended", "asc": true, "desc": false, "fields": config.Slice{
+				config.Map{"attributeCode": "inStock", "attributeType": "bool", "desc": true},
+				config.Map{"attributeCode": "price"},
+				config.Map{"attributeCode": "_score"},
+			}},
+		},
+	})
+	require.NoError(t, s.PrepareIndex(context.Background()))
+
+	newProduct := func(marketPlaceCode string, title string, inStock string, price int64) domain.SimpleProduct {
+		return domain.SimpleProduct{
+			Identifier: marketPlaceCode,
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: marketPlaceCode,
+				Title:           title,
+				Attributes: domain.Attributes{
+					"inStock": domain.Attribute{Code: "inStock", RawValue: inStock},
+					"name":    domain.Attribute{Code: "name", RawValue: marketPlaceCode},
+				},
+			},
+			Teaser: domain.TeaserData{
+				TeaserPrice: domain.PriceInfo{Default: commercePriceDomain.NewFromInt(price, 100, "€")},
+			},
+		}
+	}
+	err := s.UpdateProducts(context.Background(), []domain.BasicProduct{
+		newProduct("p1", "shoe", "false", 1000),
+		newProduct("p2", "shoe", "true", 2000),
+		newProduct("p3", "shoe", "true", 1500),
+		newProduct("p4", "shoe shoe", "true", 2000),
+	})
+	require.NoError(t, err)
+
+	marketPlaceCodes := func(result *domain.SearchResult) []string {
+		var codes []string
+		for _, hit := range result.Hits {
+			codes = append(codes, hit.BaseData().MarketPlaceCode)
+		}
+		return codes
+	}
+
+	result, err := s.Find(context.Background(), searchDomain.NewQueryFilter("shoe"), searchDomain.NewSortFilter("recommended", "A"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"p3", "p4", "p2", "p1"}, marketPlaceCodes(result), "in stock first, then cheapest, then best match")
+
+	result, err = s.Find(context.Background(), searchDomain.NewQueryFilter("shoe"), searchDomain.NewSortFilter("name", "D"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"p4", "p3", "p2", "p1"}, marketPlaceCodes(result))
+
+	var nameOption, recommendedOption searchDomain.SortOption
+	for _, sortOption := range result.SearchMeta.SortOptions {
+		switch sortOption.Field {
+		case "name":
+			nameOption = sortOption
+		case "recommended":
+			recommendedOption = sortOption
+		}
+	}
+	assert.True(t, nameOption.SelectedDesc)
+	assert.False(t, nameOption.SelectedAsc)
+	assert.Equal(t, "Recommended", recommendedOption.Label)
+	assert.Equal(t, "recommended", recommendedOption.Asc)
+	assert.Equal(t, "", recommendedOption.Desc)
+	assert.False(t, recommendedOption.SelectedAsc)
+}
+
+func TestBleveRepository_DateAttributes(t *testing.T) {
+	s := &BleveRepository{}
+	s.Inject(flamingo.NullLogger{}, &bleveRepositoryConfig{
+		SortConfig: config.Slice{
+			config.Map{"attributeCode": "releaseDate", "attributeType": "date", "dateLayout": "2006-01-02", "asc": true, "desc": true},
+		},
+	})
+	require.NoError(t, s.PrepareIndex(context.Background()))
+
+	newProduct := func(marketPlaceCode string, releaseDate string) domain.SimpleProduct {
+		return domain.SimpleProduct{
+			Identifier: marketPlaceCode,
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: marketPlaceCode,
+				Title:           marketPlaceCode,
+				Attributes: domain.Attributes{
+					"releaseDate": domain.Attribute{Code: "releaseDate", RawValue: releaseDate},
+				},
+			},
+		}
+	}
+	err := s.UpdateProducts(context.Background(), []domain.BasicProduct{
+		newProduct("p1", "2020-05-01"),
+		newProduct("p2", "2021-01-15"),
+		newProduct("p3", "not a date"),
+		newProduct("p4", "2019-12-31"),
+	})
+	require.NoError(t, err)
+
+	marketPlaceCodes := func(result *domain.SearchResult) []string {
+		var codes []string
+		for _, hit := range result.Hits {
+			codes = append(codes, hit.BaseData().MarketPlaceCode)
+		}
+		return codes
+	}
+
+	result, err := s.Find(context.Background(), searchDomain.NewSortFilter("releaseDate", "D"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"p2", "p1", "p4", "p3"}, marketPlaceCodes(result), "newest first, invalid dates last")
+
+	result, err = s.Find(context.Background(), searchDomain.NewKeyValueFilter("releaseDate", []string{"2020-01-01..2021-01-15"}), searchDomain.NewSortFilter("releaseDate", "A"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"p1"}, marketPlaceCodes(result), "the upper bound is exclusive")
+
+	result, err = s.Find(context.Background(), searchDomain.NewKeyValueFilter("releaseDate", []string{"*..2020-01-01", "2021-01-01..*"}), searchDomain.NewSortFilter("releaseDate", "A"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"p4", "p2"}, marketPlaceCodes(result))
+}
+
+func TestBleveRepository_Visibility(t *testing.T) {
+	s := &BleveRepository{}
+	s.Inject(flamingo.NullLogger{}, &bleveRepositoryConfig{
+		SortConfig: config.Slice{
+			config.Map{"attributeCode": "name", "attributeType": "text", "asc": true, "desc": true},
+		},
+		VisibilityNonSaleable: "hide",
+		VisibilityOutOfStock:  "last",
+	})
+	require.NoError(t, s.PrepareIndex(context.Background()))
+
+	now := time.Now()
+	newProduct := func(title string, saleable domain.Saleable, stockLevel string) domain.SimpleProduct {
+		return domain.SimpleProduct{
+			Identifier: title,
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: title,
+				Title:           title,
+				StockLevel:      stockLevel,
+				Attributes: domain.Attributes{
+					"name": domain.Attribute{Code: "name", RawValue: title},
+				},
+			},
+			Saleable: saleable,
+		}
+	}
+	products := []domain.BasicProduct{
+		newProduct("a", domain.Saleable{IsSaleable: true}, domain.StockLevelInStock),
+		newProduct("b", domain.Saleable{IsSaleable: true}, domain.StockLevelOutOfStock),
+		newProduct("c", domain.Saleable{IsSaleable: true, SaleableFrom: now.Add(-time.Hour), SaleableTo: now.Add(time.Hour)}, ""),
+		newProduct("d", domain.Saleable{IsSaleable: false}, domain.StockLevelInStock),
+		newProduct("e", domain.Saleable{IsSaleable: true, SaleableFrom: now.Add(time.Hour)}, domain.StockLevelInStock),
+		newProduct("f", domain.Saleable{IsSaleable: true, SaleableTo: now.Add(time.Hour)}, domain.StockLevelOutOfStock),
+	}
+	require.NoError(t, s.UpdateProducts(context.Background(), products))
+
+	titles := func(result *domain.SearchResult) []string {
+		var titles []string
+		for _, hit := range result.Hits {
+			titles = append(titles, hit.BaseData().Title)
+		}
+		return titles
+	}
+
+	result, err := s.Find(context.Background(), searchDomain.NewSortFilter("name", "A"), searchDomain.NewPaginationPageSizeFilter(3))
+	require.NoError(t, err)
+	assert.Equal(t, 4, result.SearchMeta.NumResults, "products that are not saleable now are hidden")
+	assert.Equal(t, []string{"a", "c", "b"}, titles(result), "out of stock products are listed last")
+
+	result, err = s.Find(context.Background(), searchDomain.NewSortFilter("name", "A"), searchDomain.NewPaginationPageSizeFilter(3), searchDomain.NewPaginationPageFilter(2))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"f"}, titles(result))
+}
+
+func TestBleveRepository_VariantAttributes(t *testing.T) {
+	s := &BleveRepository{}
+	s.Inject(flamingo.NullLogger{}, &bleveRepositoryConfig{
+		FacetConfig: config.Slice{
+			config.Map{"attributeCode": "color", "amount": 10.0},
+			config.Map{"attributeCode": "price", "type": "range", "ranges": config.Slice{
+				config.Map{"to": 20.0},
+				config.Map{"from": 20.0},
+			}},
+		},
+	})
+	require.NoError(t, s.PrepareIndex(context.Background()))
+
+	newVariant := func(marketPlaceCode string, color string, price int64) domain.Variant {
+		return domain.Variant{
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: marketPlaceCode,
+				Title:           marketPlaceCode,
+				Attributes: domain.Attributes{
+					"color": domain.Attribute{Code: "color", Label: color, RawValue: color},
+				},
+			},
+			Saleable: domain.Saleable{
+				IsSaleable:  true,
+				ActivePrice: domain.PriceInfo{Default: commercePriceDomain.NewFromInt(price, 100, "€")},
+			},
+		}
+	}
+	configurable := domain.ConfigurableProduct{
+		Identifier: "shirt",
+		BasicProductData: domain.BasicProductData{
+			MarketPlaceCode: "shirt",
+			Title:           "shirt",
+			Attributes: domain.Attributes{
+				"brand": domain.Attribute{Code: "brand", Label: "ACME", RawValue: "acme"},
+			},
+		},
+		VariantVariationAttributes: []string{"color"},
+		Variants: []domain.Variant{
+			newVariant("shirt-red", "red", 1000),
+			newVariant("shirt-blue", "blue", 3000),
+		},
+	}
+	simple := domain.SimpleProduct{
+		Identifier: "socks",
+		BasicProductData: domain.BasicProductData{
+			MarketPlaceCode: "socks",
+			Title:           "socks",
+			Attributes: domain.Attributes{
+				"color": domain.Attribute{Code: "color", Label: "red", RawValue: "red"},
+			},
+		},
+		Teaser: domain.TeaserData{
+			TeaserPrice: domain.PriceInfo{Default: commercePriceDomain.NewFromInt(500, 100, "€")},
+		},
+	}
+	require.NoError(t, s.UpdateProducts(context.Background(), []domain.BasicProduct{configurable, simple}))
+
+	result, err := s.Find(context.Background())
+	require.NoError(t, err)
+	require.Len(t, result.Facets["color"].Items, 2)
+	for _, item := range result.Facets["color"].Items {
+		if item.Value == "blue" {
+			assert.Equal(t, int64(1), item.Count, "the variant colors are indexed for the configurable")
+		}
+	}
+	for _, item := range result.Facets["price"].Items {
+		filtered, err := s.Find(context.Background(), searchDomain.NewKeyValueFilter("price", []string{item.Value}))
+		require.NoError(t, err)
+		assert.Equal(t, int64(filtered.SearchMeta.NumResults), item.Count, "the range facet counts the products found by the range filter %s", item.Value)
+	}
+	require.Len(t, result.Facets["price"].Items, 2)
+	assert.Equal(t, int64(2), result.Facets["price"].Items[0].Count, "the configurable is counted in the range of its cheapest variant")
+	assert.Equal(t, int64(1), result.Facets["price"].Items[1].Count, "the configurable is counted in the range of its expensive variant")
+
+	matchedVariantsFilter := commercesearchDomain.NewMatchedVariantsFilter()
+	result, err = s.Find(context.Background(), searchDomain.NewKeyValueFilter("color", []string{"blue"}), matchedVariantsFilter)
+	require.NoError(t, err)
+	require.Len(t, result.Hits, 1)
+	assert.Equal(t, "shirt", result.Hits[0].BaseData().MarketPlaceCode)
+	assert.Equal(t, []string{"shirt-blue"}, matchedVariantsFilter.MatchedVariants().Variants("shirt"))
+
+	matchedVariantsFilter = commercesearchDomain.NewMatchedVariantsFilter()
+	result, err = s.Find(context.Background(), searchDomain.NewKeyValueFilter("price", []string{"20-*"}), matchedVariantsFilter)
+	require.NoError(t, err)
+	require.Len(t, result.Hits, 1, "the price range of the variants is indexed")
+	assert.Equal(t, "shirt", result.Hits[0].BaseData().MarketPlaceCode)
+	assert.Equal(t, []string{"shirt-blue"}, matchedVariantsFilter.MatchedVariants().Variants("shirt"))
+
+	result, err = s.Find(context.Background(), searchDomain.NewKeyValueFilter("price", []string{"*-20"}), searchDomain.NewKeyValueFilter("color", []string{"red"}))
+	require.NoError(t, err)
+	assert.Len(t, result.Hits, 2)
+}
+
+func TestBleveRepository_CollapseVariants(t *testing.T) {
+	newRepository := func(collapseVariants string) *BleveRepository {
+		s := &BleveRepository{}
+		s.Inject(flamingo.NullLogger{}, &bleveRepositoryConfig{
+			FacetConfig: config.Slice{
+				config.Map{"attributeCode": "size", "amount": 10.0},
+			},
+			SortConfig: config.Slice{
+				config.Map{"attributeCode": "name", "attributeType": "text", "asc": true, "desc": true},
+			},
+			CollapseVariants: collapseVariants,
+		})
+		require.NoError(t, s.PrepareIndex(context.Background()))
+		return s
+	}
+
+	newProduct := func(marketPlaceCode string, name string, attributes domain.Attributes) domain.SimpleProduct {
+		attributes["name"] = domain.Attribute{Code: "name", RawValue: name}
+		return domain.SimpleProduct{
+			Identifier: marketPlaceCode,
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: marketPlaceCode,
+				Title:           marketPlaceCode,
+				Attributes:      attributes,
+			},
+		}
+	}
+	newVariant := func(marketPlaceCode string) domain.Variant {
+		return domain.Variant{
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: marketPlaceCode,
+				Title:           marketPlaceCode,
+			},
+		}
+	}
+	products := []domain.BasicProduct{
+		domain.ConfigurableProduct{
+			Identifier: "shirt",
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: "shirt",
+				Title:           "shirt",
+				Attributes: domain.Attributes{
+					"name": domain.Attribute{Code: "name", RawValue: "c"},
+				},
+			},
+			Variants: []domain.Variant{newVariant("shirt-red"), newVariant("shirt-blue")},
+		},
+		newProduct("shirt-red", "a", domain.Attributes{}),
+		newProduct("shirt-blue", "b", domain.Attributes{"size": domain.Attribute{Code: "size", RawValue: "xl"}}),
+		newProduct("socks", "d", domain.Attributes{}),
+	}
+
+	marketPlaceCodes := func(result *domain.SearchResult) []string {
+		var marketPlaceCodes []string
+		for _, hit := range result.Hits {
+			marketPlaceCodes = append(marketPlaceCodes, hit.BaseData().MarketPlaceCode)
+		}
+		return marketPlaceCodes
+	}
+
+	variantRepository := newRepository("variant")
+	require.NoError(t, variantRepository.UpdateProducts(context.Background(), products))
+	result, err := variantRepository.Find(context.Background(), searchDomain.NewSortFilter("name", "A"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.SearchMeta.NumResults, "the configurable product and its variants count once")
+	assert.Equal(t, []string{"shirt-red", "socks"}, marketPlaceCodes(result), "the best matching variant is returned")
+
+	result, err = variantRepository.Find(context.Background(), searchDomain.NewSortFilter("name", "A"), searchDomain.NewPaginationPageSizeFilter(1), searchDomain.NewPaginationPageFilter(2))
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.SearchMeta.NumPages)
+	assert.Equal(t, []string{"socks"}, marketPlaceCodes(result))
+
+	parentRepository := newRepository("parent")
+	require.NoError(t, parentRepository.UpdateProducts(context.Background(), products))
+	result, err = parentRepository.Find(context.Background(), searchDomain.NewSortFilter("name", "A"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.SearchMeta.NumResults)
+	assert.Equal(t, []string{"shirt", "socks"}, marketPlaceCodes(result), "the configurable product replaces its variants")
+
+	result, err = parentRepository.Find(context.Background(), searchDomain.NewKeyValueFilter("size", []string{"xl"}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"shirt"}, marketPlaceCodes(result), "the configurable product is returned if only a variant matches")
+
+	saleVariant := newProduct("shirt-red", "a", domain.Attributes{})
+	saleVariant.MainCategory = domain.CategoryTeaser{Code: "sale"}
+	require.NoError(t, parentRepository.UpdateProducts(context.Background(), []domain.BasicProduct{saleVariant}))
+	result, err = parentRepository.Find(context.Background(), searchDomain.NewKeyValueFilter("category", []string{"sale"}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"shirt-red"}, marketPlaceCodes(result), "the configurable product is not returned outside of its categories")
+
+	reversedRepository := newRepository("variant")
+	for i := len(products) - 1; i >= 0; i-- {
+		require.NoError(t, reversedRepository.UpdateProducts(context.Background(), []domain.BasicProduct{products[i]}))
+	}
+	result, err = reversedRepository.Find(context.Background(), searchDomain.NewSortFilter("name", "A"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.SearchMeta.NumResults, "variants indexed before their configurable product are collapsed")
+	assert.Equal(t, []string{"shirt-red", "socks"}, marketPlaceCodes(result))
+}
+
+func TestBleveRepository_FindVariantByMarketplaceCode(t *testing.T) {
+	s := &BleveRepository{}
+	s.Inject(flamingo.NullLogger{}, &bleveRepositoryConfig{})
+	require.NoError(t, s.PrepareIndex(context.Background()))
+
+	configurable := domain.ConfigurableProduct{
+		Identifier: "shirt",
+		BasicProductData: domain.BasicProductData{
+			MarketPlaceCode: "shirt",
+			Title:           "shirt",
+		},
+		VariantVariationAttributes: []string{"color"},
+		Variants: []domain.Variant{
+			{BasicProductData: domain.BasicProductData{MarketPlaceCode: "shirt-red", Title: "red shirt"}},
+			{BasicProductData: domain.BasicProductData{MarketPlaceCode: "shirt-blue", Title: "blue shirt"}},
+		},
+	}
+	simple := domain.SimpleProduct{
+		Identifier:       "socks",
+		BasicProductData: domain.BasicProductData{MarketPlaceCode: "socks", Title: "socks"},
+	}
+	require.NoError(t, s.UpdateProducts(context.Background(), []domain.BasicProduct{configurable, simple}))
+
+	product, err := s.FindByMarketplaceCode(context.Background(), "shirt-blue")
+	require.NoError(t, err)
+	require.IsType(t, domain.ConfigurableProductWithActiveVariant{}, product)
+	assert.Equal(t, "shirt", product.GetIdentifier())
+	assert.Equal(t, "shirt-blue", product.(domain.ConfigurableProductWithActiveVariant).ActiveVariant.MarketPlaceCode)
+
+	product, err = s.FindByMarketplaceCode(context.Background(), "shirt")
+	require.NoError(t, err)
+	assert.IsType(t, domain.ConfigurableProduct{}, product)
+
+	product, err = s.FindByMarketplaceCode(context.Background(), "socks")
+	require.NoError(t, err)
+	assert.IsType(t, domain.SimpleProduct{}, product)
+
+	_, err = s.FindByMarketplaceCode(context.Background(), "shirt-green")
+	assert.Error(t, err)
+}
+
+func TestBleveRepository_FilterSemantics(t *testing.T) {
+	s := &BleveRepository{}
+	s.Inject(flamingo.NullLogger{}, &bleveRepositoryConfig{
+		FacetConfig: config.Slice{
+			config.Map{"attributeCode": "color", "amount": 10.0},
+			config.Map{"attributeCode": "brand", "amount": 10.0},
+		},
+	})
+	require.NoError(t, s.PrepareIndex(context.Background()))
+
+	newProduct := func(marketPlaceCode string, color string, brand string, category string) domain.SimpleProduct {
+		return domain.SimpleProduct{
+			Identifier: marketPlaceCode,
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: marketPlaceCode,
+				Title:           marketPlaceCode,
+				Categories:      []domain.CategoryTeaser{{Code: category, Parent: &domain.CategoryTeaser{Code: "root"}}},
+				Attributes: domain.Attributes{
+					"color": domain.Attribute{Code: "color", Label: color, RawValue: color},
+					"brand": domain.Attribute{Code: "brand", Label: brand, RawValue: brand},
+				},
+			},
+		}
+	}
+	require.NoError(t, s.UpdateProducts(context.Background(), []domain.BasicProduct{
+		newProduct("a", "red", "acme", "shirts"),
+		newProduct("b", "blue", "acme", "shirts"),
+		newProduct("c", "green", "acme", "pants"),
+		newProduct("d", "red", "other", "pants"),
+	}))
+
+	marketPlaceCodes := func(result *domain.SearchResult) []string {
+		var marketPlaceCodes []string
+		for _, hit := range result.Hits {
+			marketPlaceCodes = append(marketPlaceCodes, hit.BaseData().MarketPlaceCode)
+		}
+		return marketPlaceCodes
+	}
+
+	result, err := s.Find(context.Background(), searchDomain.NewKeyValueFilter("color", []string{"red", "blue"}))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "b", "d"}, marketPlaceCodes(result), "the values of a filter are alternatives")
+
+	result, err = s.Find(context.Background(), searchDomain.NewKeyValueFilter("color", []string{"red", "blue"}), searchDomain.NewKeyValueFilter("brand", []string{"acme"}))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "b"}, marketPlaceCodes(result), "all filters have to match")
+
+	result, err = s.Find(context.Background(), commercesearchDomain.NewExcludeFilter("color", []string{"red", "green"}))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"b"}, marketPlaceCodes(result))
+
+	result, err = s.Find(context.Background(), searchDomain.NewKeyValueFilter("brand", []string{"acme"}), commercesearchDomain.NewExcludeFilter("category", []string{"pants"}))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "b"}, marketPlaceCodes(result))
+}
+
+func TestBleveRepository_TypedSorting(t *testing.T) {
+	s := &BleveRepository{}
+	s.Inject(flamingo.NullLogger{}, &bleveRepositoryConfig{
+		SortConfig: config.Slice{
+			config.Map{"attributeCode": "rank", "attributeType": "numeric", "asc": true, "desc": true},
+			config.Map{"attributeCode": "new", "attributeType": "bool", "asc": true, "desc": true},
+		},
+	})
+	require.NoError(t, s.PrepareIndex(context.Background()))
+
+	newProduct := func(marketPlaceCode string, attributes domain.Attributes, price int64) domain.SimpleProduct {
+		product := domain.SimpleProduct{
+			Identifier: marketPlaceCode,
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: marketPlaceCode,
+				Title:           marketPlaceCode,
+				Attributes:      attributes,
+			},
+		}
+		if price > 0 {
+			product.Teaser.TeaserPrice = domain.PriceInfo{Default: commercePriceDomain.NewFromInt(price, 100, "€")}
+		}
+		return product
+	}
+	require.NoError(t, s.UpdateProducts(context.Background(), []domain.BasicProduct{
+		newProduct("a", domain.Attributes{"rank": domain.Attribute{Code: "rank", RawValue: "100"}, "new": domain.Attribute{Code: "new", RawValue: "true"}}, 1000),
+		newProduct("b", domain.Attributes{"rank": domain.Attribute{Code: "rank", RawValue: "20"}, "new": domain.Attribute{Code: "new", RawValue: "false"}}, 500),
+		newProduct("c", domain.Attributes{}, 0),
+		newProduct("d", domain.Attributes{"rank": domain.Attribute{Code: "rank", RawValue: "3"}, "new": domain.Attribute{Code: "new", RawValue: "true"}}, 2000),
+	}))
+
+	marketPlaceCodes := func(result *domain.SearchResult) []string {
+		var marketPlaceCodes []string
+		for _, hit := range result.Hits {
+			marketPlaceCodes = append(marketPlaceCodes, hit.BaseData().MarketPlaceCode)
+		}
+		return marketPlaceCodes
+	}
+
+	result, err := s.Find(context.Background(), searchDomain.NewSortFilter("rank", "A"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"d", "b", "a", "c"}, marketPlaceCodes(result), "numbers are compared numerically, missing values last")
+
+	result, err = s.Find(context.Background(), searchDomain.NewSortFilter("rank", "D"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "d", "c"}, marketPlaceCodes(result), "missing values are last in both directions")
+
+	result, err = s.Find(context.Background(), searchDomain.NewSortFilter("price", "A"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "a", "d", "c"}, marketPlaceCodes(result))
+
+	result, err = s.Find(context.Background(), searchDomain.NewSortFilter("price", "D"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"d", "a", "b", "c"}, marketPlaceCodes(result))
+
+	result, err = s.Find(context.Background(), searchDomain.NewSortFilter("new", "A"))
+	require.NoError(t, err)
+	hits := marketPlaceCodes(result)
+	require.Len(t, hits, 4)
+	assert.Equal(t, "b", hits[0], "false is sorted before true")
+	assert.Equal(t, "c", hits[3])
+}
+
 func TestBleveRepository_CategorySearch(t *testing.T) {
 
 	s := &BleveRepository{}
@@ -0,0 +1,122 @@
+package commercesearch
+
+import (
+	"fmt"
+
+	categoryDomain "flamingo.me/flamingo-commerce/v3/category/domain"
+	searchDomain "flamingo.me/flamingo-commerce/v3/search/domain"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+type (
+	// categoryFacetConfig defines the facet set of a category, sub categories inherit it
+	categoryFacetConfig struct {
+		CategoryCode string
+		Mode         string
+		Facets       []facetConfig
+	}
+)
+
+const (
+	// categoryFacetModeExtend adds the facets to the inherited facet set, facets of the same attribute are replaced
+	categoryFacetModeExtend = "extend"
+	// categoryFacetModeOverride replaces the inherited facet set
+	categoryFacetModeOverride = "override"
+)
+
+// indexedFacetConfig returns the global facet config and the facets of all categories, the fields of these facets are indexed
+func (r *BleveRepository) indexedFacetConfig() []facetConfig {
+	if len(r.categoryFacetConfig) == 0 {
+		return r.facetConfig
+	}
+	facetConfigs := make([]facetConfig, 0, len(r.facetConfig))
+	facetConfigs = append(facetConfigs, r.facetConfig...)
+	for _, categoryFacetConfig := range r.categoryFacetConfig {
+		facetConfigs = append(facetConfigs, categoryFacetConfig.Facets...)
+	}
+	return facetConfigs
+}
+
+// activeFacetConfig returns the facets of the filtered category, the global facet config applies if no category is filtered
+func (r *BleveRepository) activeFacetConfig(index bleve.Index, filters []searchDomain.Filter) []facetConfig {
+	if len(r.categoryFacetConfig) == 0 {
+		return r.facetConfig
+	}
+
+	var categoryCode string
+	for _, filter := range filters {
+		switch f := filter.(type) {
+		case categoryDomain.CategoryFacet:
+			categoryCode = f.CategoryCode
+		case *categoryDomain.CategoryFacet:
+			categoryCode = f.CategoryCode
+		}
+	}
+	if categoryCode == "" {
+		return r.facetConfig
+	}
+
+	facetConfigs := r.facetConfig
+	categoryCodes := r.categoryAncestorCodes(index, categoryCode)
+	// apply the facet sets from the root category down to the filtered category
+	for i := len(categoryCodes) - 1; i >= 0; i-- {
+		for _, categoryFacetConfig := range r.categoryFacetConfig {
+			if categoryFacetConfig.CategoryCode != categoryCodes[i] {
+				continue
+			}
+			if categoryFacetConfig.Mode == categoryFacetModeOverride {
+				facetConfigs = categoryFacetConfig.Facets
+				continue
+			}
+			facetConfigs = extendFacetConfig(facetConfigs, categoryFacetConfig.Facets)
+		}
+	}
+	return facetConfigs
+}
+
+// categoryAncestorCodes returns the category code followed by the codes of its parents
+func (r *BleveRepository) categoryAncestorCodes(index bleve.Index, categoryCode string) []string {
+	categoryCodes := []string{categoryCode}
+	visited := map[string]bool{categoryCode: true}
+	for {
+		searchRequest := bleve.NewSearchRequestOptions(query.NewDocIDQuery([]string{categoryIDPrefix + categoryCode}), 1, 0, false)
+		searchRequest.Fields = []string{"Category.Parent.Code"}
+		searchResults, err := index.Search(searchRequest)
+		if err != nil || len(searchResults.Hits) != 1 {
+			return categoryCodes
+		}
+		parentCode, ok := searchResults.Hits[0].Fields["Category.Parent.Code"]
+		if !ok {
+			return categoryCodes
+		}
+		// the parent code may be stored more than once
+		if parentCodes, ok := parentCode.([]interface{}); ok && len(parentCodes) > 0 {
+			parentCode = parentCodes[0]
+		}
+		categoryCode = fmt.Sprintf("%v", parentCode)
+		if categoryCode == "" || visited[categoryCode] {
+			return categoryCodes
+		}
+		visited[categoryCode] = true
+		categoryCodes = append(categoryCodes, categoryCode)
+	}
+}
+
+// extendFacetConfig returns the inherited facets with the additional facets, an additional facet replaces the inherited facet of the same attribute
+func extendFacetConfig(inherited []facetConfig, additional []facetConfig) []facetConfig {
+	facetConfigs := make([]facetConfig, 0, len(inherited)+len(additional))
+	for _, inheritedFacetConfig := range inherited {
+		replaced := false
+		for _, additionalFacetConfig := range additional {
+			if additionalFacetConfig.AttributeCode == inheritedFacetConfig.AttributeCode {
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			facetConfigs = append(facetConfigs, inheritedFacetConfig)
+		}
+	}
+	return append(facetConfigs, additional...)
+}
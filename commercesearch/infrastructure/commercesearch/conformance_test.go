@@ -0,0 +1,86 @@
+package commercesearch
+
+import (
+	"testing"
+
+	"flamingo.me/flamingo/v3/framework/config"
+	"flamingo.me/flamingo/v3/framework/flamingo"
+
+	"flamingo.me/flamingo-commerce-adapter-standalone/commercesearch/domain"
+	"flamingo.me/flamingo-commerce-adapter-standalone/commercesearch/domain/repositorytest"
+)
+
+var (
+	conformanceSortConfig = config.Slice{config.Map{"attributeCode": repositorytest.SortAttribute, "attributeType": "numeric", "asc": true, "desc": true}}
+)
+
+// conformanceFacetConfig returns the facet config required by the conformance tests of the features
+func conformanceFacetConfig(features repositorytest.Capabilities) config.Slice {
+	facetConfig := config.Slice{config.Map{"attributeCode": repositorytest.FacetAttribute, "amount": 10.0, "multiSelect": features.MultiSelectFacets}}
+	if features.RangeFacets {
+		facetConfig = append(facetConfig, config.Map{
+			"attributeCode": repositorytest.RangeFacetAttribute,
+			"type":          "range",
+			"ranges": config.Slice{
+				config.Map{"to": 20.0},
+				config.Map{"from": 20.0, "to": 100.0},
+				config.Map{"from": 100.0},
+			},
+		})
+	}
+	return facetConfig
+}
+
+// conformanceVisibility returns the visibility rule for non saleable products required by the conformance tests of the features
+func conformanceVisibility(features repositorytest.Capabilities) string {
+	if features.Visibility {
+		return "hide"
+	}
+	return ""
+}
+
+// conformanceCollapseVariants returns the collapse mode required by the conformance tests of the features
+func conformanceCollapseVariants(features repositorytest.Capabilities) string {
+	if features.CollapseVariants {
+		return "parent"
+	}
+	return ""
+}
+
+func TestBleveRepository_Conformance(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T, features repositorytest.Capabilities) (domain.ProductRepository, domain.CategoryRepository) {
+		s := &BleveRepository{}
+		s.Inject(flamingo.NullLogger{}, &bleveRepositoryConfig{
+			AssignProductsToParentCategories: true,
+			EnableCategoryFacet:              true,
+			FacetConfig:                      conformanceFacetConfig(features),
+			SortConfig:                       conformanceSortConfig,
+			VisibilityNonSaleable:            conformanceVisibility(features),
+			CollapseVariants:                 conformanceCollapseVariants(features),
+		})
+		return s, s
+	}, repositorytest.Capabilities{
+		ParentCategories:  true,
+		MultiSelectFacets: true,
+		RangeFacets:       true,
+		Visibility:        true,
+		CollapseVariants:  true,
+	})
+}
+
+func TestInMemoryProductRepository_Conformance(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T, features repositorytest.Capabilities) (domain.ProductRepository, domain.CategoryRepository) {
+		s := new(InMemoryProductRepository).Inject(flamingo.NullLogger{}, &inMemoryRepositoryConfig{
+			EnableCategoryFacet:   true,
+			FacetConfig:           conformanceFacetConfig(features),
+			SortConfig:            conformanceSortConfig,
+			VisibilityNonSaleable: conformanceVisibility(features),
+			CollapseVariants:      conformanceCollapseVariants(features),
+		})
+		return s, s
+	}, repositorytest.Capabilities{
+		MultiSelectFacets: true,
+		Visibility:        true,
+		CollapseVariants:  true,
+	})
+}
@@ -0,0 +1,77 @@
+package commercesearch
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+type (
+	// dateRange is a date range filter, the lower bound is inclusive and the upper bound exclusive, a zero bound is open
+	dateRange struct {
+		From time.Time
+		To   time.Time
+	}
+)
+
+const (
+	attributeTypeDate = "date"
+	// dateRangeSeparator separates the bounds of a date range filter value, e.g. "2020-01-01T00:00:00Z..*"
+	dateRangeSeparator = ".."
+)
+
+// parseDateValue parses the attribute value with the layout, the default layout is RFC3339
+func parseDateValue(value string, layout string) (time.Time, error) {
+	if layout == "" {
+		layout = time.RFC3339
+	}
+	return time.Parse(layout, strings.TrimSpace(value))
+}
+
+// parseDateRange parses a date range filter value like "2020-01-01T00:00:00Z..2021-01-01T00:00:00Z" or "2020-01-01T00:00:00Z..*"
+func parseDateRange(value string, layout string) (dateRange, error) {
+	separator := strings.Index(value, dateRangeSeparator)
+	if separator < 0 {
+		return dateRange{}, errors.New("invalid date range value " + value)
+	}
+
+	var result dateRange
+	var err error
+	if from := value[:separator]; from != "" && from != rangeOpenBound {
+		result.From, err = parseDateValue(from, layout)
+		if err != nil {
+			return dateRange{}, err
+		}
+	}
+	if to := value[separator+len(dateRangeSeparator):]; to != "" && to != rangeOpenBound {
+		result.To, err = parseDateValue(to, layout)
+		if err != nil {
+			return dateRange{}, err
+		}
+	}
+	if result.From.IsZero() && result.To.IsZero() {
+		return dateRange{}, errors.New("invalid date range value " + value)
+	}
+	return result, nil
+}
+
+// contains checks if the date is within the range
+func (d dateRange) contains(date time.Time) bool {
+	if !d.From.IsZero() && date.Before(d.From) {
+		return false
+	}
+	if !d.To.IsZero() && !date.Before(d.To) {
+		return false
+	}
+	return true
+}
+
+// dateSortField returns the sort field of the date attribute
+func dateSortField(sortConfigs []sortConfig, attributeCode string) (sortField, bool) {
+	for _, field := range indexedSortFields(sortConfigs) {
+		if field.AttributeCode == attributeCode && field.AttributeType == attributeTypeDate {
+			return field, true
+		}
+	}
+	return sortField{}, false
+}
@@ -0,0 +1,28 @@
+package commercesearch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDateRange(t *testing.T) {
+	dateRange, err := parseDateRange("2020-01-01..*", "2006-01-02")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), dateRange.From)
+	assert.True(t, dateRange.To.IsZero())
+	assert.True(t, dateRange.contains(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)), "the lower bound is inclusive")
+	assert.False(t, dateRange.contains(time.Date(2019, 12, 31, 0, 0, 0, 0, time.UTC)))
+
+	dateRange, err = parseDateRange("*..2020-01-01T00:00:00Z", "")
+	require.NoError(t, err)
+	assert.True(t, dateRange.From.IsZero())
+	assert.False(t, dateRange.contains(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)), "the upper bound is exclusive")
+
+	for _, value := range []string{"2020-01-01", "*..*", "..", "2020-13-01..*"} {
+		_, err = parseDateRange(value, "2006-01-02")
+		assert.Error(t, err, value)
+	}
+}
@@ -0,0 +1,85 @@
+package commercesearch
+
+import (
+	"sort"
+	"strings"
+
+	productDomain "flamingo.me/flamingo-commerce/v3/product/domain"
+	searchDomain "flamingo.me/flamingo-commerce/v3/search/domain"
+)
+
+const (
+	facetSortCount  = "count"
+	facetSortAlpha  = "alpha"
+	facetSortCustom = "custom"
+)
+
+// applyFacetPresentation applies label, position, item sorting and visibility of the facetConfig to the result facets
+func applyFacetPresentation(facetConfigs []facetConfig, result *productDomain.SearchResult) {
+	for _, facetConfig := range facetConfigs {
+		facet, ok := result.Facets[facetConfig.AttributeCode]
+		if !ok {
+			continue
+		}
+
+		if facetConfig.Label != "" {
+			facet.Label = facetConfig.Label
+		}
+		facet.Position = facetConfig.Position
+		facet.Items = filterFacetItems(facet.Items, facetConfig.MinCount)
+		if facetConfig.Type != facetTypeRange {
+			sortFacetItems(facet.Items, facetConfig)
+		}
+
+		if facetConfig.HideSingleValue && (len(facet.Items) == 0 || (len(facet.Items) == 1 && !facet.Items[0].Selected)) {
+			delete(result.Facets, facetConfig.AttributeCode)
+			continue
+		}
+		result.Facets[facetConfig.AttributeCode] = facet
+	}
+}
+
+// filterFacetItems removes items with less than minCount hits, selected items are always kept
+func filterFacetItems(items []*searchDomain.FacetItem, minCount int) []*searchDomain.FacetItem {
+	if minCount <= 0 {
+		return items
+	}
+	var filteredItems []*searchDomain.FacetItem
+	for _, item := range items {
+		if item.Count >= int64(minCount) || item.Selected {
+			filteredItems = append(filteredItems, item)
+		}
+	}
+	return filteredItems
+}
+
+// sortFacetItems sorts the items by count (default), alphabetically or by the configured custom order
+func sortFacetItems(items []*searchDomain.FacetItem, facetConfig facetConfig) {
+	switch facetConfig.Sort {
+	case facetSortAlpha:
+		sort.SliceStable(items, func(i, j int) bool {
+			return strings.ToLower(items[i].Label) < strings.ToLower(items[j].Label)
+		})
+	case facetSortCustom:
+		position := make(map[string]int, len(facetConfig.Order))
+		for i, value := range facetConfig.Order {
+			position[value] = i
+		}
+		// values missing in the custom order are put at the end, ordered by count
+		sort.SliceStable(items, func(i, j int) bool {
+			positionI, okI := position[items[i].Value]
+			positionJ, okJ := position[items[j].Value]
+			if okI && okJ {
+				return positionI < positionJ
+			}
+			if okI != okJ {
+				return okI
+			}
+			return items[i].Count > items[j].Count
+		})
+	default:
+		sort.SliceStable(items, func(i, j int) bool {
+			return items[i].Count > items[j].Count
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package commercesearch
+
+import (
+	"testing"
+
+	productDomain "flamingo.me/flamingo-commerce/v3/product/domain"
+	searchDomain "flamingo.me/flamingo-commerce/v3/search/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyFacetPresentation(t *testing.T) {
+	newResult := func() *productDomain.SearchResult {
+		return &productDomain.SearchResult{
+			Result: searchDomain.Result{
+				Facets: searchDomain.FacetCollection{
+					"size": searchDomain.Facet{
+						Name:  "size",
+						Label: "size",
+						Items: []*searchDomain.FacetItem{
+							{Label: "M", Value: "m", Count: 5},
+							{Label: "xl", Value: "xl", Count: 3},
+							{Label: "S", Value: "s", Count: 1},
+						},
+					},
+					"brand": searchDomain.Facet{
+						Name:  "brand",
+						Label: "brand",
+						Items: []*searchDomain.FacetItem{
+							{Label: "apple", Value: "apple", Count: 2},
+						},
+					},
+				},
+			},
+		}
+	}
+	facetValues := func(facet searchDomain.Facet) []string {
+		var values []string
+		for _, item := range facet.Items {
+			values = append(values, item.Value)
+		}
+		return values
+	}
+
+	t.Run("Label, position and custom order", func(t *testing.T) {
+		facetConfigs := []facetConfig{
+			{AttributeCode: "size", Label: "facet.size", Position: 2, Sort: facetSortCustom, Order: []string{"s", "m"}},
+		}
+		result := newResult()
+		applyFacetPresentation(facetConfigs, result)
+		assert.Equal(t, "facet.size", result.Facets["size"].Label)
+		assert.Equal(t, 2, result.Facets["size"].Position)
+		assert.Equal(t, []string{"s", "m", "xl"}, facetValues(result.Facets["size"]))
+	})
+
+	t.Run("Alphabetical order and min count", func(t *testing.T) {
+		facetConfigs := []facetConfig{
+			{AttributeCode: "size", Sort: facetSortAlpha, MinCount: 2},
+		}
+		result := newResult()
+		applyFacetPresentation(facetConfigs, result)
+		assert.Equal(t, []string{"m", "xl"}, facetValues(result.Facets["size"]))
+	})
+
+	t.Run("Hide single value", func(t *testing.T) {
+		facetConfigs := []facetConfig{
+			{AttributeCode: "brand", HideSingleValue: true},
+		}
+		result := newResult()
+		applyFacetPresentation(facetConfigs, result)
+		assert.NotContains(t, result.Facets, "brand")
+
+		result = newResult()
+		result.Facets["brand"].Items[0].Selected = true
+		applyFacetPresentation(facetConfigs, result)
+		assert.Contains(t, result.Facets, "brand", "selected values stay visible")
+	})
+}
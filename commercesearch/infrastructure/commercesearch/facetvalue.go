@@ -0,0 +1,93 @@
+package commercesearch
+
+import (
+	"fmt"
+	"strings"
+
+	productDomain "flamingo.me/flamingo-commerce/v3/product/domain"
+)
+
+type (
+	// facetValue is a stable value of an attribute used for faceting and filtering together with its display label
+	facetValue struct {
+		Value string
+		Label string
+	}
+)
+
+// attributeFacetValues returns the values of the attribute, multi value attributes return one value per entry
+func attributeFacetValues(attribute productDomain.Attribute) []facetValue {
+	if attribute.HasMultipleValues() {
+		entries, _ := attribute.RawValue.([]interface{})
+		var facetValues []facetValue
+		for _, entry := range entries {
+			value, label := multiValueEntry(entry)
+			if value == "" {
+				continue
+			}
+			// the attribute label belongs to the value if there is only one
+			if label == "" && len(entries) == 1 {
+				label = strings.TrimSpace(attribute.Label)
+			}
+			if label == "" {
+				label = value
+			}
+			facetValues = append(facetValues, facetValue{Value: value, Label: label})
+		}
+		return facetValues
+	}
+
+	value := attribute.Value()
+	if value == "" || attribute.RawValue == nil {
+		value = attribute.Label
+	}
+	if value == "" {
+		return nil
+	}
+	label := attribute.Label
+	if label == "" {
+		label = value
+	}
+	return []facetValue{{Value: value, Label: label}}
+}
+
+// multiValueEntry returns the value and label of an entry of a multi value attribute. Entries with their own label
+// are maps with the keys "value" and "label" (e.g. decoded from JSON), other entries have no label
+func multiValueEntry(entry interface{}) (string, string) {
+	switch e := entry.(type) {
+	case map[string]interface{}:
+		value, _ := e["value"].(string)
+		label, _ := e["label"].(string)
+		return strings.TrimSpace(value), strings.TrimSpace(label)
+	case map[string]string:
+		return strings.TrimSpace(e["value"]), strings.TrimSpace(e["label"])
+	case nil:
+		return "", ""
+	}
+	return strings.TrimSpace(fmt.Sprintf("%v", entry)), ""
+}
+
+// addFacetValueLabel remembers the display label of an indexed facet value
+func (r *BleveRepository) addFacetValueLabel(attributeCode string, value string, label string) {
+	r.facetValueLabelsMutex.Lock()
+	defer r.facetValueLabelsMutex.Unlock()
+
+	if r.facetValueLabels == nil {
+		r.facetValueLabels = make(map[string]map[string]string)
+	}
+	if r.facetValueLabels[attributeCode] == nil {
+		r.facetValueLabels[attributeCode] = make(map[string]string)
+	}
+	r.facetValueLabels[attributeCode][value] = label
+}
+
+// facetValueLabel returns the display label of an indexed facet value, the value itself if the label is unknown
+func (r *BleveRepository) facetValueLabel(attributeCode string, value string) string {
+	r.facetValueLabelsMutex.RLock()
+	defer r.facetValueLabelsMutex.RUnlock()
+
+	if label, ok := r.facetValueLabels[attributeCode][value]; ok {
+		return label
+	}
+	return value
+}
@@ -0,0 +1,130 @@
+package commercesearch
+
+import (
+	"math"
+	"sort"
+
+	productDomain "flamingo.me/flamingo-commerce/v3/product/domain"
+)
+
+type (
+	// fullTextIndex is an inverted index over the searchable texts of the products, e.g. all market place codes with 'shoe' in the title or description
+	fullTextIndex struct {
+		// postings weighted term frequency by market place code for each term
+		postings map[string]map[string]float64
+		// documentTerms terms of each product, used to remove the postings of a product
+		documentTerms map[string][]string
+	}
+
+	// fullTextField is a searchable text of a product together with the weight of its terms
+	fullTextField struct {
+		Text   string
+		Weight float64
+	}
+)
+
+const (
+	fullTextWeightTitle       = 3.0
+	fullTextWeightKeywords    = 2.0
+	fullTextWeightAttributes  = 1.5
+	fullTextWeightDescription = 1.0
+)
+
+// productFullTextFields returns the searchable texts of the product, attributes include the values of the variants
+func productFullTextFields(product productDomain.BasicProduct, attributeCodes []string) []fullTextField {
+	data := product.BaseData()
+	fields := []fullTextField{
+		{Text: data.Title, Weight: fullTextWeightTitle},
+		{Text: data.ShortDescription, Weight: fullTextWeightDescription},
+		{Text: data.Description, Weight: fullTextWeightDescription},
+	}
+	for _, keyword := range data.Keywords {
+		fields = append(fields, fullTextField{Text: keyword, Weight: fullTextWeightKeywords})
+	}
+	for _, attributeCode := range attributeCodes {
+		for _, value := range productFacetValues(product, attributeCode) {
+			fields = append(fields, fullTextField{Text: value.Label, Weight: fullTextWeightAttributes})
+		}
+	}
+	return fields
+}
+
+// add the texts of the product, the previous texts of the product are replaced
+func (i *fullTextIndex) add(marketPlaceCode string, fields []fullTextField) {
+	i.remove(marketPlaceCode)
+	if i.postings == nil {
+		i.postings = make(map[string]map[string]float64)
+		i.documentTerms = make(map[string][]string)
+	}
+
+	terms := []string{}
+	for _, field := range fields {
+		for _, term := range queryTerms(field.Text) {
+			if i.postings[term] == nil {
+				i.postings[term] = make(map[string]float64)
+			}
+			if _, ok := i.postings[term][marketPlaceCode]; !ok {
+				terms = append(terms, term)
+			}
+			i.postings[term][marketPlaceCode] += field.Weight
+		}
+	}
+	i.documentTerms[marketPlaceCode] = terms
+}
+
+// remove the texts of the product
+func (i *fullTextIndex) remove(marketPlaceCode string) {
+	for _, term := range i.documentTerms[marketPlaceCode] {
+		delete(i.postings[term], marketPlaceCode)
+		if len(i.postings[term]) == 0 {
+			delete(i.postings, term)
+		}
+	}
+	delete(i.documentTerms, marketPlaceCode)
+}
+
+// search returns the relevance score of each product matching the terms, with operator "and" all terms have to match
+// terms found in few products and in heavy weighted texts (title, keywords) score higher
+func (i *fullTextIndex) search(terms []string, operator string) map[string]float64 {
+	scores := make(map[string]float64)
+	matchedTerms := make(map[string]int)
+	uniqueTerms := make(map[string]bool)
+	for _, term := range terms {
+		if uniqueTerms[term] {
+			continue
+		}
+		uniqueTerms[term] = true
+
+		postings := i.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		inverseDocumentFrequency := math.Log(1 + float64(len(i.documentTerms))/float64(len(postings)))
+		for marketPlaceCode, termFrequency := range postings {
+			scores[marketPlaceCode] += math.Sqrt(termFrequency) * inverseDocumentFrequency
+			matchedTerms[marketPlaceCode]++
+		}
+	}
+
+	if operator == queryOperatorAnd {
+		for marketPlaceCode := range scores {
+			if matchedTerms[marketPlaceCode] < len(uniqueTerms) {
+				delete(scores, marketPlaceCode)
+			}
+		}
+	}
+	return scores
+}
+
+// dictionary returns all indexed terms with their document frequency
+func (i *fullTextIndex) dictionary() []dictionaryTerm {
+	dictionary := make([]dictionaryTerm, 0, len(i.postings))
+	for term, postings := range i.postings {
+		dictionary = append(dictionary, dictionaryTerm{Term: term, Count: uint64(len(postings))})
+	}
+	// a stable order keeps the spelling suggestions stable for terms with the same frequency
+	sort.Slice(dictionary, func(i, j int) bool {
+		return dictionary[i].Term < dictionary[j].Term
+	})
+	return dictionary
+}
@@ -0,0 +1,45 @@
+package commercesearch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFullTextIndex(t *testing.T) {
+	var index fullTextIndex
+	index.add("shoe", []fullTextField{
+		{Text: "Running Shoe", Weight: fullTextWeightTitle},
+		{Text: "A light shoe for running", Weight: fullTextWeightDescription},
+	})
+	index.add("shirt", []fullTextField{
+		{Text: "Running Shirt", Weight: fullTextWeightTitle},
+	})
+	index.add("socks", []fullTextField{
+		{Text: "Socks", Weight: fullTextWeightTitle},
+		{Text: "fit your running shoe", Weight: fullTextWeightDescription},
+	})
+
+	scores := index.search([]string{"running", "shoe"}, queryOperatorOr)
+	assert.Len(t, scores, 3)
+	assert.True(t, scores["shoe"] > scores["socks"], "terms in the title score higher")
+	assert.True(t, scores["socks"] > scores["shirt"], "products matching more terms score higher")
+
+	scores = index.search([]string{"running", "shoe"}, queryOperatorAnd)
+	assert.Len(t, scores, 2)
+	assert.NotContains(t, scores, "shirt")
+
+	index.add("socks", []fullTextField{{Text: "Socks", Weight: fullTextWeightTitle}})
+	assert.Len(t, index.search([]string{"shoe"}, queryOperatorOr), 1, "the previous texts are replaced")
+
+	index.remove("shirt")
+	assert.Empty(t, index.search([]string{"shirt"}, queryOperatorOr))
+	assert.Equal(t, []dictionaryTerm{
+		{Term: "a", Count: 1},
+		{Term: "for", Count: 1},
+		{Term: "light", Count: 1},
+		{Term: "running", Count: 1},
+		{Term: "shoe", Count: 1},
+		{Term: "socks", Count: 1},
+	}, index.dictionary())
+}
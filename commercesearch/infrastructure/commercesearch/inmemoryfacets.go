@@ -0,0 +1,97 @@
+package commercesearch
+
+import (
+	"sort"
+
+	categoryDomain "flamingo.me/flamingo-commerce/v3/category/domain"
+	searchDomain "flamingo.me/flamingo-commerce/v3/search/domain"
+)
+
+// facets returns the category tree facet and the configured list facets for the matching products,
+// the values are counted by intersecting their reverse index entries with the matching codes. Multi-select facets
+// with a filter are counted with their entry of facetMatchingCodes
+func (r *InMemoryProductRepository) facets(matchingCodes sortedCodes, facetMatchingCodes map[string]sortedCodes) searchDomain.FacetCollection {
+	facetCollection := make(searchDomain.FacetCollection)
+	if r.enableCategoryFacet && r.rootCategory != nil {
+		matchingCodeSet := make(map[string]struct{}, len(matchingCodes))
+		for _, marketPlaceCode := range matchingCodes {
+			matchingCodeSet[marketPlaceCode] = struct{}{}
+		}
+		items, _ := categoryTreeFacetItems(r.rootCategory.SubTreesData, r.productsByCategoriesReverseIndex, matchingCodeSet)
+		facetCollection["category"] = searchDomain.Facet{
+			Type:     searchDomain.TreeFacet,
+			Name:     "category",
+			Label:    "category",
+			Items:    items,
+			Position: 0,
+		}
+	}
+
+	for _, facetConfig := range r.facetConfig {
+		facet := searchDomain.Facet{
+			Type:     searchDomain.ListFacet,
+			Name:     facetConfig.AttributeCode,
+			Label:    facetConfig.AttributeCode,
+			Position: 0,
+		}
+		codes := matchingCodes
+		if multiSelectCodes, ok := facetMatchingCodes[facetConfig.AttributeCode]; ok {
+			codes = multiSelectCodes
+		}
+		for value, marketPlaceCodes := range r.attributeReverseIndex[facetConfig.AttributeCode] {
+			count := marketPlaceCodes.intersectionCount(codes)
+			if count == 0 {
+				continue
+			}
+			label := value
+			if indexedLabel, ok := r.facetValueLabels[facetConfig.AttributeCode][value]; ok {
+				label = indexedLabel
+			}
+			facet.Items = append(facet.Items, &searchDomain.FacetItem{
+				Label: label,
+				Value: value,
+				Count: int64(count),
+			})
+		}
+		// like the term facets of bleve: the most frequent values up to the configured amount
+		sort.Slice(facet.Items, func(i, j int) bool {
+			if facet.Items[i].Count != facet.Items[j].Count {
+				return facet.Items[i].Count > facet.Items[j].Count
+			}
+			return facet.Items[i].Value < facet.Items[j].Value
+		})
+		if facetConfig.Amount > 0 && len(facet.Items) > facetConfig.Amount {
+			facet.Items = facet.Items[:facetConfig.Amount]
+		}
+		facetCollection[facetConfig.AttributeCode] = facet
+	}
+	return facetCollection
+}
+
+// categoryTreeFacetItems returns the items of the categories with matching products,
+// the count of a category includes the products of its sub categories once
+func categoryTreeFacetItems(trees []*categoryDomain.TreeData, productsByCategory map[string]sortedCodes, matchingCodes map[string]struct{}) ([]*searchDomain.FacetItem, map[string]struct{}) {
+	var items []*searchDomain.FacetItem
+	treesCodes := make(map[string]struct{})
+	for _, tree := range trees {
+		subItems, codes := categoryTreeFacetItems(tree.SubTreesData, productsByCategory, matchingCodes)
+		for _, marketPlaceCode := range productsByCategory[tree.CategoryCode] {
+			if _, ok := matchingCodes[marketPlaceCode]; ok {
+				codes[marketPlaceCode] = struct{}{}
+			}
+		}
+		if len(codes) == 0 {
+			continue
+		}
+		for marketPlaceCode := range codes {
+			treesCodes[marketPlaceCode] = struct{}{}
+		}
+		items = append(items, &searchDomain.FacetItem{
+			Label: tree.CategoryName,
+			Value: tree.CategoryCode,
+			Count: int64(len(codes)),
+			Items: subItems,
+		})
+	}
+	return items, treesCodes
+}
@@ -1,13 +1,18 @@
 package commercesearch
 
 import (
+	"container/heap"
 	"context"
 	"errors"
 	"fmt"
 	"math"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"flamingo.me/flamingo/v3/framework/config"
 	"flamingo.me/flamingo/v3/framework/flamingo"
 
 	"flamingo.me/flamingo-commerce-adapter-standalone/commercesearch/domain"
@@ -25,22 +30,64 @@ type (
 		// marketplaceCodeIndex index to get products from marketplaceCode, e.g. get product for market place code 'foobar'
 		marketplaceCodeIndex map[string]productDomain.BasicProduct
 
+		// marketplaceCodes all indexed market place codes, the result of a search without filters
+		marketplaceCodes sortedCodes
+
 		// attributeReverseIndex index to get all market place codes for a certain attribute, e.g. all market place codes with attribute 'size' and value 'large'
-		attributeReverseIndex map[string]map[string][]string
+		attributeReverseIndex map[string]map[string]sortedCodes
 
 		// productsByCategoriesReverseIndex index to get all market place codes for a categoryCode, e.g. all market place codes with category 'clothing'
-		productsByCategoriesReverseIndex map[string][]string
-		addReadMutex                     sync.RWMutex
+		productsByCategoriesReverseIndex map[string]sortedCodes
+
+		// suggestTerms sorted list of all title terms, used for prefix lookups (search-as-you-type)
+		suggestTerms []string
+
+		// suggestTermReverseIndex index to get all market place codes for a title term, e.g. all market place codes with 'shoe' in the title
+		suggestTermReverseIndex map[string]sortedCodes
+
+		// facetValueLabels display labels of the facet values by attribute code and value
+		facetValueLabels map[string]map[string]string
+
+		// fullTextIndex index to get the market place codes and relevance scores for the terms of a QueryFilter
+		fullTextIndex fullTextIndex
+		addReadMutex  sync.RWMutex
 
 		// for category adapters:
 		rootCategory      *categoryDomain.TreeData
 		categoryTreeIndex map[string]*categoryDomain.TreeData
 
+		enableCategoryFacet bool
+		facetConfig         []facetConfig
+		sortConfig          []sortConfig
+		queryConfig         queryConfig
+		queryAttributes     []string
+		visibilityConfig    visibilityConfig
+		collapseVariants    string
+		snapshotConfig      snapshotConfig
+
+		// variantParents index to get the configurable product of a variant
+		variantParents variantParentIndex
+
 		logger flamingo.Logger
 	}
 
+	// productHeap is a max heap of products in the given order, see firstSortedProducts
+	productHeap struct {
+		products []productDomain.BasicProduct
+		less     func(a, b productDomain.BasicProduct) bool
+	}
+
+	snapshotConfig struct {
+		// File path of the snapshot
+		File string
+		// Restore the snapshot on startup instead of running the loader
+		Restore bool
+		// SaveAfterIndexing saves the snapshot after the loader indexed all products
+		SaveAfterIndexing bool
+	}
+
 	marketPlaceCodeSet struct {
-		currentSet    []string
+		currentSet    sortedCodes
 		initialFilled bool
 	}
 )
@@ -48,6 +95,8 @@ type (
 var (
 	_ domain.ProductRepository  = &InMemoryProductRepository{}
 	_ domain.CategoryRepository = &InMemoryProductRepository{}
+	_ domain.SuggestRepository  = &InMemoryProductRepository{}
+	_ domain.SnapshotRepository = &InMemoryProductRepository{}
 )
 
 // PrepareIndex implementation
@@ -56,8 +105,66 @@ func (r *InMemoryProductRepository) PrepareIndex(_ context.Context) error {
 }
 
 // Inject dependencies
-func (r *InMemoryProductRepository) Inject(logger flamingo.Logger) {
+func (r *InMemoryProductRepository) Inject(logger flamingo.Logger, config *struct {
+	SortConfig               config.Slice `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.inMemoryAdapter.sortConfig,optional"`
+	EnableCategoryFacet      bool         `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.inMemoryAdapter.enableCategoryFacet,optional"`
+	FacetConfig              config.Slice `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.inMemoryAdapter.facetConfig,optional"`
+	QueryAttributes          config.Slice `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.inMemoryAdapter.query.attributes,optional"`
+	QueryOperator            string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.inMemoryAdapter.query.operator,optional"`
+	QuerySuggestionThreshold float64      `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.inMemoryAdapter.query.suggestionThreshold,optional"`
+	VisibilityNonSaleable    string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.visibility.nonSaleable,optional"`
+	VisibilityOutOfStock     string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.visibility.outOfStock,optional"`
+	CollapseVariants         string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.collapseVariants,optional"`
+	SnapshotFile             string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.inMemoryAdapter.snapshot.file,optional"`
+	SnapshotRestore          bool         `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.inMemoryAdapter.snapshot.restore,optional"`
+	SnapshotSaveAfterIndex   bool         `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.inMemoryAdapter.snapshot.saveAfterIndexing,optional"`
+}) *InMemoryProductRepository {
 	r.logger = logger.WithField(flamingo.LogKeyModule, "flamingo-commerce-adapter-standalone").WithField(flamingo.LogKeyCategory, "InMemoryProductRepository")
+	r.queryConfig = queryConfig{
+		Operator:              queryOperatorOr,
+		TypoPrefixLength:      1,
+		MinTermLengthOneTypo:  4,
+		MinTermLengthTwoTypos: 8,
+	}
+	if config != nil {
+		var sortConfig []sortConfig
+		err := config.SortConfig.MapInto(&sortConfig)
+		if err != nil {
+			panic(err)
+		}
+		r.sortConfig = sortConfig
+
+		r.enableCategoryFacet = config.EnableCategoryFacet
+		var facetConfig []facetConfig
+		err = config.FacetConfig.MapInto(&facetConfig)
+		if err != nil {
+			panic(err)
+		}
+		r.facetConfig = supportedListFacetConfig(r.logger, facetConfig, true)
+
+		var queryAttributes []string
+		err = config.QueryAttributes.MapInto(&queryAttributes)
+		if err != nil {
+			panic(err)
+		}
+		r.queryAttributes = queryAttributes
+		if config.QueryOperator != "" {
+			r.queryConfig.Operator = config.QueryOperator
+		}
+		r.queryConfig.SuggestionThreshold = int(config.QuerySuggestionThreshold)
+
+		r.visibilityConfig = visibilityConfig{
+			NonSaleable: config.VisibilityNonSaleable,
+			OutOfStock:  config.VisibilityOutOfStock,
+		}
+		r.collapseVariants = config.CollapseVariants
+		r.snapshotConfig = snapshotConfig{
+			File:              config.SnapshotFile,
+			Restore:           config.SnapshotRestore,
+			SaveAfterIndexing: config.SnapshotSaveAfterIndex,
+		}
+	}
+	return r
 }
 
 // DocumentsCount returns the number of documents in the index
@@ -104,63 +211,156 @@ func (r *InMemoryProductRepository) UpdateProducts(_ context.Context, products [
 		}
 		marketPlaceCode := product.BaseData().MarketPlaceCode
 
-		err := r.addProductToMarketplaceCodeReverseIndex(marketPlaceCode, product)
-		if err != nil {
-			return err
+		// an already indexed product is replaced
+		if indexedProduct, ok := r.marketplaceCodeIndex[marketPlaceCode]; ok {
+			r.removeMarketplaceCodeFromReverseIndexes(indexedProduct, marketPlaceCode)
 		}
+		r.addProductToMarketplaceCodeReverseIndex(marketPlaceCode, product)
 
 		r.addMarketplaceCodeToCategoryReverseIndex(product, marketPlaceCode)
 		r.addMarketplaceCodeToAttributeReverseIndex(product, marketPlaceCode)
+		r.addMarketplaceCodeToSuggestReverseIndex(product, marketPlaceCode)
+		r.fullTextIndex.add(marketPlaceCode, productFullTextFields(product, r.queryAttributes))
+		r.variantParents.add(product)
 	}
 
 	return nil
 }
 
+func (r *InMemoryProductRepository) addMarketplaceCodeToSuggestReverseIndex(product productDomain.BasicProduct, marketPlaceCode string) {
+	if r.suggestTermReverseIndex == nil {
+		r.suggestTermReverseIndex = make(map[string]sortedCodes)
+	}
+
+	addedTerms := make(map[string]struct{})
+	for _, term := range queryTerms(product.BaseData().Title) {
+		term = truncateSuggestTerm(term)
+		if _, ok := addedTerms[term]; ok {
+			continue
+		}
+		addedTerms[term] = struct{}{}
+
+		if _, ok := r.suggestTermReverseIndex[term]; !ok {
+			// keep the terms sorted for prefix lookups
+			i := sort.SearchStrings(r.suggestTerms, term)
+			r.suggestTerms = append(r.suggestTerms, "")
+			copy(r.suggestTerms[i+1:], r.suggestTerms[i:])
+			r.suggestTerms[i] = term
+		}
+		r.suggestTermReverseIndex[term] = r.suggestTermReverseIndex[term].add(marketPlaceCode)
+	}
+}
+
+// suggestTermsWithPrefix returns all indexed title terms starting with the given prefix
+func (r *InMemoryProductRepository) suggestTermsWithPrefix(prefix string) []string {
+	var terms []string
+	for i := sort.SearchStrings(r.suggestTerms, prefix); i < len(r.suggestTerms) && strings.HasPrefix(r.suggestTerms[i], prefix); i++ {
+		terms = append(terms, r.suggestTerms[i])
+	}
+	return terms
+}
+
 func (r *InMemoryProductRepository) addMarketplaceCodeToAttributeReverseIndex(product productDomain.BasicProduct, marketPlaceCode string) {
 	if r.attributeReverseIndex == nil {
-		r.attributeReverseIndex = make(map[string]map[string][]string)
+		r.attributeReverseIndex = make(map[string]map[string]sortedCodes)
+		r.facetValueLabels = make(map[string]map[string]string)
+	}
+	// configurable products are indexed with the attribute values of their variants
+	attributeCodes := make(map[string]struct{})
+	for attributeCode := range product.BaseData().Attributes {
+		attributeCodes[attributeCode] = struct{}{}
+	}
+	for _, variant := range productVariants(product) {
+		for attributeCode := range variant.Attributes {
+			attributeCodes[attributeCode] = struct{}{}
+		}
 	}
-	for _, attribute := range product.BaseData().Attributes {
-		if _, ok := r.attributeReverseIndex[attribute.Code]; !ok {
-			r.attributeReverseIndex[attribute.Code] = make(map[string][]string)
+	for attributeCode := range attributeCodes {
+		if _, ok := r.attributeReverseIndex[attributeCode]; !ok {
+			r.attributeReverseIndex[attributeCode] = make(map[string]sortedCodes)
+			r.facetValueLabels[attributeCode] = make(map[string]string)
+		}
+		for _, facetValue := range productFacetValues(product, attributeCode) {
+			r.facetValueLabels[attributeCode][facetValue.Value] = facetValue.Label
+			r.attributeReverseIndex[attributeCode][facetValue.Value] = r.attributeReverseIndex[attributeCode][facetValue.Value].add(marketPlaceCode)
 		}
-		r.attributeReverseIndex[attribute.Code][attribute.Value()] = append(r.attributeReverseIndex[attribute.Code][attribute.Value()], marketPlaceCode)
 	}
 }
 
 func (r *InMemoryProductRepository) addMarketplaceCodeToCategoryReverseIndex(product productDomain.BasicProduct, marketPlaceCode string) {
 	if r.productsByCategoriesReverseIndex == nil {
-		r.productsByCategoriesReverseIndex = make(map[string][]string)
+		r.productsByCategoriesReverseIndex = make(map[string]sortedCodes)
 	}
 
 	for _, categoryTeaser := range product.BaseData().Categories {
-		r.productsByCategoriesReverseIndex[categoryTeaser.Code] = append(r.productsByCategoriesReverseIndex[categoryTeaser.Code], marketPlaceCode)
+		r.productsByCategoriesReverseIndex[categoryTeaser.Code] = r.productsByCategoriesReverseIndex[categoryTeaser.Code].add(marketPlaceCode)
 	}
 	if product.BaseData().MainCategory.Code != "" {
-		if !inSlice(r.productsByCategoriesReverseIndex[product.BaseData().MainCategory.Code], marketPlaceCode) {
-			r.productsByCategoriesReverseIndex[product.BaseData().MainCategory.Code] = append(r.productsByCategoriesReverseIndex[product.BaseData().MainCategory.Code], marketPlaceCode)
-		}
+		r.productsByCategoriesReverseIndex[product.BaseData().MainCategory.Code] = r.productsByCategoriesReverseIndex[product.BaseData().MainCategory.Code].add(marketPlaceCode)
 	}
 }
 
-func (r *InMemoryProductRepository) addProductToMarketplaceCodeReverseIndex(marketPlaceCode string, product productDomain.BasicProduct) error {
+func (r *InMemoryProductRepository) addProductToMarketplaceCodeReverseIndex(marketPlaceCode string, product productDomain.BasicProduct) {
 	// Set reverse index for marketplaceCode (the primary identifier)
 	if r.marketplaceCodeIndex == nil {
 		r.marketplaceCodeIndex = make(map[string]productDomain.BasicProduct)
 	}
-	if r.marketplaceCodeIndex[marketPlaceCode] != nil {
-		err := errors.New("Duplicate for marketplace code " + marketPlaceCode)
-		r.logger.Error(err)
-		return err
-	}
 	r.marketplaceCodeIndex[marketPlaceCode] = product
-	return nil
+	r.marketplaceCodes = r.marketplaceCodes.add(marketPlaceCode)
 }
 
-// FindByMarketplaceCode returns a product struct for the given marketplaceCode
+// removeMarketplaceCodeFromReverseIndexes removes the entries of the indexed product from the category, attribute and suggest
+// reverse indexes, so that a replaced product is not found by its previous values
+func (r *InMemoryProductRepository) removeMarketplaceCodeFromReverseIndexes(product productDomain.BasicProduct, marketPlaceCode string) {
+	categoryCodes := []string{product.BaseData().MainCategory.Code}
+	for _, categoryTeaser := range product.BaseData().Categories {
+		categoryCodes = append(categoryCodes, categoryTeaser.Code)
+	}
+	for _, categoryCode := range categoryCodes {
+		if codes, ok := r.productsByCategoriesReverseIndex[categoryCode]; ok {
+			r.productsByCategoriesReverseIndex[categoryCode] = codes.remove(marketPlaceCode)
+			if len(r.productsByCategoriesReverseIndex[categoryCode]) == 0 {
+				delete(r.productsByCategoriesReverseIndex, categoryCode)
+			}
+		}
+	}
+
+	for attributeCode, values := range r.attributeReverseIndex {
+		for _, facetValue := range productFacetValues(product, attributeCode) {
+			values[facetValue.Value] = values[facetValue.Value].remove(marketPlaceCode)
+			if len(values[facetValue.Value]) == 0 {
+				delete(values, facetValue.Value)
+				delete(r.facetValueLabels[attributeCode], facetValue.Value)
+			}
+		}
+	}
+
+	for _, term := range queryTerms(product.BaseData().Title) {
+		term = truncateSuggestTerm(term)
+		codes, ok := r.suggestTermReverseIndex[term]
+		if !ok {
+			continue
+		}
+		r.suggestTermReverseIndex[term] = codes.remove(marketPlaceCode)
+		if len(r.suggestTermReverseIndex[term]) == 0 {
+			delete(r.suggestTermReverseIndex, term)
+			r.suggestTerms = sortedCodes(r.suggestTerms).remove(term)
+		}
+	}
+
+	r.fullTextIndex.remove(marketPlaceCode)
+}
+
+// FindByMarketplaceCode returns a product struct for the given marketplaceCode,
+// the configurable product with the active variant for variant marketplace codes
 func (r *InMemoryProductRepository) FindByMarketplaceCode(_ context.Context, marketplaceCode string) (productDomain.BasicProduct, error) {
 	r.addReadMutex.RLock()
 	defer r.addReadMutex.RUnlock()
+	if parentMarketplaceCode, ok := r.variantParents.parent(marketplaceCode); ok {
+		if parent, ok := r.marketplaceCodeIndex[parentMarketplaceCode]; ok {
+			return configurableWithActiveVariant(parent, marketplaceCode)
+		}
+	}
 	if product, ok := r.marketplaceCodeIndex[marketplaceCode]; ok {
 		return product, nil
 	}
@@ -169,6 +369,82 @@ func (r *InMemoryProductRepository) FindByMarketplaceCode(_ context.Context, mar
 	}
 }
 
+// Suggest returns products, categories and query completions matching the given prefix (search-as-you-type)
+func (r *InMemoryProductRepository) Suggest(_ context.Context, prefix string, limit int) (*domain.SuggestResult, error) {
+	r.addReadMutex.RLock()
+	defer r.addReadMutex.RUnlock()
+
+	terms := queryTerms(prefix)
+	for i, term := range terms {
+		terms[i] = truncateSuggestTerm(term)
+	}
+	if len(terms) == 0 {
+		return &domain.SuggestResult{}, nil
+	}
+
+	var matchingMarketplaceCodes marketPlaceCodeSet
+	for _, term := range terms {
+		var matchingCodes sortedCodes
+		for _, indexedTerm := range r.suggestTermsWithPrefix(term) {
+			matchingCodes = matchingCodes.union(r.suggestTermReverseIndex[indexedTerm])
+		}
+		matchingMarketplaceCodes.intersection(matchingCodes)
+	}
+	products := r.getMatchingProducts(matchingMarketplaceCodes.currentSet)
+	sort.Slice(products, func(i, j int) bool {
+		return products[i].BaseData().Title < products[j].BaseData().Title
+	})
+	if len(products) > limit {
+		products = products[:limit]
+	}
+
+	var categories []categoryDomain.Category
+	for _, tree := range r.categoryTreeIndex {
+		if categoryMatchesSuggestTerms(tree.CategoryName, terms) {
+			categories = append(categories, &categoryDomain.CategoryData{
+				CategoryCode: tree.CategoryCode,
+				CategoryName: tree.CategoryName,
+				CategoryPath: tree.CategoryPath,
+			})
+		}
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		return categories[i].Name() < categories[j].Name()
+	})
+	if len(categories) > limit {
+		categories = categories[:limit]
+	}
+
+	var candidates []dictionaryTerm
+	for _, indexedTerm := range r.suggestTermsWithPrefix(terms[len(terms)-1]) {
+		candidates = append(candidates, dictionaryTerm{Term: indexedTerm, Count: uint64(len(r.suggestTermReverseIndex[indexedTerm]))})
+	}
+
+	return &domain.SuggestResult{
+		Products:    products,
+		Categories:  categories,
+		Completions: suggestCompletions(prefix, terms, candidates, limit),
+	}, nil
+}
+
+// categoryMatchesSuggestTerms checks if every term is a prefix of a term in the category name
+func categoryMatchesSuggestTerms(categoryName string, terms []string) bool {
+	nameTerms := queryTerms(categoryName)
+	for _, term := range terms {
+		matches := false
+		for _, nameTerm := range nameTerms {
+			if strings.HasPrefix(nameTerm, term) {
+				matches = true
+				break
+			}
+		}
+		if !matches {
+			return false
+		}
+	}
+	return true
+}
+
 // CategoryTree returns tree - empty code returns RootNode
 func (r *InMemoryProductRepository) CategoryTree(_ context.Context, code string) (categoryDomain.Tree, error) {
 	if r.rootCategory == nil {
@@ -216,57 +492,106 @@ func (r *InMemoryProductRepository) Find(_ context.Context, filters ...searchDom
 
 	pageSize := 100
 	pageNumber := 1
-	sortField := "relevance"
-	sortDirection := searchDomain.SortDirectionAscending
+	var sortFilter *searchDomain.SortFilter
+	var userInput string
+	var scores map[string]float64
+	var matchedVariantsFilter *domain.MatchedVariantsFilter
+	var keyValueFilters []*searchDomain.KeyValueFilter
+	var excludedCodes sortedCodes
+	var multiSelectFilterCodes map[string]sortedCodes
+	// the visibility and category filters also apply to the configurable products of collapsed variants
+	var parentCategoryCodes marketPlaceCodeSet
+	var parentExcludedCodes sortedCodes
 	for _, filter := range filters {
 		filterKey, filterValues := filter.Value()
 		switch f := filter.(type) {
 		case *searchDomain.KeyValueFilter:
-			for _, filterValue := range filterValues {
-				matchingCodes := r.attributeReverseIndex[filterKey][filterValue]
-				matchingMarketplaceCodes.intersection(matchingCodes)
+			// the values of a filter are alternatives, the filters all have to match
+			if isMultiSelectFacet(r.facetConfig, filterKey) {
+				// multi-select facets are counted without their own filter
+				if multiSelectFilterCodes == nil {
+					multiSelectFilterCodes = make(map[string]sortedCodes)
+				}
+				multiSelectFilterCodes[filterKey] = r.marketplaceCodesWithValues(filterKey, filterValues)
+			} else {
+				matchingMarketplaceCodes.intersection(r.marketplaceCodesWithValues(filterKey, filterValues))
+			}
+			if filterKey != "category" {
+				keyValueFilters = append(keyValueFilters, f)
+			} else {
+				parentCategoryCodes.intersection(r.marketplaceCodesWithValues(filterKey, filterValues))
+			}
+		case *domain.ExcludeFilter:
+			excludedCodes = excludedCodes.union(r.marketplaceCodesWithValues(filterKey, filterValues))
+			if filterKey == "category" {
+				parentExcludedCodes = parentExcludedCodes.union(r.marketplaceCodesWithValues(filterKey, filterValues))
 			}
 		case categoryDomain.CategoryFacet:
 			for _, filterValue := range filterValues {
 				matchingCodes := r.productsByCategoriesReverseIndex[filterValue]
 				matchingMarketplaceCodes.intersection(matchingCodes)
+				parentCategoryCodes.intersection(matchingCodes)
 			}
+		case *searchDomain.QueryFilter:
+			userInput = f.Query()
+			if len(queryTerms(userInput)) == 0 {
+				continue
+			}
+			scores = r.fullTextIndex.search(queryTerms(userInput), r.queryConfig.Operator)
+			matchingCodes := make([]string, 0, len(scores))
+			for marketPlaceCode := range scores {
+				matchingCodes = append(matchingCodes, marketPlaceCode)
+			}
+			matchingMarketplaceCodes.intersection(newSortedCodes(matchingCodes))
 		case *searchDomain.PaginationPageSize:
 			pageSize = f.GetPageSize()
 		case *searchDomain.PaginationPage:
 			pageNumber = f.GetPage()
 		case *searchDomain.SortFilter:
-			sortField = f.Field()
-			sortDirection = f.Direction()
+			sortFilter = configuredSortFilter(r.sortConfig, f)
+		case *domain.MatchedVariantsFilter:
+			matchedVariantsFilter = f
+			matchedVariantsFilter.Reset()
 		}
 	}
 
-	if !matchingMarketplaceCodes.initialFilled {
-		// get all products if not filtered yet
-		for _, p := range r.marketplaceCodeIndex {
-			productResults = append(productResults, p)
-		}
-	} else {
-		// otherwise get only the remaining marketplace codes
-		productResults = r.getMatchingProducts(matchingMarketplaceCodes.currentSet)
+	// all products match if not filtered yet
+	filteredCodes := r.marketplaceCodes
+	if matchingMarketplaceCodes.initialFilled {
+		filteredCodes = matchingMarketplaceCodes.currentSet
 	}
 
-	// Sort the Results
-	sort.Slice(productResults, func(i, j int) bool {
-		iV := productResults[i].BaseData().Attributes[sortField].Value()
-		jV := productResults[j].BaseData().Attributes[sortField].Value()
+	// visibility rules are evaluated at the time of the request
+	now := time.Now()
+	matchingCodes := r.visibleCodes(withMultiSelectFilterCodes(filteredCodes, multiSelectFilterCodes, "").difference(excludedCodes), now)
+	productResults = r.getMatchingProducts(matchingCodes)
 
-		if sortField == "title" || sortField == "relevance" {
-			iV = productResults[i].BaseData().Title
-			jV = productResults[j].BaseData().Title
-		}
+	// facets count all matching products, like the bleve repository, multi-select facets without their own filter
+	facetMatchingCodes := make(map[string]sortedCodes, len(multiSelectFilterCodes))
+	for attributeCode := range multiSelectFilterCodes {
+		facetMatchingCodes[attributeCode] = r.visibleCodes(withMultiSelectFilterCodes(filteredCodes, multiSelectFilterCodes, attributeCode).difference(excludedCodes), now)
+	}
+	facets := r.facets(matchingCodes, facetMatchingCodes)
 
-		if sortDirection == searchDomain.SortDirectionAscending {
-			return iV < jV
-		}
+	less := r.productLess(selectedSortFields(r.sortConfig, sortFilter, scores != nil), scores, r.visibleFirst(productResults, now))
 
-		return iV > jV
-	})
+	collapse := r.collapseVariants == collapseVariantsParent || r.collapseVariants == collapseVariantsVariant
+	if collapse {
+		// the groups depend on the order of all products
+		sort.Slice(productResults, func(i, j int) bool {
+			return less(productResults[i], productResults[j])
+		})
+		productResults = r.collapseProducts(productResults, func(parent productDomain.BasicProduct) bool {
+			marketPlaceCode := parent.BaseData().MarketPlaceCode
+			if parentCategoryCodes.initialFilled && !parentCategoryCodes.currentSet.contains(marketPlaceCode) {
+				return false
+			}
+			if parentExcludedCodes.contains(marketPlaceCode) {
+				return false
+			}
+			return !r.visibilityConfig.hasRule(visibilityHide) || r.visibilityConfig.matches(visibilityHide, availabilityOf(parent), now)
+		})
+	}
 
 	totalHits := len(productResults)
 
@@ -276,6 +601,9 @@ func (r *InMemoryProductRepository) Find(_ context.Context, filters ...searchDom
 		pageNumber = 0
 	}
 	start := (pageNumber - 1) * pageSize
+	if start < 0 {
+		start = 0
+	}
 
 	if start > len(productResults) {
 		start = len(productResults)
@@ -284,51 +612,293 @@ func (r *InMemoryProductRepository) Find(_ context.Context, filters ...searchDom
 	if stop > len(productResults) {
 		stop = len(productResults)
 	}
+	if !collapse {
+		// only the products up to the requested page are sorted
+		productResults = firstSortedProducts(productResults, less, stop)
+	}
 	productResults = productResults[start:stop]
 
 	if pageSize > 0 {
 		pageAmount = int(math.Ceil(float64(totalHits) / float64(pageSize)))
 	}
 
-	return &productDomain.SearchResult{
+	if matchedVariantsFilter != nil {
+		for _, product := range productResults {
+			matchedVariantsFilter.AddVariants(product.BaseData().MarketPlaceCode, matchedVariants(product, keyValueFilters, sortConfigVariantMatcher(r.sortConfig)))
+		}
+	}
+
+	sortOptions := configSortOptions(r.sortConfig)
+	markSelectedSortOption(sortOptions, sortFilter)
+
+	result := &productDomain.SearchResult{
 		Hits: productResults,
 		Result: searchDomain.Result{
+			Facets: facets,
 			SearchMeta: searchDomain.SearchMeta{
-				NumResults: totalHits,
-				NumPages:   pageAmount,
-				Page:       pageNumber,
+				NumResults:  totalHits,
+				NumPages:    pageAmount,
+				Page:        pageNumber,
+				SortOptions: sortOptions,
 			}},
-	}, nil
+	}
+	markActiveFacets(filters, result)
+	applyFacetPresentation(r.facetConfig, result)
+
+	if userInput != "" && totalHits <= r.queryConfig.SuggestionThreshold {
+		result.Suggestion = spellingSuggestions(userInput, r.fullTextIndex.dictionary(), r.queryConfig)
+	}
+	return result, nil
+}
+
+// visibleCodes returns the codes of the products that pass the "hide" visibility rules
+func (r *InMemoryProductRepository) visibleCodes(codes sortedCodes, now time.Time) sortedCodes {
+	if !r.visibilityConfig.hasRule(visibilityHide) {
+		return codes
+	}
+	visibleCodes := make(sortedCodes, 0, len(codes))
+	for _, marketPlaceCode := range codes {
+		if r.visibilityConfig.matches(visibilityHide, availabilityOf(r.marketplaceCodeIndex[marketPlaceCode]), now) {
+			visibleCodes = append(visibleCodes, marketPlaceCode)
+		}
+	}
+	return visibleCodes
 }
 
-func (r *InMemoryProductRepository) getMatchingProducts(codes []string) []productDomain.BasicProduct {
+// withMultiSelectFilterCodes intersects the codes with the filters of all multi-select facets except the excluded one
+func withMultiSelectFilterCodes(codes sortedCodes, multiSelectFilterCodes map[string]sortedCodes, excludedFacet string) sortedCodes {
+	for attributeCode, filterCodes := range multiSelectFilterCodes {
+		if attributeCode != excludedFacet {
+			codes = codes.intersection(filterCodes)
+		}
+	}
+	return codes
+}
 
-	var matches []productDomain.BasicProduct
-	for code, product := range r.marketplaceCodeIndex {
-		for _, codeToFind := range codes {
-			if code == codeToFind {
-				matches = append(matches, product)
+// productLess returns the order of the products: visible products first (for "last" visibility rules), then the sort fields.
+// The marketplace code breaks remaining ties, so the order is stable across pages
+func (r *InMemoryProductRepository) productLess(sortFields []sortField, scores map[string]float64, visibleFirst map[string]bool) func(a, b productDomain.BasicProduct) bool {
+	return func(a, b productDomain.BasicProduct) bool {
+		aCode, bCode := a.BaseData().MarketPlaceCode, b.BaseData().MarketPlaceCode
+		if visibleFirst != nil && visibleFirst[aCode] != visibleFirst[bCode] {
+			return visibleFirst[aCode]
+		}
+		for _, sortField := range sortFields {
+			var compared int
+			if sortField.AttributeCode == sortFieldScore {
+				// best matches first is the natural order of the relevance
+				compared = compareScores(scores[bCode], scores[aCode])
+			} else {
+				compared = compareSortValues(a, b, sortField)
 			}
+			if compared == 0 {
+				continue
+			}
+			if sortField.Desc {
+				return compared > 0
+			}
+			return compared < 0
+		}
+		return aCode < bCode
+	}
+}
+
+// visibleFirst returns the marketplace codes of the products that pass the "last" visibility rules, nil without such rules
+func (r *InMemoryProductRepository) visibleFirst(products []productDomain.BasicProduct, now time.Time) map[string]bool {
+	if !r.visibilityConfig.hasRule(visibilityLast) {
+		return nil
+	}
+	visible := make(map[string]bool, len(products))
+	for _, product := range products {
+		visible[product.BaseData().MarketPlaceCode] = r.visibilityConfig.matches(visibilityLast, availabilityOf(product), now)
+	}
+	return visible
+}
+
+// collapseProducts keeps the first product of each configurable product and its variants, in parent mode the
+// configurable product replaces the variant if it passes the filters, otherwise the variant is kept
+func (r *InMemoryProductRepository) collapseProducts(products []productDomain.BasicProduct, parentMatches func(parent productDomain.BasicProduct) bool) []productDomain.BasicProduct {
+	collapsed := make([]productDomain.BasicProduct, 0, len(products))
+	addedGroups := make(map[string]bool)
+	for _, product := range products {
+		collapseKey := r.variantParents.collapseKey(product.BaseData().MarketPlaceCode)
+		if addedGroups[collapseKey] {
+			continue
+		}
+		addedGroups[collapseKey] = true
+		if parent, ok := r.marketplaceCodeIndex[collapseKey]; ok && r.collapseVariants == collapseVariantsParent && parentMatches(parent) {
+			product = parent
+		}
+		collapsed = append(collapsed, product)
+	}
+	return collapsed
+}
+
+// marketplaceCodesWithValues returns the market place codes of the products with any of the values,
+// the key "category" matches category codes
+func (r *InMemoryProductRepository) marketplaceCodesWithValues(key string, values []string) sortedCodes {
+	if dateField, ok := dateSortField(r.sortConfig, key); ok {
+		return r.marketplaceCodesInDateRanges(dateField, values)
+	}
+	var matchingCodes sortedCodes
+	for _, value := range values {
+		if key == "category" {
+			matchingCodes = matchingCodes.union(r.productsByCategoriesReverseIndex[value])
+			continue
+		}
+		matchingCodes = matchingCodes.union(r.attributeReverseIndex[key][value])
+	}
+	return matchingCodes
+}
+
+// marketplaceCodesInDateRanges returns the market place codes of the products with a date within any of the ranges,
+// every indexed date value is parsed once
+func (r *InMemoryProductRepository) marketplaceCodesInDateRanges(dateField sortField, values []string) sortedCodes {
+	var dateRanges []dateRange
+	for _, value := range values {
+		dateRange, err := parseDateRange(value, dateField.DateLayout)
+		if err != nil {
+			r.logger.Warn("Invalid date range filter ", err)
+			continue
+		}
+		dateRanges = append(dateRanges, dateRange)
+	}
+
+	var matchingCodes sortedCodes
+	for value, marketPlaceCodes := range r.attributeReverseIndex[dateField.AttributeCode] {
+		date, err := parseDateValue(value, dateField.DateLayout)
+		if err != nil {
+			continue
+		}
+		for _, dateRange := range dateRanges {
+			if dateRange.contains(date) {
+				matchingCodes = matchingCodes.union(marketPlaceCodes)
+				break
+			}
+		}
+	}
+	return matchingCodes
+}
+
+// firstSortedProducts returns the first n products in sorted order, without sorting all products
+func firstSortedProducts(products []productDomain.BasicProduct, less func(a, b productDomain.BasicProduct) bool, n int) []productDomain.BasicProduct {
+	if n >= len(products) {
+		sort.Slice(products, func(i, j int) bool {
+			return less(products[i], products[j])
+		})
+		return products
+	}
+
+	// the heap keeps the first n products seen so far, its root is the last of them
+	first := &productHeap{products: make([]productDomain.BasicProduct, 0, n), less: less}
+	for _, product := range products {
+		if first.Len() < n {
+			heap.Push(first, product)
+			continue
+		}
+		if n > 0 && less(product, first.products[0]) {
+			first.products[0] = product
+			heap.Fix(first, 0)
+		}
+	}
+	sort.Slice(first.products, func(i, j int) bool {
+		return less(first.products[i], first.products[j])
+	})
+	return first.products
+}
+
+// compareScores compares two relevance scores
+func compareScores(a float64, b float64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+// compareSortValues compares the sort values of the products by the attribute type of the field, missing values are sorted last.
+// The relevance score is compared by compareScores
+func compareSortValues(a productDomain.BasicProduct, b productDomain.BasicProduct, field sortField) int {
+	switch field.AttributeCode {
+	case "title", "relevance":
+		return strings.Compare(a.BaseData().Title, b.BaseData().Title)
+	case priceAttributeCode:
+		aPrice, aOk := numericValue(a.BaseData(), a.TeaserData().TeaserPrice, priceAttributeCode)
+		bPrice, bOk := numericValue(b.BaseData(), b.TeaserData().TeaserPrice, priceAttributeCode)
+		if compared, missing := compareMissingValues(aOk, bOk, field); missing {
+			return compared
+		}
+		return compareScores(aPrice, bPrice)
+	}
+
+	switch field.AttributeType {
+	case attributeTypeDate:
+		return compareDateValues(a, b, field)
+	case attributeTypeNumeric:
+		aValue, aOk := numericValue(a.BaseData(), productDomain.PriceInfo{}, field.AttributeCode)
+		bValue, bOk := numericValue(b.BaseData(), productDomain.PriceInfo{}, field.AttributeCode)
+		if compared, missing := compareMissingValues(aOk, bOk, field); missing {
+			return compared
+		}
+		return compareScores(aValue, bValue)
+	case attributeTypeBool:
+		aValue, aErr := strconv.ParseBool(a.BaseData().Attribute(field.AttributeCode).Value())
+		bValue, bErr := strconv.ParseBool(b.BaseData().Attribute(field.AttributeCode).Value())
+		if compared, missing := compareMissingValues(aErr == nil, bErr == nil, field); missing {
+			return compared
+		}
+		if aValue == bValue {
+			return 0
+		}
+		if bValue {
+			return -1
+		}
+		return 1
+	}
+
+	aValue := a.BaseData().Attribute(field.AttributeCode).Value()
+	bValue := b.BaseData().Attribute(field.AttributeCode).Value()
+	if compared, missing := compareMissingValues(aValue != "", bValue != "", field); missing {
+		return compared
+	}
+	return strings.Compare(aValue, bValue)
+}
+
+func (r *InMemoryProductRepository) getMatchingProducts(codes sortedCodes) []productDomain.BasicProduct {
+	matches := make([]productDomain.BasicProduct, 0, len(codes))
+	for _, code := range codes {
+		if product, ok := r.marketplaceCodeIndex[code]; ok {
+			matches = append(matches, product)
 		}
 	}
 	return matches
 }
 
-func (s *marketPlaceCodeSet) intersection(set2 []string) {
+func (h *productHeap) Len() int { return len(h.products) }
+
+func (h *productHeap) Less(i, j int) bool { return h.less(h.products[j], h.products[i]) }
+
+func (h *productHeap) Swap(i, j int) { h.products[i], h.products[j] = h.products[j], h.products[i] }
+
+func (h *productHeap) Push(x interface{}) {
+	h.products = append(h.products, x.(productDomain.BasicProduct))
+}
+
+func (h *productHeap) Pop() interface{} {
+	last := h.products[len(h.products)-1]
+	h.products = h.products[:len(h.products)-1]
+	return last
+}
+
+func (s *marketPlaceCodeSet) intersection(set2 sortedCodes) {
 	if !s.initialFilled {
 		s.currentSet = set2
 		s.initialFilled = true
 		return
 	}
-	var result []string
-	for _, v1 := range s.currentSet {
-		for _, v2 := range set2 {
-			if v1 == v2 {
-				result = append(result, v2)
-			}
-		}
-	}
-	s.currentSet = result
+	s.currentSet = s.currentSet.intersection(set2)
 }
 
 // addCategoryPath merges in the given categoryToAdd to the  passed currentExisting
@@ -337,6 +907,9 @@ func (r *InMemoryProductRepository) addCategoryPath(currentTreeNode *categoryDom
 	if r.categoryTreeIndex == nil {
 		r.categoryTreeIndex = make(map[string]*categoryDomain.TreeData)
 	}
+	if treeNodeToAdd == nil {
+		return currentTreeNode
+	}
 	// if its the first node then make as current node
 	if currentTreeNode == nil {
 		clone := *treeNodeToAdd
@@ -348,13 +921,17 @@ func (r *InMemoryProductRepository) addCategoryPath(currentTreeNode *categoryDom
 		// No common root node - exit
 		return currentTreeNode
 	}
+	// a known category keeps its node (products and sub categories stay linked), only the name is updated
+	if treeNodeToAdd.CategoryName != "" {
+		currentTreeNode.CategoryName = treeNodeToAdd.CategoryName
+	}
 	for _, subTreeNodeToAdd := range treeNodeToAdd.SubTreesData {
 		exists := false
 		for _, existingSubTree := range currentTreeNode.SubTreesData {
 			if existingSubTree.CategoryCode == subTreeNodeToAdd.CategoryCode {
 				exists = true
-				// match - proceed in recursion
-				existingSubTree = r.addCategoryPath(existingSubTree, subTreeNodeToAdd)
+				// match - proceed in recursion, the existing node is updated in place
+				r.addCategoryPath(existingSubTree, subTreeNodeToAdd)
 			}
 		}
 		// subTreeNodeToAdd does not exist yet - so we merge it in:
@@ -411,3 +988,40 @@ func inSlice(list []string, search string) bool {
 	}
 	return false
 }
+
+// compareDateValues compares the date attributes chronologically, products without a valid date are sorted last
+func compareDateValues(a productDomain.BasicProduct, b productDomain.BasicProduct, field sortField) int {
+	aDate, aErr := parseDateValue(a.BaseData().Attribute(field.AttributeCode).Value(), field.DateLayout)
+	bDate, bErr := parseDateValue(b.BaseData().Attribute(field.AttributeCode).Value(), field.DateLayout)
+	if compared, missing := compareMissingValues(aErr == nil, bErr == nil, field); missing {
+		return compared
+	}
+	switch {
+	case aDate.Before(bDate):
+		return -1
+	case aDate.After(bDate):
+		return 1
+	}
+	return 0
+}
+
+// compareMissingValues compares two values of which at least one is missing, so that the missing one is sorted last
+func compareMissingValues(aOk bool, bOk bool, field sortField) (int, bool) {
+	switch {
+	case !aOk && !bOk:
+		return 0, true
+	case !aOk:
+		return missingSortValue(field), true
+	case !bOk:
+		return -missingSortValue(field), true
+	}
+	return 0, false
+}
+
+// missingSortValue returns the comparison result that sorts a missing value last in the direction of the field
+func missingSortValue(field sortField) int {
+	if field.Desc {
+		return -1
+	}
+	return 1
+}
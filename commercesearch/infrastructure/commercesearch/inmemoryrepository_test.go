@@ -2,17 +2,40 @@ package commercesearch
 
 import (
 	"context"
+	"fmt"
+	"sort"
 	"testing"
+	"time"
 
+	priceDomain "flamingo.me/flamingo-commerce/v3/price/domain"
 	searchDomain "flamingo.me/flamingo-commerce/v3/search/domain"
+	"flamingo.me/flamingo/v3/framework/config"
 	"flamingo.me/flamingo/v3/framework/flamingo"
 	"github.com/stretchr/testify/require"
 
 	categoryDomain "flamingo.me/flamingo-commerce/v3/category/domain"
 	"flamingo.me/flamingo-commerce/v3/product/domain"
 	"github.com/stretchr/testify/assert"
+
+	commercesearchDomain "flamingo.me/flamingo-commerce-adapter-standalone/commercesearch/domain"
 )
 
+// inMemoryRepositoryConfig matches the config struct of InMemoryProductRepository.Inject
+type inMemoryRepositoryConfig = struct {
+	SortConfig               config.Slice `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.inMemoryAdapter.sortConfig,optional"`
+	EnableCategoryFacet      bool         `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.inMemoryAdapter.enableCategoryFacet,optional"`
+	FacetConfig              config.Slice `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.inMemoryAdapter.facetConfig,optional"`
+	QueryAttributes          config.Slice `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.inMemoryAdapter.query.attributes,optional"`
+	QueryOperator            string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.inMemoryAdapter.query.operator,optional"`
+	QuerySuggestionThreshold float64      `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.inMemoryAdapter.query.suggestionThreshold,optional"`
+	VisibilityNonSaleable    string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.visibility.nonSaleable,optional"`
+	VisibilityOutOfStock     string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.visibility.outOfStock,optional"`
+	CollapseVariants         string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.collapseVariants,optional"`
+	SnapshotFile             string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.inMemoryAdapter.snapshot.file,optional"`
+	SnapshotRestore          bool         `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.inMemoryAdapter.snapshot.restore,optional"`
+	SnapshotSaveAfterIndex   bool         `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.inMemoryAdapter.snapshot.saveAfterIndexing,optional"`
+}
+
 func TestInMemoryProductRepository_AddProduct(t *testing.T) {
 	s := &InMemoryProductRepository{
 		logger: flamingo.NullLogger{},
@@ -201,3 +224,727 @@ func TestInMemoryProductRepository_addCategoryPath(t *testing.T) {
 	assert.Equal(t, "sub3-sub", existingTree.SubTreesData[2].SubTreesData[0].CategoryCode)
 
 }
+
+func TestInMemoryProductRepository_Suggest(t *testing.T) {
+	s := &InMemoryProductRepository{
+		logger: flamingo.NullLogger{},
+	}
+
+	err := s.UpdateByCategoryTeasers(context.Background(), []domain.CategoryTeaser{
+		{Code: "shoes", Name: "Shoes", Path: "master/shoes", Parent: &domain.CategoryTeaser{Code: "master", Name: "Master"}},
+		{Code: "shirts", Name: "Shirts", Path: "master/shirts", Parent: &domain.CategoryTeaser{Code: "master", Name: "Master"}},
+	})
+	require.NoError(t, err)
+
+	err = s.UpdateProducts(context.Background(), []domain.BasicProduct{
+		domain.SimpleProduct{
+			Identifier: "id1",
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: "id1",
+				Title:           "Leather Shoe",
+			},
+		},
+		domain.SimpleProduct{
+			Identifier: "id2",
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: "id2",
+				Title:           "Running Shoe",
+			},
+		},
+		domain.SimpleProduct{
+			Identifier: "id3",
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: "id3",
+				Title:           "Leather Shirt",
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	result, err := s.Suggest(context.Background(), "sh", 10)
+	require.NoError(t, err)
+	assert.Len(t, result.Products, 3)
+	assert.Len(t, result.Categories, 2)
+	assert.Equal(t, []string{"shoe", "shirt"}, result.Completions, "more frequent completions first")
+
+	result, err = s.Suggest(context.Background(), "leather sho", 10)
+	require.NoError(t, err)
+	require.Len(t, result.Products, 1)
+	assert.Equal(t, "id1", result.Products[0].BaseData().MarketPlaceCode)
+	assert.Empty(t, result.Categories)
+	assert.Equal(t, []string{"leather shoe"}, result.Completions)
+
+	result, err = s.Suggest(context.Background(), "shoe ", 10)
+	require.NoError(t, err)
+	assert.Len(t, result.Products, 2)
+	assert.Empty(t, result.Completions, "no completions for finished terms")
+}
+
+func TestInMemoryProductRepository_SortPresets(t *testing.T) {
+	s := new(InMemoryProductRepository).Inject(flamingo.NullLogger{}, &inMemoryRepositoryConfig{
+		SortConfig: config.Slice{
+			config.Map{"name": "recommended", "asc": true, "desc": true, "fields": config.Slice{
+				config.Map{"attributeCode": "inStock", "desc": true},
+				config.Map{"attributeCode": "price"},
+			}},
+		},
+	})
+
+	newProduct := func(marketPlaceCode string, inStock string, price int64) domain.SimpleProduct {
+		return domain.SimpleProduct{
+			Identifier: marketPlaceCode,
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: marketPlaceCode,
+				Title:           marketPlaceCode,
+				Attributes: domain.Attributes{
+					"inStock": domain.Attribute{Code: "inStock", RawValue: inStock},
+				},
+			},
+			Teaser: domain.TeaserData{
+				TeaserPrice: domain.PriceInfo{Default: priceDomain.NewFromInt(price, 100, "€")},
+			},
+		}
+	}
+	err := s.UpdateProducts(context.Background(), []domain.BasicProduct{
+		newProduct("p1", "false", 1000),
+		newProduct("p2", "true", 2000),
+		newProduct("p3", "true", 1500),
+	})
+	require.NoError(t, err)
+
+	result, err := s.Find(context.Background(), searchDomain.NewSortFilter("recommended", "A"))
+	require.NoError(t, err)
+	require.Len(t, result.Hits, 3)
+	assert.Equal(t, "p3", result.Hits[0].BaseData().MarketPlaceCode)
+	assert.Equal(t, "p2", result.Hits[1].BaseData().MarketPlaceCode)
+	assert.Equal(t, "p1", result.Hits[2].BaseData().MarketPlaceCode)
+	require.Len(t, result.SearchMeta.SortOptions, 1)
+	assert.True(t, result.SearchMeta.SortOptions[0].SelectedAsc)
+
+	result, err = s.Find(context.Background(), searchDomain.NewSortFilter("recommended", "D"))
+	require.NoError(t, err)
+	assert.Equal(t, "p1", result.Hits[0].BaseData().MarketPlaceCode, "descending reverses all fields of the preset")
+	assert.True(t, result.SearchMeta.SortOptions[0].SelectedDesc)
+}
+
+func TestInMemoryProductRepository_DateAttributes(t *testing.T) {
+	s := new(InMemoryProductRepository).Inject(flamingo.NullLogger{}, &inMemoryRepositoryConfig{
+		SortConfig: config.Slice{
+			config.Map{"attributeCode": "releaseDate", "attributeType": "date", "asc": true, "desc": true},
+		},
+	})
+
+	newProduct := func(marketPlaceCode string, releaseDate string) domain.SimpleProduct {
+		return domain.SimpleProduct{
+			Identifier: marketPlaceCode,
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: marketPlaceCode,
+				Title:           marketPlaceCode,
+				Attributes: domain.Attributes{
+					"releaseDate": domain.Attribute{Code: "releaseDate", RawValue: releaseDate},
+				},
+			},
+		}
+	}
+	err := s.UpdateProducts(context.Background(), []domain.BasicProduct{
+		// compared as text the first product would be the newest
+		newProduct("p1", "2020-05-01T10:00:00+02:00"),
+		newProduct("p2", "2020-05-01T09:30:00Z"),
+		newProduct("p3", "invalid"),
+	})
+	require.NoError(t, err)
+
+	result, err := s.Find(context.Background(), searchDomain.NewSortFilter("releaseDate", "D"))
+	require.NoError(t, err)
+	require.Len(t, result.Hits, 3)
+	assert.Equal(t, "p2", result.Hits[0].BaseData().MarketPlaceCode)
+	assert.Equal(t, "p1", result.Hits[1].BaseData().MarketPlaceCode)
+	assert.Equal(t, "p3", result.Hits[2].BaseData().MarketPlaceCode, "invalid dates are sorted last")
+
+	result, err = s.Find(context.Background(), searchDomain.NewKeyValueFilter("releaseDate", []string{"2020-05-01T08:00:00Z..2020-05-01T09:30:00Z"}))
+	require.NoError(t, err)
+	require.Len(t, result.Hits, 1)
+	assert.Equal(t, "p1", result.Hits[0].BaseData().MarketPlaceCode)
+}
+
+func TestInMemoryProductRepository_Visibility(t *testing.T) {
+	s := new(InMemoryProductRepository).Inject(flamingo.NullLogger{}, &inMemoryRepositoryConfig{
+		VisibilityNonSaleable: "hide",
+		VisibilityOutOfStock:  "last",
+	})
+
+	now := time.Now()
+	newProduct := func(title string, saleable domain.Saleable, stockLevel string) domain.SimpleProduct {
+		return domain.SimpleProduct{
+			Identifier: title,
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: title,
+				Title:           title,
+				StockLevel:      stockLevel,
+				Attributes: domain.Attributes{
+					"name": domain.Attribute{Code: "name", RawValue: title},
+				},
+			},
+			Saleable: saleable,
+		}
+	}
+	products := []domain.BasicProduct{
+		newProduct("a", domain.Saleable{IsSaleable: true}, domain.StockLevelInStock),
+		newProduct("b", domain.Saleable{IsSaleable: true}, domain.StockLevelOutOfStock),
+		newProduct("c", domain.Saleable{IsSaleable: true, SaleableFrom: now.Add(-time.Hour), SaleableTo: now.Add(time.Hour)}, ""),
+		newProduct("d", domain.Saleable{IsSaleable: false}, domain.StockLevelInStock),
+		newProduct("e", domain.Saleable{IsSaleable: true, SaleableFrom: now.Add(time.Hour)}, domain.StockLevelInStock),
+		newProduct("f", domain.Saleable{IsSaleable: true, SaleableTo: now.Add(time.Hour)}, domain.StockLevelOutOfStock),
+	}
+	require.NoError(t, s.UpdateProducts(context.Background(), products))
+
+	titles := func(result *domain.SearchResult) []string {
+		var titles []string
+		for _, hit := range result.Hits {
+			titles = append(titles, hit.BaseData().Title)
+		}
+		return titles
+	}
+
+	result, err := s.Find(context.Background(), searchDomain.NewSortFilter("name", "A"), searchDomain.NewPaginationPageSizeFilter(3))
+	require.NoError(t, err)
+	assert.Equal(t, 4, result.SearchMeta.NumResults, "products that are not saleable now are hidden")
+	assert.Equal(t, []string{"a", "c", "b"}, titles(result), "out of stock products are listed last")
+
+	result, err = s.Find(context.Background(), searchDomain.NewSortFilter("name", "A"), searchDomain.NewPaginationPageSizeFilter(3), searchDomain.NewPaginationPageFilter(2))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"f"}, titles(result))
+}
+
+func TestInMemoryProductRepository_VariantAttributes(t *testing.T) {
+	s := new(InMemoryProductRepository).Inject(flamingo.NullLogger{}, &inMemoryRepositoryConfig{})
+
+	newVariant := func(marketPlaceCode string, color string, price int64) domain.Variant {
+		return domain.Variant{
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: marketPlaceCode,
+				Title:           marketPlaceCode,
+				Attributes: domain.Attributes{
+					"color": domain.Attribute{Code: "color", Label: color, RawValue: color},
+				},
+			},
+			Saleable: domain.Saleable{
+				IsSaleable:  true,
+				ActivePrice: domain.PriceInfo{Default: priceDomain.NewFromInt(price, 100, "€")},
+			},
+		}
+	}
+	configurable := domain.ConfigurableProduct{
+		Identifier: "shirt",
+		BasicProductData: domain.BasicProductData{
+			MarketPlaceCode: "shirt",
+			Title:           "shirt",
+			Attributes: domain.Attributes{
+				"brand": domain.Attribute{Code: "brand", Label: "ACME", RawValue: "acme"},
+			},
+		},
+		VariantVariationAttributes: []string{"color"},
+		Variants: []domain.Variant{
+			newVariant("shirt-red", "red", 1000),
+			newVariant("shirt-blue", "blue", 3000),
+		},
+	}
+	simple := domain.SimpleProduct{
+		Identifier: "socks",
+		BasicProductData: domain.BasicProductData{
+			MarketPlaceCode: "socks",
+			Title:           "socks",
+			Attributes: domain.Attributes{
+				"color": domain.Attribute{Code: "color", Label: "red", RawValue: "red"},
+			},
+		},
+		Teaser: domain.TeaserData{
+			TeaserPrice: domain.PriceInfo{Default: priceDomain.NewFromInt(500, 100, "€")},
+		},
+	}
+	require.NoError(t, s.UpdateProducts(context.Background(), []domain.BasicProduct{configurable, simple}))
+
+	matchedVariantsFilter := commercesearchDomain.NewMatchedVariantsFilter()
+	result, err := s.Find(context.Background(), searchDomain.NewKeyValueFilter("color", []string{"blue"}), matchedVariantsFilter)
+	require.NoError(t, err)
+	require.Len(t, result.Hits, 1)
+	assert.Equal(t, "shirt", result.Hits[0].BaseData().MarketPlaceCode)
+	assert.Equal(t, []string{"shirt-blue"}, matchedVariantsFilter.MatchedVariants().Variants("shirt"))
+
+	result, err = s.Find(context.Background(), searchDomain.NewKeyValueFilter("brand", []string{"acme"}))
+	require.NoError(t, err)
+	require.Len(t, result.Hits, 1)
+}
+
+func TestInMemoryProductRepository_CollapseVariants(t *testing.T) {
+	newRepository := func(collapseVariants string) *InMemoryProductRepository {
+		return new(InMemoryProductRepository).Inject(flamingo.NullLogger{}, &inMemoryRepositoryConfig{
+			SortConfig:       config.Slice{config.Map{"attributeCode": "name", "asc": true}},
+			CollapseVariants: collapseVariants,
+		})
+	}
+
+	newProduct := func(marketPlaceCode string, name string, attributes domain.Attributes) domain.SimpleProduct {
+		attributes["name"] = domain.Attribute{Code: "name", RawValue: name}
+		return domain.SimpleProduct{
+			Identifier: marketPlaceCode,
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: marketPlaceCode,
+				Title:           marketPlaceCode,
+				Attributes:      attributes,
+			},
+		}
+	}
+	newVariant := func(marketPlaceCode string) domain.Variant {
+		return domain.Variant{
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: marketPlaceCode,
+				Title:           marketPlaceCode,
+			},
+		}
+	}
+	products := []domain.BasicProduct{
+		domain.ConfigurableProduct{
+			Identifier: "shirt",
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: "shirt",
+				Title:           "shirt",
+				Attributes: domain.Attributes{
+					"name": domain.Attribute{Code: "name", RawValue: "c"},
+				},
+			},
+			Variants: []domain.Variant{newVariant("shirt-red"), newVariant("shirt-blue")},
+		},
+		newProduct("shirt-red", "a", domain.Attributes{}),
+		newProduct("shirt-blue", "b", domain.Attributes{"size": domain.Attribute{Code: "size", RawValue: "xl"}}),
+		newProduct("socks", "d", domain.Attributes{}),
+	}
+
+	marketPlaceCodes := func(result *domain.SearchResult) []string {
+		var marketPlaceCodes []string
+		for _, hit := range result.Hits {
+			marketPlaceCodes = append(marketPlaceCodes, hit.BaseData().MarketPlaceCode)
+		}
+		return marketPlaceCodes
+	}
+
+	variantRepository := newRepository("variant")
+	require.NoError(t, variantRepository.UpdateProducts(context.Background(), products))
+	result, err := variantRepository.Find(context.Background(), searchDomain.NewSortFilter("name", "A"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.SearchMeta.NumResults, "the configurable product and its variants count once")
+	assert.Equal(t, []string{"shirt-red", "socks"}, marketPlaceCodes(result), "the best matching variant is returned")
+
+	result, err = variantRepository.Find(context.Background(), searchDomain.NewSortFilter("name", "A"), searchDomain.NewPaginationPageSizeFilter(1), searchDomain.NewPaginationPageFilter(2))
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.SearchMeta.NumPages)
+	assert.Equal(t, []string{"socks"}, marketPlaceCodes(result))
+
+	parentRepository := newRepository("parent")
+	require.NoError(t, parentRepository.UpdateProducts(context.Background(), products))
+	result, err = parentRepository.Find(context.Background(), searchDomain.NewSortFilter("name", "A"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.SearchMeta.NumResults)
+	assert.Equal(t, []string{"shirt", "socks"}, marketPlaceCodes(result), "the configurable product replaces its variants")
+
+	result, err = parentRepository.Find(context.Background(), searchDomain.NewKeyValueFilter("size", []string{"xl"}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"shirt"}, marketPlaceCodes(result), "the configurable product is returned if only a variant matches")
+}
+
+func TestInMemoryProductRepository_FindVariantByMarketplaceCode(t *testing.T) {
+	s := new(InMemoryProductRepository).Inject(flamingo.NullLogger{}, &inMemoryRepositoryConfig{})
+
+	configurable := domain.ConfigurableProduct{
+		Identifier: "shirt",
+		BasicProductData: domain.BasicProductData{
+			MarketPlaceCode: "shirt",
+			Title:           "shirt",
+		},
+		VariantVariationAttributes: []string{"color"},
+		Variants: []domain.Variant{
+			{BasicProductData: domain.BasicProductData{MarketPlaceCode: "shirt-red", Title: "red shirt"}},
+			{BasicProductData: domain.BasicProductData{MarketPlaceCode: "shirt-blue", Title: "blue shirt"}},
+		},
+	}
+	simple := domain.SimpleProduct{
+		Identifier:       "socks",
+		BasicProductData: domain.BasicProductData{MarketPlaceCode: "socks", Title: "socks"},
+	}
+	require.NoError(t, s.UpdateProducts(context.Background(), []domain.BasicProduct{configurable, simple}))
+
+	product, err := s.FindByMarketplaceCode(context.Background(), "shirt-blue")
+	require.NoError(t, err)
+	require.IsType(t, domain.ConfigurableProductWithActiveVariant{}, product)
+	assert.Equal(t, "shirt", product.GetIdentifier())
+	assert.Equal(t, "shirt-blue", product.(domain.ConfigurableProductWithActiveVariant).ActiveVariant.MarketPlaceCode)
+
+	product, err = s.FindByMarketplaceCode(context.Background(), "shirt")
+	require.NoError(t, err)
+	assert.IsType(t, domain.ConfigurableProduct{}, product)
+
+	product, err = s.FindByMarketplaceCode(context.Background(), "socks")
+	require.NoError(t, err)
+	assert.IsType(t, domain.SimpleProduct{}, product)
+
+	_, err = s.FindByMarketplaceCode(context.Background(), "shirt-green")
+	assert.Error(t, err)
+}
+
+func TestInMemoryProductRepository_QueryFilter(t *testing.T) {
+	s := new(InMemoryProductRepository).Inject(flamingo.NullLogger{}, &inMemoryRepositoryConfig{
+		QueryAttributes: config.Slice{"brand"},
+	})
+
+	newProduct := func(marketPlaceCode string, title string, description string, brand string) domain.SimpleProduct {
+		return domain.SimpleProduct{
+			Identifier: marketPlaceCode,
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: marketPlaceCode,
+				Title:           title,
+				Description:     description,
+				Attributes: domain.Attributes{
+					"brand": domain.Attribute{Code: "brand", Label: brand, RawValue: brand},
+				},
+			},
+		}
+	}
+	products := []domain.BasicProduct{
+		newProduct("shoe", "Running Shoe", "A light shoe", "Acme"),
+		newProduct("shirt", "Running Shirt", "Breathable", "Acme"),
+		newProduct("socks", "Socks", "For your running shoe", "Other"),
+		newProduct("cap", "Cap", "", "Other"),
+	}
+	require.NoError(t, s.UpdateProducts(context.Background(), products))
+
+	marketPlaceCodes := func(result *domain.SearchResult) []string {
+		var marketPlaceCodes []string
+		for _, hit := range result.Hits {
+			marketPlaceCodes = append(marketPlaceCodes, hit.BaseData().MarketPlaceCode)
+		}
+		return marketPlaceCodes
+	}
+
+	result, err := s.Find(context.Background(), searchDomain.NewQueryFilter("running shoe"))
+	require.NoError(t, err)
+	assert.Equal(t, 3, result.SearchMeta.NumResults)
+	assert.Equal(t, "shoe", marketPlaceCodes(result)[0], "the best match is listed first")
+
+	result, err = s.Find(context.Background(), searchDomain.NewQueryFilter("acme"))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"shoe", "shirt"}, marketPlaceCodes(result), "configured attributes are searchable")
+
+	result, err = s.Find(context.Background(), searchDomain.NewQueryFilter("running"), searchDomain.NewSortFilter("title", "A"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"shirt", "shoe", "socks"}, marketPlaceCodes(result), "the sort filter replaces the relevance")
+
+	result, err = s.Find(context.Background(), searchDomain.NewQueryFilter("runing"))
+	require.NoError(t, err)
+	assert.Empty(t, result.Hits)
+	if assert.Len(t, result.Suggestion, 1) {
+		assert.Equal(t, "running", result.Suggestion[0].Text)
+	}
+}
+
+func TestInMemoryProductRepository_Facets(t *testing.T) {
+	s := new(InMemoryProductRepository).Inject(flamingo.NullLogger{}, &inMemoryRepositoryConfig{
+		EnableCategoryFacet: true,
+		FacetConfig: config.Slice{
+			config.Map{"attributeCode": "color", "amount": 10.0, "label": "Color"},
+			config.Map{"attributeCode": "brand", "amount": 1.0},
+		},
+	})
+
+	laptops := domain.CategoryTeaser{Code: "laptops", Name: "Laptops", Parent: &domain.CategoryTeaser{Code: "computers", Name: "Computers", Parent: &domain.CategoryTeaser{Code: "root"}}}
+	tablets := domain.CategoryTeaser{Code: "tablets", Name: "Tablets", Parent: &domain.CategoryTeaser{Code: "computers", Name: "Computers", Parent: &domain.CategoryTeaser{Code: "root"}}}
+	require.NoError(t, s.UpdateByCategoryTeasers(context.Background(), []domain.CategoryTeaser{laptops, tablets}))
+
+	colorLabels := map[string]string{"black": "Black", "silver": "Silver"}
+	newProduct := func(marketPlaceCode string, category domain.CategoryTeaser, color string, brand string) domain.SimpleProduct {
+		return domain.SimpleProduct{
+			Identifier: marketPlaceCode,
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: marketPlaceCode,
+				Title:           marketPlaceCode,
+				Categories:      []domain.CategoryTeaser{category},
+				Attributes: domain.Attributes{
+					"color": domain.Attribute{Code: "color", Label: colorLabels[color], RawValue: color},
+					"brand": domain.Attribute{Code: "brand", Label: brand, RawValue: brand},
+				},
+			},
+		}
+	}
+	require.NoError(t, s.UpdateProducts(context.Background(), []domain.BasicProduct{
+		newProduct("laptop-1", laptops, "black", "acme"),
+		newProduct("laptop-2", laptops, "silver", "acme"),
+		newProduct("tablet-1", tablets, "black", "other"),
+	}))
+
+	result, err := s.Find(context.Background())
+	require.NoError(t, err)
+	colorFacet := result.Facets["color"]
+	assert.Equal(t, "Color", colorFacet.Label)
+	require.Len(t, colorFacet.Items, 2)
+	assert.Equal(t, "black", colorFacet.Items[0].Value)
+	assert.Equal(t, "Black", colorFacet.Items[0].Label)
+	assert.Equal(t, int64(2), colorFacet.Items[0].Count)
+	require.Len(t, result.Facets["brand"].Items, 1, "the amount limits the facet items")
+	assert.Equal(t, "acme", result.Facets["brand"].Items[0].Value)
+
+	categoryFacet := result.Facets["category"]
+	assert.Equal(t, searchDomain.TreeFacet, categoryFacet.Type)
+	require.Len(t, categoryFacet.Items, 1)
+	assert.Equal(t, "computers", categoryFacet.Items[0].Value)
+	assert.Equal(t, int64(3), categoryFacet.Items[0].Count, "sub categories are counted for their parent")
+	require.Len(t, categoryFacet.Items[0].Items, 2)
+
+	result, err = s.Find(context.Background(), searchDomain.NewKeyValueFilter("color", []string{"black"}), categoryDomain.NewCategoryFacet("laptops"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.SearchMeta.NumResults)
+	require.Len(t, result.Facets["color"].Items, 1)
+	assert.True(t, result.Facets["color"].Items[0].Selected)
+	computers := result.Facets["category"].Items[0]
+	assert.True(t, computers.Active)
+	require.Len(t, computers.Items, 1)
+	assert.Equal(t, "laptops", computers.Items[0].Value)
+	assert.True(t, computers.Items[0].Selected)
+}
+
+func TestFirstSortedProducts(t *testing.T) {
+	var products []domain.BasicProduct
+	for _, marketPlaceCode := range []string{"e", "b", "f", "a", "d", "c"} {
+		products = append(products, domain.SimpleProduct{BasicProductData: domain.BasicProductData{MarketPlaceCode: marketPlaceCode}})
+	}
+	less := func(a, b domain.BasicProduct) bool {
+		return a.BaseData().MarketPlaceCode < b.BaseData().MarketPlaceCode
+	}
+	marketPlaceCodes := func(products []domain.BasicProduct) []string {
+		var marketPlaceCodes []string
+		for _, product := range products {
+			marketPlaceCodes = append(marketPlaceCodes, product.BaseData().MarketPlaceCode)
+		}
+		return marketPlaceCodes
+	}
+
+	assert.Equal(t, []string{"a", "b", "c"}, marketPlaceCodes(firstSortedProducts(products, less, 3)))
+	assert.Empty(t, firstSortedProducts(products, less, 0))
+	assert.Equal(t, []string{"a", "b", "c", "d", "e", "f"}, marketPlaceCodes(firstSortedProducts(products, less, 10)))
+}
+
+func TestInMemoryProductRepository_UnsupportedFacets(t *testing.T) {
+	s := new(InMemoryProductRepository).Inject(flamingo.NullLogger{}, &inMemoryRepositoryConfig{
+		FacetConfig: config.Slice{
+			config.Map{"attributeCode": "price", "type": "range"},
+			config.Map{"attributeCode": "color", "amount": 10.0},
+		},
+	})
+	require.NoError(t, s.UpdateProducts(context.Background(), []domain.BasicProduct{
+		domain.SimpleProduct{BasicProductData: domain.BasicProductData{
+			MarketPlaceCode: "p1",
+			Attributes:      domain.Attributes{"color": domain.Attribute{Code: "color", Label: "Red", RawValue: "red"}},
+		}},
+	}))
+
+	result, err := s.Find(context.Background())
+	require.NoError(t, err)
+	assert.NotContains(t, result.Facets, "price", "range facets are skipped")
+	assert.Contains(t, result.Facets, "color")
+}
+
+func TestInMemoryProductRepository_Pagination(t *testing.T) {
+	s := new(InMemoryProductRepository).Inject(flamingo.NullLogger{}, &inMemoryRepositoryConfig{})
+
+	var products []domain.BasicProduct
+	for i := 0; i < 25; i++ {
+		products = append(products, domain.SimpleProduct{
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: fmt.Sprintf("product-%02d", i),
+				Title:           fmt.Sprintf("title %02d", 24-i),
+				Categories:      []domain.CategoryTeaser{{Code: fmt.Sprintf("category-%d", i%2)}},
+			},
+		})
+	}
+	require.NoError(t, s.UpdateProducts(context.Background(), products))
+
+	var titles []string
+	for page := 1; page <= 3; page++ {
+		result, err := s.Find(context.Background(), categoryDomain.NewCategoryFacet("category-0"), searchDomain.NewPaginationPageSizeFilter(5), searchDomain.NewPaginationPageFilter(page))
+		require.NoError(t, err)
+		assert.Equal(t, 13, result.SearchMeta.NumResults)
+		assert.Equal(t, 3, result.SearchMeta.NumPages)
+		for _, hit := range result.Hits {
+			titles = append(titles, hit.BaseData().Title)
+		}
+	}
+	assert.Len(t, titles, 13)
+	assert.True(t, sort.StringsAreSorted(titles), "the pages continue the order")
+	assert.Equal(t, "title 00", titles[0])
+}
+
+func TestInMemoryProductRepository_FilterSemantics(t *testing.T) {
+	s := new(InMemoryProductRepository).Inject(flamingo.NullLogger{}, &inMemoryRepositoryConfig{})
+
+	newProduct := func(marketPlaceCode string, color string, brand string, category string) domain.SimpleProduct {
+		return domain.SimpleProduct{
+			Identifier: marketPlaceCode,
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: marketPlaceCode,
+				Title:           marketPlaceCode,
+				Categories:      []domain.CategoryTeaser{{Code: category, Parent: &domain.CategoryTeaser{Code: "root"}}},
+				Attributes: domain.Attributes{
+					"color": domain.Attribute{Code: "color", Label: color, RawValue: color},
+					"brand": domain.Attribute{Code: "brand", Label: brand, RawValue: brand},
+				},
+			},
+		}
+	}
+	require.NoError(t, s.UpdateProducts(context.Background(), []domain.BasicProduct{
+		newProduct("a", "red", "acme", "shirts"),
+		newProduct("b", "blue", "acme", "shirts"),
+		newProduct("c", "green", "acme", "pants"),
+		newProduct("d", "red", "other", "pants"),
+	}))
+
+	marketPlaceCodes := func(result *domain.SearchResult) []string {
+		var marketPlaceCodes []string
+		for _, hit := range result.Hits {
+			marketPlaceCodes = append(marketPlaceCodes, hit.BaseData().MarketPlaceCode)
+		}
+		return marketPlaceCodes
+	}
+
+	result, err := s.Find(context.Background(), searchDomain.NewKeyValueFilter("color", []string{"red", "blue"}))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "b", "d"}, marketPlaceCodes(result), "the values of a filter are alternatives")
+
+	result, err = s.Find(context.Background(), searchDomain.NewKeyValueFilter("color", []string{"red", "blue"}), searchDomain.NewKeyValueFilter("brand", []string{"acme"}))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "b"}, marketPlaceCodes(result), "all filters have to match")
+
+	result, err = s.Find(context.Background(), commercesearchDomain.NewExcludeFilter("color", []string{"red", "green"}))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"b"}, marketPlaceCodes(result))
+
+	result, err = s.Find(context.Background(), searchDomain.NewKeyValueFilter("brand", []string{"acme"}), commercesearchDomain.NewExcludeFilter("category", []string{"pants"}))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "b"}, marketPlaceCodes(result))
+}
+
+func TestInMemoryProductRepository_TypedSorting(t *testing.T) {
+	s := new(InMemoryProductRepository).Inject(flamingo.NullLogger{}, &inMemoryRepositoryConfig{
+		SortConfig: config.Slice{
+			config.Map{"attributeCode": "rank", "attributeType": "numeric", "asc": true, "desc": true},
+			config.Map{"attributeCode": "new", "attributeType": "bool", "asc": true, "desc": true},
+		},
+	})
+
+	newProduct := func(marketPlaceCode string, attributes domain.Attributes, price int64) domain.SimpleProduct {
+		product := domain.SimpleProduct{
+			Identifier: marketPlaceCode,
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: marketPlaceCode,
+				Title:           marketPlaceCode,
+				Attributes:      attributes,
+			},
+		}
+		if price > 0 {
+			product.Teaser.TeaserPrice = domain.PriceInfo{Default: priceDomain.NewFromInt(price, 100, "€")}
+		}
+		return product
+	}
+	require.NoError(t, s.UpdateProducts(context.Background(), []domain.BasicProduct{
+		newProduct("a", domain.Attributes{"rank": domain.Attribute{Code: "rank", RawValue: "100"}, "new": domain.Attribute{Code: "new", RawValue: "true"}}, 1000),
+		newProduct("b", domain.Attributes{"rank": domain.Attribute{Code: "rank", RawValue: "20"}, "new": domain.Attribute{Code: "new", RawValue: "false"}}, 500),
+		newProduct("c", domain.Attributes{}, 0),
+		newProduct("d", domain.Attributes{"rank": domain.Attribute{Code: "rank", RawValue: "3"}, "new": domain.Attribute{Code: "new", RawValue: "true"}}, 2000),
+	}))
+
+	marketPlaceCodes := func(result *domain.SearchResult) []string {
+		var marketPlaceCodes []string
+		for _, hit := range result.Hits {
+			marketPlaceCodes = append(marketPlaceCodes, hit.BaseData().MarketPlaceCode)
+		}
+		return marketPlaceCodes
+	}
+
+	result, err := s.Find(context.Background(), searchDomain.NewSortFilter("rank", "A"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"d", "b", "a", "c"}, marketPlaceCodes(result), "numbers are compared numerically, missing values last")
+
+	result, err = s.Find(context.Background(), searchDomain.NewSortFilter("rank", "D"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "d", "c"}, marketPlaceCodes(result), "missing values are last in both directions")
+
+	result, err = s.Find(context.Background(), searchDomain.NewSortFilter("price", "A"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "a", "d", "c"}, marketPlaceCodes(result))
+
+	result, err = s.Find(context.Background(), searchDomain.NewSortFilter("price", "D"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"d", "a", "b", "c"}, marketPlaceCodes(result))
+
+	result, err = s.Find(context.Background(), searchDomain.NewSortFilter("new", "A"))
+	require.NoError(t, err)
+	hits := marketPlaceCodes(result)
+	require.Len(t, hits, 4)
+	assert.Equal(t, "b", hits[0], "false is sorted before true")
+	assert.Equal(t, "c", hits[3])
+}
+
+func TestInMemoryProductRepository_UpdateExistingProduct(t *testing.T) {
+	s := new(InMemoryProductRepository).Inject(flamingo.NullLogger{}, &inMemoryRepositoryConfig{})
+
+	newProduct := func(title string, color string, category string, categoryName string) domain.SimpleProduct {
+		return domain.SimpleProduct{
+			Identifier: "a",
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: "a",
+				Title:           title,
+				Categories:      []domain.CategoryTeaser{{Code: category, Name: categoryName, Parent: &domain.CategoryTeaser{Code: "root"}}},
+				Attributes: domain.Attributes{
+					"color": domain.Attribute{Code: "color", Label: color, RawValue: color},
+				},
+			},
+		}
+	}
+	require.NoError(t, s.UpdateProducts(context.Background(), []domain.BasicProduct{newProduct("Red Shirt", "red", "shirts", "Shirts")}))
+	require.NoError(t, s.UpdateProducts(context.Background(), []domain.BasicProduct{newProduct("Blue Pants", "blue", "pants", "Pants")}))
+	assert.Equal(t, int64(1), s.DocumentsCount(), "the product is replaced, not added twice")
+
+	product, err := s.FindByMarketplaceCode(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, "Blue Pants", product.BaseData().Title)
+
+	for _, filter := range []searchDomain.Filter{
+		searchDomain.NewKeyValueFilter("color", []string{"red"}),
+		searchDomain.NewKeyValueFilter("category", []string{"shirts"}),
+		searchDomain.NewQueryFilter("shirt"),
+	} {
+		result, err := s.Find(context.Background(), filter)
+		require.NoError(t, err)
+		assert.Empty(t, result.Hits, "the previous values of the product do not match anymore")
+	}
+
+	for _, filter := range []searchDomain.Filter{
+		searchDomain.NewKeyValueFilter("color", []string{"blue"}),
+		searchDomain.NewKeyValueFilter("category", []string{"pants"}),
+		searchDomain.NewQueryFilter("pants"),
+	} {
+		result, err := s.Find(context.Background(), filter)
+		require.NoError(t, err)
+		assert.Len(t, result.Hits, 1)
+	}
+
+	for _, categoryName := range []string{"Pants", "Trousers"} {
+		require.NoError(t, s.UpdateByCategoryTeasers(context.Background(), []domain.CategoryTeaser{
+			{Code: "shirts", Name: "Shirts", Parent: &domain.CategoryTeaser{Code: "root"}},
+			{Code: "pants", Name: categoryName, Parent: &domain.CategoryTeaser{Code: "root"}},
+		}))
+	}
+	category, err := s.Category(context.Background(), "pants")
+	require.NoError(t, err)
+	assert.Equal(t, "Trousers", category.Name(), "the category name is updated")
+	root, err := s.CategoryTree(context.Background(), "root")
+	require.NoError(t, err)
+	assert.Len(t, root.SubTrees(), 2, "the category is merged into the existing tree")
+}
@@ -0,0 +1,275 @@
+package commercesearch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	categoryDomain "flamingo.me/flamingo-commerce/v3/category/domain"
+	productDomain "flamingo.me/flamingo-commerce/v3/product/domain"
+)
+
+type (
+	// snapshotHeader is written in front of the snapshot to reject files of other formats or versions before decoding them
+	snapshotHeader struct {
+		Format  string
+		Version int
+		// SourceFingerprint identifies the source data the snapshot was indexed from
+		SourceFingerprint string
+	}
+
+	// inMemorySnapshot contains the products, reverse indexes and the category tree of the in-memory repository
+	inMemorySnapshot struct {
+		Products                         []productDomain.BasicProduct
+		AttributeReverseIndex            map[string]map[string]sortedCodes
+		FacetValueLabels                 map[string]map[string]string
+		ProductsByCategoriesReverseIndex map[string]sortedCodes
+		SuggestTerms                     []string
+		SuggestTermReverseIndex          map[string]sortedCodes
+		// QueryAttributes the attributes of the full-text index, the index is rebuilt if they are configured differently
+		QueryAttributes       []string
+		FullTextPostings      map[string]map[string]float64
+		FullTextDocumentTerms map[string][]string
+		RootCategory          *categoryDomain.TreeData
+	}
+)
+
+const (
+	snapshotFormat = "flamingo-commerce-adapter-standalone/inMemoryProductRepository"
+	// snapshotVersion has to be increased if the content of inMemorySnapshot changes
+	snapshotVersion = 2
+)
+
+var (
+	// errSnapshotSourceChanged is returned for snapshots of other source data
+	errSnapshotSourceChanged = errors.New("the source data changed since the snapshot")
+)
+
+// WriteSnapshot writes the products, reverse indexes and the category tree as gzip compressed snapshot of the source data with the fingerprint
+func (r *InMemoryProductRepository) WriteSnapshot(w io.Writer, sourceFingerprint string) error {
+	r.addReadMutex.RLock()
+	defer r.addReadMutex.RUnlock()
+
+	snapshot := inMemorySnapshot{
+		Products:                         make([]productDomain.BasicProduct, 0, len(r.marketplaceCodeIndex)),
+		AttributeReverseIndex:            r.attributeReverseIndex,
+		FacetValueLabels:                 r.facetValueLabels,
+		ProductsByCategoriesReverseIndex: r.productsByCategoriesReverseIndex,
+		SuggestTerms:                     r.suggestTerms,
+		SuggestTermReverseIndex:          r.suggestTermReverseIndex,
+		QueryAttributes:                  r.queryAttributes,
+		FullTextPostings:                 r.fullTextIndex.postings,
+		FullTextDocumentTerms:            r.fullTextIndex.documentTerms,
+		RootCategory:                     r.rootCategory,
+	}
+	for _, product := range r.marketplaceCodeIndex {
+		snapshot.Products = append(snapshot.Products, gobProduct(product))
+	}
+
+	zw := gzip.NewWriter(w)
+	enc := gob.NewEncoder(zw)
+	err := enc.Encode(snapshotHeader{Format: snapshotFormat, Version: snapshotVersion, SourceFingerprint: sourceFingerprint})
+	if err != nil {
+		return err
+	}
+	err = enc.Encode(&snapshot)
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// ReadSnapshot replaces the content of the repository with the snapshot, snapshots of other source data are rejected with errSnapshotSourceChanged
+func (r *InMemoryProductRepository) ReadSnapshot(reader io.Reader, sourceFingerprint string) error {
+	zr, err := gzip.NewReader(reader)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	dec := gob.NewDecoder(zr)
+	var header snapshotHeader
+	err = dec.Decode(&header)
+	if err != nil {
+		return err
+	}
+	if header.Format != snapshotFormat || header.Version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot %q version %d, expected %q version %d", header.Format, header.Version, snapshotFormat, snapshotVersion)
+	}
+	if header.SourceFingerprint != sourceFingerprint {
+		return errSnapshotSourceChanged
+	}
+	var snapshot inMemorySnapshot
+	err = dec.Decode(&snapshot)
+	if err != nil {
+		return err
+	}
+
+	r.addReadMutex.Lock()
+	defer r.addReadMutex.Unlock()
+
+	r.marketplaceCodeIndex = make(map[string]productDomain.BasicProduct, len(snapshot.Products))
+	r.variantParents = variantParentIndex{}
+	marketPlaceCodes := make([]string, 0, len(snapshot.Products))
+	for _, product := range snapshot.Products {
+		product = productFromGob(product)
+		r.marketplaceCodeIndex[product.BaseData().MarketPlaceCode] = product
+		marketPlaceCodes = append(marketPlaceCodes, product.BaseData().MarketPlaceCode)
+		r.variantParents.add(product)
+	}
+	r.marketplaceCodes = newSortedCodes(marketPlaceCodes)
+	r.attributeReverseIndex = snapshot.AttributeReverseIndex
+	r.facetValueLabels = snapshot.FacetValueLabels
+	r.productsByCategoriesReverseIndex = snapshot.ProductsByCategoriesReverseIndex
+	r.suggestTerms = snapshot.SuggestTerms
+	r.suggestTermReverseIndex = snapshot.SuggestTermReverseIndex
+
+	r.fullTextIndex = fullTextIndex{postings: snapshot.FullTextPostings, documentTerms: snapshot.FullTextDocumentTerms}
+	if !equalStrings(snapshot.QueryAttributes, r.queryAttributes) {
+		r.logger.Info("Query attributes changed since the snapshot, rebuilding the full-text index")
+		r.fullTextIndex = fullTextIndex{}
+		for marketPlaceCode, product := range r.marketplaceCodeIndex {
+			r.fullTextIndex.add(marketPlaceCode, productFullTextFields(product, r.queryAttributes))
+		}
+	}
+
+	r.rootCategory = snapshot.RootCategory
+	r.categoryTreeIndex = make(map[string]*categoryDomain.TreeData)
+	if r.rootCategory != nil {
+		r.updateCategoryIndex(r.rootCategory)
+	}
+	return nil
+}
+
+// SaveSnapshot writes the snapshot of the source data with the fingerprint to the configured snapshot file
+func (r *InMemoryProductRepository) SaveSnapshot(_ context.Context, sourceFingerprint string) error {
+	if r.snapshotConfig.File == "" {
+		return errors.New("no snapshot file configured")
+	}
+
+	// write to a temporary file first, a running restore never sees a partly written snapshot
+	file, err := os.CreateTemp(filepath.Dir(r.snapshotConfig.File), filepath.Base(r.snapshotConfig.File)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	err = r.WriteSnapshot(file, sourceFingerprint)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(file.Name(), r.snapshotConfig.File)
+	if err != nil {
+		return err
+	}
+	r.logger.Info("Saved snapshot with ", r.DocumentsCount(), " products to ", r.snapshotConfig.File)
+	return nil
+}
+
+// RestoreSnapshot loads the configured snapshot file, returns false if there is no snapshot of the source data with the fingerprint
+func (r *InMemoryProductRepository) RestoreSnapshot(_ context.Context, sourceFingerprint string) (bool, error) {
+	if r.snapshotConfig.File == "" || !r.snapshotConfig.Restore {
+		return false, nil
+	}
+	file, err := os.Open(r.snapshotConfig.File)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	err = r.ReadSnapshot(file, sourceFingerprint)
+	if err == errSnapshotSourceChanged {
+		r.logger.Info("Source data changed since the snapshot ", r.snapshotConfig.File, ", reindexing")
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	r.logger.Info("Restored snapshot with ", r.DocumentsCount(), " products from ", r.snapshotConfig.File)
+	return true, nil
+}
+
+// IndexFinished saves the snapshot if configured
+func (r *InMemoryProductRepository) IndexFinished(ctx context.Context, sourceFingerprint string) error {
+	if r.snapshotConfig.File == "" || !r.snapshotConfig.SaveAfterIndexing {
+		return nil
+	}
+	if sourceFingerprint == "" {
+		r.logger.Info("The loader has no source fingerprint, the snapshot could never be restored and is not saved")
+		return nil
+	}
+	return r.SaveSnapshot(ctx, sourceFingerprint)
+}
+
+// gobProduct returns the product as pointer, gob only decodes registered pointer types into the interface
+func gobProduct(product productDomain.BasicProduct) productDomain.BasicProduct {
+	if sp, ok := product.(productDomain.SimpleProduct); ok {
+		return &sp
+	}
+	if cp, ok := product.(productDomain.ConfigurableProduct); ok {
+		return &cp
+	}
+	return product
+}
+
+// productFromGob reverts gobProduct
+func productFromGob(product productDomain.BasicProduct) productDomain.BasicProduct {
+	if p, ok := product.(*productDomain.SimpleProduct); ok {
+		return *p
+	}
+	if p, ok := product.(*productDomain.ConfigurableProduct); ok {
+		return *p
+	}
+	return product
+}
+
+// encodeGobProduct encodes the product with gob
+func encodeGobProduct(product productDomain.BasicProduct) ([]byte, error) {
+	var mess bytes.Buffer
+	product = gobProduct(product)
+
+	enc := gob.NewEncoder(&mess)
+	err := enc.Encode(&product)
+	if err != nil {
+		return nil, err
+	}
+	return mess.Bytes(), nil
+}
+
+// decodeGobProduct reverts encodeGobProduct
+func decodeGobProduct(b []byte) (productDomain.BasicProduct, error) {
+	dec := gob.NewDecoder(bytes.NewBuffer(b))
+
+	var sp productDomain.BasicProduct
+	err := dec.Decode(&sp)
+	if err != nil {
+		return nil, err
+	}
+	return productFromGob(sp), nil
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,130 @@
+package commercesearch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/gob"
+	"path/filepath"
+	"testing"
+
+	priceDomain "flamingo.me/flamingo-commerce/v3/price/domain"
+	"flamingo.me/flamingo-commerce/v3/product/domain"
+	searchDomain "flamingo.me/flamingo-commerce/v3/search/domain"
+	"flamingo.me/flamingo/v3/framework/config"
+	"flamingo.me/flamingo/v3/framework/flamingo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemoryProductRepository_Snapshot(t *testing.T) {
+	snapshotFile := filepath.Join(t.TempDir(), "products.snapshot")
+	newRepository := func() *InMemoryProductRepository {
+		return new(InMemoryProductRepository).Inject(flamingo.NullLogger{}, &inMemoryRepositoryConfig{
+			EnableCategoryFacet:    true,
+			FacetConfig:            config.Slice{config.Map{"attributeCode": "color"}},
+			QueryAttributes:        config.Slice{"color"},
+			SnapshotFile:           snapshotFile,
+			SnapshotRestore:        true,
+			SnapshotSaveAfterIndex: true,
+		})
+	}
+
+	shirt := domain.SimpleProduct{
+		Identifier: "shirt",
+		BasicProductData: domain.BasicProductData{
+			MarketPlaceCode: "shirt",
+			Title:           "Running Shirt",
+			Categories:      []domain.CategoryTeaser{{Code: "shirts", Name: "Shirts", Parent: &domain.CategoryTeaser{Code: "root"}}},
+			Attributes: domain.Attributes{
+				"color": domain.Attribute{Code: "color", Label: "Red", RawValue: "red"},
+				"size":  domain.Attribute{Code: "size", RawValue: []interface{}{"s", "m"}},
+			},
+		},
+		Teaser: domain.TeaserData{TeaserPrice: domain.PriceInfo{Default: priceDomain.NewFromInt(1999, 100, "€")}},
+	}
+	jacket := domain.ConfigurableProduct{
+		Identifier: "jacket",
+		BasicProductData: domain.BasicProductData{
+			MarketPlaceCode: "jacket",
+			Title:           "Rain Jacket",
+			Categories:      []domain.CategoryTeaser{{Code: "jackets", Name: "Jackets", Parent: &domain.CategoryTeaser{Code: "root"}}},
+		},
+		Variants: []domain.Variant{{BasicProductData: domain.BasicProductData{
+			MarketPlaceCode: "jacket-blue",
+			Title:           "Rain Jacket Blue",
+			Attributes:      domain.Attributes{"color": domain.Attribute{Code: "color", Label: "Blue", RawValue: "blue"}},
+		}}},
+	}
+
+	indexed := newRepository()
+	restored, err := indexed.RestoreSnapshot(context.Background(), "products.csv 1")
+	require.NoError(t, err)
+	assert.False(t, restored, "there is no snapshot yet")
+
+	require.NoError(t, indexed.UpdateProducts(context.Background(), []domain.BasicProduct{shirt, jacket}))
+	require.NoError(t, indexed.UpdateByCategoryTeasers(context.Background(), append(shirt.Categories, jacket.Categories...)))
+	require.NoError(t, indexed.IndexFinished(context.Background(), "products.csv 1"))
+
+	restored, err = newRepository().RestoreSnapshot(context.Background(), "products.csv 2")
+	require.NoError(t, err)
+	assert.False(t, restored, "snapshots of other source data are not restored")
+
+	s := newRepository()
+	restored, err = s.RestoreSnapshot(context.Background(), "products.csv 1")
+	require.NoError(t, err)
+	require.True(t, restored)
+	assert.Equal(t, int64(2), s.DocumentsCount())
+
+	product, err := s.FindByMarketplaceCode(context.Background(), "shirt")
+	require.NoError(t, err)
+	assert.Equal(t, shirt, product)
+	assert.Equal(t, 19.99, product.TeaserData().TeaserPrice.Default.FloatAmount())
+
+	product, err = s.FindByMarketplaceCode(context.Background(), "jacket-blue")
+	require.NoError(t, err)
+	assert.Equal(t, domain.TypeConfigurableWithActiveVariant, product.Type(), "the variant index is rebuilt")
+
+	result, err := s.Find(context.Background(), searchDomain.NewKeyValueFilter("size", []string{"m"}))
+	require.NoError(t, err)
+	require.Len(t, result.Hits, 1)
+	assert.Equal(t, "shirt", result.Hits[0].BaseData().MarketPlaceCode)
+
+	result, err = s.Find(context.Background(), searchDomain.NewQueryFilter("blue"))
+	require.NoError(t, err)
+	require.Len(t, result.Hits, 1)
+	assert.Equal(t, "jacket", result.Hits[0].BaseData().MarketPlaceCode)
+
+	result, err = s.Find(context.Background(), searchDomain.NewKeyValueFilter("category", []string{"jackets"}))
+	require.NoError(t, err)
+	assert.Len(t, result.Hits, 1)
+	assert.Len(t, result.Facets["category"].Items, 1)
+	assert.Len(t, result.Facets["color"].Items, 1)
+
+	category, err := s.Category(context.Background(), "shirts")
+	require.NoError(t, err)
+	assert.Equal(t, "Shirts", category.Name())
+
+	require.NoError(t, s.UpdateProducts(context.Background(), []domain.BasicProduct{domain.SimpleProduct{
+		Identifier:       "socks",
+		BasicProductData: domain.BasicProductData{MarketPlaceCode: "socks", Title: "Running Socks"},
+	}}))
+	result, err = s.Find(context.Background(), searchDomain.NewQueryFilter("running"))
+	require.NoError(t, err)
+	assert.Len(t, result.Hits, 2, "the restored repository is updated like an indexed one")
+}
+
+func TestInMemoryProductRepository_SnapshotVersion(t *testing.T) {
+	var buffer bytes.Buffer
+	zw := gzip.NewWriter(&buffer)
+	require.NoError(t, gob.NewEncoder(zw).Encode(snapshotHeader{Format: snapshotFormat, Version: snapshotVersion + 1}))
+	require.NoError(t, zw.Close())
+
+	s := new(InMemoryProductRepository).Inject(flamingo.NullLogger{}, &inMemoryRepositoryConfig{})
+	assert.Error(t, s.ReadSnapshot(&buffer, ""), "snapshots of other versions are rejected")
+
+	assert.Error(t, s.SaveSnapshot(context.Background(), ""), "no snapshot file configured")
+	restored, err := s.RestoreSnapshot(context.Background(), "")
+	assert.NoError(t, err)
+	assert.False(t, restored)
+}
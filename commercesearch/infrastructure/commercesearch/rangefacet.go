@@ -0,0 +1,143 @@
+package commercesearch
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"flamingo.me/flamingo/v3/framework/flamingo"
+)
+
+type (
+	// facetRange is a bucket of a range facet, the lower bound is inclusive and the upper bound exclusive
+	facetRange struct {
+		From  *float64
+		To    *float64
+		Label string
+	}
+)
+
+const (
+	facetTypeList  = "list"
+	facetTypeRange = "range"
+	// rangeOpenBound marks a missing bound in a range facet value, e.g. "100-*"
+	rangeOpenBound = "*"
+)
+
+// supportedListFacetConfig returns the facet configs for repositories that only count list facets. Range facets are
+// logged and skipped and multi-select facets become plain list facets if the repository does not support them, so the
+// same facet config works with all adapters
+func supportedListFacetConfig(logger flamingo.Logger, facetConfigs []facetConfig, multiSelect bool) []facetConfig {
+	supported := make([]facetConfig, 0, len(facetConfigs))
+	for _, facetConfig := range facetConfigs {
+		if facetConfig.Type == facetTypeRange {
+			logger.Warn("range facets are not supported by this repository, skipping facet " + facetConfig.AttributeCode)
+			continue
+		}
+		if facetConfig.MultiSelect && !multiSelect {
+			logger.Warn("multi-select facets are not supported by this repository, counting facet " + facetConfig.AttributeCode + " as list facet")
+			facetConfig.MultiSelect = false
+		}
+		supported = append(supported, facetConfig)
+	}
+	return supported
+}
+
+// Value returns the filter value of the range, e.g. "10-20", "*-10" or "100-*"
+func (f facetRange) Value() string {
+	return formatRangeBound(f.From) + "-" + formatRangeBound(f.To)
+}
+
+// DisplayLabel returns the configured label or the range value
+func (f facetRange) DisplayLabel() string {
+	if f.Label != "" {
+		return f.Label
+	}
+	return f.Value()
+}
+
+// contains checks if the value is within the range
+func (f facetRange) contains(value float64) bool {
+	return (f.From == nil || value >= *f.From) && (f.To == nil || value < *f.To)
+}
+
+func formatRangeBound(bound *float64) string {
+	if bound == nil {
+		return rangeOpenBound
+	}
+	return strconv.FormatFloat(*bound, 'f', -1, 64)
+}
+
+// parseFacetRange parses a range facet value like "10-20", "*-10" or "100-*"
+func parseFacetRange(value string) (facetRange, error) {
+	// skip the first rune, it may be the sign of a negative lower bound
+	separator := -1
+	if len(value) > 1 {
+		separator = strings.Index(value[1:], "-")
+	}
+	if separator < 0 {
+		return facetRange{}, errors.New("invalid range value " + value)
+	}
+	separator++
+
+	from, err := parseRangeBound(value[:separator])
+	if err != nil {
+		return facetRange{}, err
+	}
+	to, err := parseRangeBound(value[separator+1:])
+	if err != nil {
+		return facetRange{}, err
+	}
+	if from == nil && to == nil {
+		return facetRange{}, errors.New("invalid range value " + value)
+	}
+	return facetRange{From: from, To: to}, nil
+}
+
+func parseRangeBound(bound string) (*float64, error) {
+	if bound == rangeOpenBound || bound == "" {
+		return nil, nil
+	}
+	value, err := strconv.ParseFloat(bound, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &value, nil
+}
+
+// automaticFacetRanges covers the interval between min and max with roughly the given amount of equally sized buckets with "nice" bounds
+func automaticFacetRanges(min float64, max float64, buckets int) []facetRange {
+	if buckets < 1 || min > max {
+		return nil
+	}
+
+	width := niceBucketWidth((max - min) / float64(buckets))
+	start := math.Floor(min/width) * width
+	var ranges []facetRange
+	for i := 0; start+float64(i)*width <= max; i++ {
+		// round to get rid of floating point artifacts like 0.30000000000000004
+		bucketFrom := roundRangeBound(start + float64(i)*width)
+		bucketTo := roundRangeBound(start + float64(i+1)*width)
+		ranges = append(ranges, facetRange{From: &bucketFrom, To: &bucketTo})
+	}
+	return ranges
+}
+
+func roundRangeBound(bound float64) float64 {
+	return math.Round(bound*1e10) / 1e10
+}
+
+// niceBucketWidth rounds the width up to 1, 2 or 5 times a power of ten
+func niceBucketWidth(width float64) float64 {
+	if width <= 0 {
+		return 1
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(width)))
+	for _, factor := range []float64{1, 2, 5, 10} {
+		if width <= factor*magnitude {
+			return factor * magnitude
+		}
+	}
+	return 10 * magnitude
+}
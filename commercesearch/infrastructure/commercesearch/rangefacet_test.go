@@ -0,0 +1,50 @@
+package commercesearch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFacetRange(t *testing.T) {
+	facetRange, err := parseFacetRange("10-20.5")
+	require.NoError(t, err)
+	assert.Equal(t, 10.0, *facetRange.From)
+	assert.Equal(t, 20.5, *facetRange.To)
+	assert.Equal(t, "10-20.5", facetRange.Value())
+
+	facetRange, err = parseFacetRange("*-10")
+	require.NoError(t, err)
+	assert.Nil(t, facetRange.From)
+	assert.Equal(t, 10.0, *facetRange.To)
+
+	facetRange, err = parseFacetRange("-5-*")
+	require.NoError(t, err)
+	assert.Equal(t, -5.0, *facetRange.From)
+	assert.Nil(t, facetRange.To)
+	assert.Equal(t, "-5-*", facetRange.Value())
+
+	for _, invalid := range []string{"", "10", "*-*", "a-b"} {
+		_, err = parseFacetRange(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestAutomaticFacetRanges(t *testing.T) {
+	var values []string
+	for _, facetRange := range automaticFacetRanges(7.99, 93.5, 5) {
+		values = append(values, facetRange.Value())
+	}
+	assert.Equal(t, []string{"0-20", "20-40", "40-60", "60-80", "80-100"}, values)
+
+	values = nil
+	for _, facetRange := range automaticFacetRanges(0.1, 0.45, 4) {
+		values = append(values, facetRange.Value())
+	}
+	assert.Equal(t, []string{"0.1-0.2", "0.2-0.3", "0.3-0.4", "0.4-0.5"}, values)
+
+	ranges := automaticFacetRanges(5, 5, 5)
+	require.Len(t, ranges, 1, "a single value needs one bucket")
+	assert.Equal(t, "5-6", ranges[0].Value())
+}
@@ -0,0 +1,155 @@
+package commercesearch
+
+import (
+	searchDomain "flamingo.me/flamingo-commerce/v3/search/domain"
+)
+
+type (
+	// sortConfig defines a sortable attribute or, with Name and Fields, a named sort preset made of several fields
+	sortConfig struct {
+		AttributeCode string
+		AttributeType string
+		// DateLayout is the layout of date attributes, the default is RFC3339
+		DateLayout string
+		Asc        bool
+		Desc       bool
+		Name       string
+		Label      string
+		Fields     []sortField
+	}
+
+	// sortField is a field of a sort preset, the direction is reversed if the preset is selected descending
+	sortField struct {
+		AttributeCode string
+		AttributeType string
+		DateLayout    string
+		Desc          bool
+	}
+)
+
+const (
+	// sortFieldScore sorts by relevance, best matches first
+	sortFieldScore = "_score"
+)
+
+// name of the sort option, used as field of the SortFilter
+func (s sortConfig) name() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return s.AttributeCode
+}
+
+// label of the sort option, defaults to the name
+func (s sortConfig) label() string {
+	if s.Label != "" {
+		return s.Label
+	}
+	return s.name()
+}
+
+// sortFields returns the fields of the preset or the configured attribute
+func (s sortConfig) sortFields() []sortField {
+	if len(s.Fields) > 0 {
+		return s.Fields
+	}
+	return []sortField{{AttributeCode: s.AttributeCode, AttributeType: s.AttributeType, DateLayout: s.DateLayout}}
+}
+
+// findSortConfig returns the sort config with the given name
+func findSortConfig(sortConfigs []sortConfig, name string) (sortConfig, bool) {
+	for _, s := range sortConfigs {
+		if s.name() == name {
+			return s, true
+		}
+	}
+	return sortConfig{}, false
+}
+
+// configuredSortFilter returns the sort filter if its field is a configured sort option, the relevance or the price,
+// nil otherwise: values of other attributes are not indexed for sorting by all adapters, so the results fall back
+// to the default order instead of an adapter specific one
+func configuredSortFilter(sortConfigs []sortConfig, sortFilter *searchDomain.SortFilter) *searchDomain.SortFilter {
+	if sortFilter == nil {
+		return nil
+	}
+	if _, ok := findSortConfig(sortConfigs, sortFilter.Field()); ok {
+		return sortFilter
+	}
+	if sortFilter.Field() == sortFieldScore || sortFilter.Field() == priceAttributeCode {
+		return sortFilter
+	}
+	return nil
+}
+
+// selectedSortFields returns the fields of the selected preset or attribute with the direction of the sort filter,
+// the default is the relevance for queries and the title otherwise
+func selectedSortFields(sortConfigs []sortConfig, sortFilter *searchDomain.SortFilter, hasQuery bool) []sortField {
+	if sortFilter == nil && hasQuery {
+		return []sortField{{AttributeCode: sortFieldScore}, {AttributeCode: "title"}}
+	}
+	if sortFilter == nil {
+		return []sortField{{AttributeCode: "title"}}
+	}
+	sortFields := []sortField{{AttributeCode: sortFilter.Field()}}
+	if sortConfig, ok := findSortConfig(sortConfigs, sortFilter.Field()); ok {
+		sortFields = sortConfig.sortFields()
+	}
+	directedSortFields := make([]sortField, 0, len(sortFields))
+	for _, field := range sortFields {
+		// a descending preset reverses each of its fields
+		field.Desc = field.Desc != sortFilter.Descending()
+		directedSortFields = append(directedSortFields, field)
+	}
+	// ties are sorted by title
+	return append(directedSortFields, sortField{AttributeCode: "title"})
+}
+
+// indexedSortFields returns the attribute fields of all sort configs and presets, each attribute once
+func indexedSortFields(sortConfigs []sortConfig) []sortField {
+	var fields []sortField
+	added := make(map[string]bool)
+	for _, s := range sortConfigs {
+		for _, field := range s.sortFields() {
+			if field.AttributeCode == "" || field.AttributeCode == sortFieldScore || added[field.AttributeCode] {
+				continue
+			}
+			added[field.AttributeCode] = true
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// configSortOptions returns the sort options of the sort configs
+func configSortOptions(sortConfigs []sortConfig) []searchDomain.SortOption {
+	sortOptions := make([]searchDomain.SortOption, 0, len(sortConfigs))
+	for _, s := range sortConfigs {
+		sortOption := searchDomain.SortOption{
+			Label: s.label(),
+			Field: s.name(),
+		}
+		if s.Asc {
+			sortOption.Asc = s.name()
+		}
+		if s.Desc {
+			sortOption.Desc = s.name()
+		}
+		sortOptions = append(sortOptions, sortOption)
+	}
+	return sortOptions
+}
+
+// markSelectedSortOption sets SelectedAsc / SelectedDesc of the sort option matching the sort filter
+func markSelectedSortOption(sortOptions []searchDomain.SortOption, sortFilter *searchDomain.SortFilter) {
+	if sortFilter == nil {
+		return
+	}
+	for i := range sortOptions {
+		if sortOptions[i].Field != sortFilter.Field() {
+			continue
+		}
+		sortOptions[i].SelectedDesc = sortFilter.Descending()
+		sortOptions[i].SelectedAsc = sortFilter.Direction() == searchDomain.SortDirectionAscending
+	}
+}
@@ -0,0 +1,134 @@
+package commercesearch
+
+import (
+	"sort"
+)
+
+type (
+	// sortedCodes is a sorted set of marketplace codes, the reverse indexes of the in-memory repository are built on it
+	// to intersect and unite them in linear time
+	sortedCodes []string
+)
+
+// newSortedCodes returns the sorted set of the codes
+func newSortedCodes(codes []string) sortedCodes {
+	set := make(sortedCodes, len(codes))
+	copy(set, codes)
+	sort.Strings(set)
+
+	unique := set[:0]
+	for i, code := range set {
+		if i > 0 && code == set[i-1] {
+			continue
+		}
+		unique = append(unique, code)
+	}
+	return unique
+}
+
+// add returns the set with the code
+func (s sortedCodes) add(code string) sortedCodes {
+	// codes are often indexed in order, so appending is the fast path
+	if len(s) == 0 || s[len(s)-1] < code {
+		return append(s, code)
+	}
+	i := sort.SearchStrings(s, code)
+	if s[i] == code {
+		return s
+	}
+	s = append(s, "")
+	copy(s[i+1:], s[i:])
+	s[i] = code
+	return s
+}
+
+// remove returns the set without the code
+func (s sortedCodes) remove(code string) sortedCodes {
+	i := sort.SearchStrings(s, code)
+	if i >= len(s) || s[i] != code {
+		return s
+	}
+	return append(s[:i], s[i+1:]...)
+}
+
+// contains checks if the code is part of the set
+func (s sortedCodes) contains(code string) bool {
+	i := sort.SearchStrings(s, code)
+	return i < len(s) && s[i] == code
+}
+
+// intersection returns the codes that are part of both sets
+func (s sortedCodes) intersection(other sortedCodes) sortedCodes {
+	result := make(sortedCodes, 0, minInt(len(s), len(other)))
+	i, j := 0, 0
+	for i < len(s) && j < len(other) {
+		switch {
+		case s[i] < other[j]:
+			i++
+		case s[i] > other[j]:
+			j++
+		default:
+			result = append(result, s[i])
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+// union returns the codes that are part of any of the sets
+func (s sortedCodes) union(other sortedCodes) sortedCodes {
+	result := make(sortedCodes, 0, len(s)+len(other))
+	i, j := 0, 0
+	for i < len(s) && j < len(other) {
+		switch {
+		case s[i] < other[j]:
+			result = append(result, s[i])
+			i++
+		case s[i] > other[j]:
+			result = append(result, other[j])
+			j++
+		default:
+			result = append(result, s[i])
+			i++
+			j++
+		}
+	}
+	result = append(result, s[i:]...)
+	return append(result, other[j:]...)
+}
+
+// difference returns the codes that are not part of the other set
+func (s sortedCodes) difference(other sortedCodes) sortedCodes {
+	result := make(sortedCodes, 0, len(s))
+	j := 0
+	for _, code := range s {
+		for j < len(other) && other[j] < code {
+			j++
+		}
+		if j < len(other) && other[j] == code {
+			continue
+		}
+		result = append(result, code)
+	}
+	return result
+}
+
+// intersectionCount returns the number of codes that are part of both sets, without building the intersection
+func (s sortedCodes) intersectionCount(other sortedCodes) int {
+	count := 0
+	i, j := 0, 0
+	for i < len(s) && j < len(other) {
+		switch {
+		case s[i] < other[j]:
+			i++
+		case s[i] > other[j]:
+			j++
+		default:
+			count++
+			i++
+			j++
+		}
+	}
+	return count
+}
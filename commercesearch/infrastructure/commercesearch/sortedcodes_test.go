@@ -0,0 +1,30 @@
+package commercesearch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortedCodes(t *testing.T) {
+	codes := newSortedCodes([]string{"c", "a", "b", "a"})
+	assert.Equal(t, sortedCodes{"a", "b", "c"}, codes)
+
+	codes = codes.add("d").add("aa").add("b")
+	assert.Equal(t, sortedCodes{"a", "aa", "b", "c", "d"}, codes)
+	assert.True(t, codes.contains("aa"))
+	assert.False(t, codes.contains("e"))
+
+	codes = codes.remove("aa").remove("e")
+	assert.Equal(t, sortedCodes{"a", "b", "c", "d"}, codes)
+
+	other := sortedCodes{"b", "d", "f"}
+	assert.Equal(t, sortedCodes{"b", "d"}, codes.intersection(other))
+	assert.Equal(t, sortedCodes{"a", "b", "c", "d", "f"}, codes.union(other))
+	assert.Empty(t, codes.intersection(nil))
+	assert.Equal(t, other, sortedCodes(nil).union(other))
+	assert.Equal(t, sortedCodes{"a", "c"}, codes.difference(other))
+	assert.Equal(t, codes, codes.difference(nil))
+	assert.Equal(t, 2, codes.intersectionCount(other))
+	assert.Equal(t, 0, codes.intersectionCount(nil))
+}
@@ -0,0 +1,153 @@
+package commercesearch
+
+import (
+	"strings"
+	"unicode"
+
+	searchDomain "flamingo.me/flamingo-commerce/v3/search/domain"
+)
+
+type (
+	// dictionaryTerm is a term of the indexed term dictionary together with its document frequency
+	dictionaryTerm struct {
+		Term  string
+		Count uint64
+	}
+)
+
+// allowedEdits returns the allowed edit distance for the given term depending on its length
+func (c queryConfig) allowedEdits(term string) int {
+	length := len([]rune(term))
+	if length >= c.MinTermLengthTwoTypos {
+		return 2
+	}
+	if length >= c.MinTermLengthOneTypo {
+		return 1
+	}
+	return 0
+}
+
+// hasTypoPrefix checks if the candidate starts with the same runes as the term (leading runes are not considered as typos)
+func (c queryConfig) hasTypoPrefix(term string, candidate string) bool {
+	termRunes := []rune(term)
+	candidateRunes := []rune(candidate)
+	for i := 0; i < c.TypoPrefixLength; i++ {
+		if i >= len(termRunes) || i >= len(candidateRunes) || termRunes[i] != candidateRunes[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// typoPrefix returns the leading runes of the term which are not considered as typos
+func typoPrefix(term string, typoPrefixLength int) string {
+	if runes := []rune(term); len(runes) > typoPrefixLength {
+		return string(runes[:typoPrefixLength])
+	}
+	return term
+}
+
+// queryTerms splits the user input into lower cased terms that consist of letters and digits only
+func queryTerms(userInput string) []string {
+	return strings.FieldsFunc(strings.ToLower(userInput), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// spellingSuggestions returns a "did you mean" suggestion for the user input based on the given term dictionary
+// every query term that is not part of the dictionary is replaced by the most frequent term with the smallest edit distance
+func spellingSuggestions(userInput string, dictionary []dictionaryTerm, config queryConfig) []searchDomain.Suggestion {
+	terms := queryTerms(userInput)
+	if len(terms) == 0 || len(dictionary) == 0 {
+		return nil
+	}
+
+	known := make(map[string]struct{}, len(dictionary))
+	for _, entry := range dictionary {
+		known[entry.Term] = struct{}{}
+	}
+
+	corrected := false
+	textParts := make([]string, 0, len(terms))
+	highlightParts := make([]string, 0, len(terms))
+	for _, term := range terms {
+		correction := term
+		if _, ok := known[term]; !ok {
+			correction = closestTerm(term, dictionary, config)
+		}
+		textParts = append(textParts, correction)
+		if correction != term {
+			corrected = true
+			highlightParts = append(highlightParts, "<em>"+correction+"</em>")
+			continue
+		}
+		highlightParts = append(highlightParts, correction)
+	}
+
+	if !corrected {
+		return nil
+	}
+	return []searchDomain.Suggestion{
+		{
+			Text:      strings.Join(textParts, " "),
+			Highlight: strings.Join(highlightParts, " "),
+		},
+	}
+}
+
+// closestTerm returns the dictionary term with the smallest edit distance (preferring frequent terms) or the term itself
+func closestTerm(term string, dictionary []dictionaryTerm, config queryConfig) string {
+	maxEdits := config.allowedEdits(term)
+	termLength := len([]rune(term))
+
+	closest := term
+	closestDistance := maxEdits + 1
+	var closestCount uint64
+	for _, entry := range dictionary {
+		lengthDiff := len([]rune(entry.Term)) - termLength
+		if lengthDiff > maxEdits || -lengthDiff > maxEdits || !config.hasTypoPrefix(term, entry.Term) {
+			continue
+		}
+		distance := levenshteinDistance(term, entry.Term)
+		if distance > maxEdits {
+			continue
+		}
+		if distance < closestDistance || (distance == closestDistance && entry.Count > closestCount) {
+			closest = entry.Term
+			closestDistance = distance
+			closestCount = entry.Count
+		}
+	}
+	return closest
+}
+
+// levenshteinDistance returns the number of single rune edits needed to change a into b
+func levenshteinDistance(a, b string) int {
+	runesA := []rune(a)
+	runesB := []rune(b)
+
+	previous := make([]int, len(runesB)+1)
+	current := make([]int, len(runesB)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(runesA); i++ {
+		current[0] = i
+		for j := 1; j <= len(runesB); j++ {
+			cost := 1
+			if runesA[i-1] == runesB[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(runesB)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
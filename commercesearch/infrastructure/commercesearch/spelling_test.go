@@ -0,0 +1,38 @@
+package commercesearch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLevenshteinDistance(t *testing.T) {
+	assert.Equal(t, 0, levenshteinDistance("shoe", "shoe"))
+	assert.Equal(t, 1, levenshteinDistance("shoe", "shoo"))
+	assert.Equal(t, 1, levenshteinDistance("shoe", "shoes"))
+	assert.Equal(t, 2, levenshteinDistance("größe", "grose"))
+	assert.Equal(t, 4, levenshteinDistance("", "shoe"))
+}
+
+func TestSpellingSuggestions(t *testing.T) {
+	config := queryConfig{
+		MinTermLengthOneTypo:  4,
+		MinTermLengthTwoTypos: 8,
+		TypoPrefixLength:      1,
+	}
+	dictionary := []dictionaryTerm{
+		{Term: "shirt", Count: 5},
+		{Term: "shoe", Count: 1},
+		{Term: "shop", Count: 3},
+		{Term: "red", Count: 2},
+	}
+
+	suggestions := spellingSuggestions("red shoo", dictionary, config)
+	if assert.Len(t, suggestions, 1) {
+		assert.Equal(t, "red shop", suggestions[0].Text, "most frequent term wins on equal distance")
+	}
+
+	assert.Empty(t, spellingSuggestions("red shoe", dictionary, config), "no suggestion for known terms")
+	assert.Empty(t, spellingSuggestions("rad", dictionary, config), "no suggestion for short terms")
+	assert.Empty(t, spellingSuggestions("thoe", dictionary, config), "leading runes are no typos")
+}
@@ -0,0 +1,1163 @@
+package commercesearch
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	categoryDomain "flamingo.me/flamingo-commerce/v3/category/domain"
+	productDomain "flamingo.me/flamingo-commerce/v3/product/domain"
+	searchDomain "flamingo.me/flamingo-commerce/v3/search/domain"
+	"flamingo.me/flamingo/v3/framework/config"
+	"flamingo.me/flamingo/v3/framework/flamingo"
+	// registers the pure-Go database/sql driver "sqlite", it is compiled with FTS5
+	_ "modernc.org/sqlite"
+
+	"flamingo.me/flamingo-commerce-adapter-standalone/commercesearch/domain"
+)
+
+type (
+	// SQLiteRepository serves products and categories from an embedded SQLite database, text search uses FTS5.
+	// It uses the pure-Go driver modernc.org/sqlite, no cgo is required
+	SQLiteRepository struct {
+		driverName     string
+		dataSourceName string
+		db             *sql.DB
+		dbMutex        sync.Mutex
+
+		assignProductsToParentCategories bool
+		enableCategoryFacet              bool
+		facetConfig                      []facetConfig
+		sortConfig                       []sortConfig
+		queryAttributes                  []string
+		queryConfig                      queryConfig
+		visibilityConfig                 visibilityConfig
+		collapseVariants                 string
+
+		logger flamingo.Logger
+	}
+
+	// sqliteProductQuery is the FROM and WHERE part of a product query together with its arguments
+	sqliteProductQuery struct {
+		joins      []string
+		joinArgs   []interface{}
+		conditions []string
+		args       []interface{}
+		hasQuery   bool
+	}
+)
+
+const (
+	defaultSQLiteDriverName = "sqlite"
+	sqliteCategoryFilterKey = "category"
+	// sqliteCollapseKey is the marketplace code of the configurable product for variants, the marketplace code itself otherwise
+	sqliteCollapseKey = "COALESCE(pv.marketplace_code, p.marketplace_code)"
+	// sqliteMaxRune is the upper bound of prefix range lookups
+	sqliteMaxRune = string(utf8.MaxRune)
+)
+
+var (
+	_ domain.ProductRepository  = &SQLiteRepository{}
+	_ domain.CategoryRepository = &SQLiteRepository{}
+	_ domain.SuggestRepository  = &SQLiteRepository{}
+
+	// sqliteSchema creates the tables, products_fts is weighted by bm25 in the order title, keywords, attributes, description
+	sqliteSchema = []string{
+		`CREATE TABLE IF NOT EXISTS products (
+			id INTEGER PRIMARY KEY,
+			marketplace_code TEXT NOT NULL UNIQUE,
+			title TEXT NOT NULL,
+			data BLOB NOT NULL,
+			saleable INTEGER NOT NULL,
+			saleable_from INTEGER,
+			saleable_to INTEGER,
+			in_stock INTEGER NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS product_variants (
+			variant_code TEXT PRIMARY KEY,
+			marketplace_code TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS product_variants_marketplace_code ON product_variants (marketplace_code)`,
+		`CREATE TABLE IF NOT EXISTS product_attributes (
+			marketplace_code TEXT NOT NULL,
+			code TEXT NOT NULL,
+			value TEXT NOT NULL,
+			label TEXT NOT NULL,
+			PRIMARY KEY (marketplace_code, code, value)
+		)`,
+		`CREATE INDEX IF NOT EXISTS product_attributes_code_value ON product_attributes (code, value)`,
+		`CREATE TABLE IF NOT EXISTS product_categories (
+			marketplace_code TEXT NOT NULL,
+			category_code TEXT NOT NULL,
+			PRIMARY KEY (marketplace_code, category_code)
+		)`,
+		`CREATE INDEX IF NOT EXISTS product_categories_category_code ON product_categories (category_code)`,
+		`CREATE TABLE IF NOT EXISTS product_sort_values (
+			marketplace_code TEXT NOT NULL,
+			code TEXT NOT NULL,
+			number_value REAL,
+			text_value TEXT,
+			PRIMARY KEY (marketplace_code, code)
+		)`,
+		`CREATE VIRTUAL TABLE IF NOT EXISTS products_fts USING fts5(title, keywords, attributes, description, tokenize = 'unicode61 remove_diacritics 0')`,
+		`CREATE VIRTUAL TABLE IF NOT EXISTS products_fts_vocab USING fts5vocab(products_fts, 'col')`,
+		`CREATE VIRTUAL TABLE IF NOT EXISTS products_fts_terms USING fts5vocab(products_fts, 'row')`,
+		`CREATE TABLE IF NOT EXISTS categories (
+			code TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			path TEXT NOT NULL,
+			parent_code TEXT NOT NULL
+		)`,
+	}
+
+	// sqliteTables are cleared by PrepareIndex
+	sqliteTables = []string{"products", "product_variants", "product_attributes", "product_categories", "product_sort_values", "products_fts", "categories"}
+)
+
+// Inject dependencies
+func (r *SQLiteRepository) Inject(logger flamingo.Logger, config *struct {
+	DriverName                       string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.sqliteAdapter.driverName,optional"`
+	DataSourceName                   string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.sqliteAdapter.dataSourceName,optional"`
+	AssignProductsToParentCategories bool         `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.sqliteAdapter.productsToParentCategories,optional"`
+	EnableCategoryFacet              bool         `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.sqliteAdapter.enableCategoryFacet,optional"`
+	FacetConfig                      config.Slice `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.sqliteAdapter.facetConfig,optional"`
+	SortConfig                       config.Slice `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.sqliteAdapter.sortConfig,optional"`
+	QueryAttributes                  config.Slice `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.sqliteAdapter.query.attributes,optional"`
+	QueryOperator                    string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.sqliteAdapter.query.operator,optional"`
+	QuerySuggestionThreshold         float64      `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.sqliteAdapter.query.suggestionThreshold,optional"`
+	VisibilityNonSaleable            string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.visibility.nonSaleable,optional"`
+	VisibilityOutOfStock             string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.visibility.outOfStock,optional"`
+	CollapseVariants                 string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.collapseVariants,optional"`
+}) *SQLiteRepository {
+	r.logger = logger.WithField(flamingo.LogKeyModule, "flamingo-commerce-adapter-standalone").WithField(flamingo.LogKeyCategory, "SQLiteRepository")
+	r.driverName = defaultSQLiteDriverName
+	r.queryConfig = queryConfig{
+		Operator:              queryOperatorOr,
+		TypoPrefixLength:      1,
+		MinTermLengthOneTypo:  4,
+		MinTermLengthTwoTypos: 8,
+	}
+	// without a data source the database would silently be created in the working directory
+	if config == nil || config.DataSourceName == "" {
+		panic("flamingoCommerceAdapterStandalone.commercesearch.sqliteAdapter.dataSourceName is required for the sqlite repository adapter")
+	}
+	if config.DriverName != "" {
+		r.driverName = config.DriverName
+	}
+	r.dataSourceName = config.DataSourceName
+	r.assignProductsToParentCategories = config.AssignProductsToParentCategories
+	r.enableCategoryFacet = config.EnableCategoryFacet
+
+	var facetConfig []facetConfig
+	err := config.FacetConfig.MapInto(&facetConfig)
+	if err != nil {
+		panic(err)
+	}
+	r.facetConfig = supportedListFacetConfig(r.logger, facetConfig, false)
+
+	var sortConfig []sortConfig
+	err = config.SortConfig.MapInto(&sortConfig)
+	if err != nil {
+		panic(err)
+	}
+	r.sortConfig = sortConfig
+
+	var queryAttributes []string
+	err = config.QueryAttributes.MapInto(&queryAttributes)
+	if err != nil {
+		panic(err)
+	}
+	r.queryAttributes = queryAttributes
+	if config.QueryOperator != "" {
+		r.queryConfig.Operator = config.QueryOperator
+	}
+	r.queryConfig.SuggestionThreshold = int(config.QuerySuggestionThreshold)
+
+	r.visibilityConfig = visibilityConfig{
+		NonSaleable: config.VisibilityNonSaleable,
+		OutOfStock:  config.VisibilityOutOfStock,
+	}
+	r.collapseVariants = config.CollapseVariants
+	return r
+}
+
+// getDB opens the database and creates the schema on first use
+func (r *SQLiteRepository) getDB() (*sql.DB, error) {
+	r.dbMutex.Lock()
+	defer r.dbMutex.Unlock()
+	if r.db != nil {
+		return r.db, nil
+	}
+
+	db, err := sql.Open(r.driverName, r.dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+	for _, statement := range sqliteSchema {
+		_, err = db.Exec(statement)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("creating sqlite schema failed: %w", err)
+		}
+	}
+	r.db = db
+	return db, nil
+}
+
+// Close the database
+func (r *SQLiteRepository) Close() error {
+	r.dbMutex.Lock()
+	defer r.dbMutex.Unlock()
+	if r.db == nil {
+		return nil
+	}
+	err := r.db.Close()
+	r.db = nil
+	return err
+}
+
+// PrepareIndex clears the products and categories, the loader indexes them again
+func (r *SQLiteRepository) PrepareIndex(ctx context.Context) error {
+	db, err := r.getDB()
+	if err != nil {
+		return err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range sqliteTables {
+		_, err = tx.ExecContext(ctx, "DELETE FROM "+table)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DocumentsCount returns the number of products
+func (r *SQLiteRepository) DocumentsCount() int64 {
+	db, err := r.getDB()
+	if err != nil {
+		r.logger.Error(err)
+		return 0
+	}
+	var count int64
+	err = db.QueryRow("SELECT COUNT(*) FROM products").Scan(&count)
+	if err != nil {
+		r.logger.Error(err)
+		return 0
+	}
+	return count
+}
+
+// UpdateProducts adds or replaces the products
+func (r *SQLiteRepository) UpdateProducts(ctx context.Context, products []productDomain.BasicProduct) error {
+	db, err := r.getDB()
+	if err != nil {
+		return err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, product := range products {
+		if product.BaseData().MarketPlaceCode == "" {
+			return fmt.Errorf("No marketplace code %v, %v", product.GetIdentifier(), product.BaseData().Title)
+		}
+		err = r.updateProduct(ctx, tx, product)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// updateProduct replaces the product and its index rows
+func (r *SQLiteRepository) updateProduct(ctx context.Context, tx *sql.Tx, product productDomain.BasicProduct) error {
+	data := product.BaseData()
+	marketPlaceCode := data.MarketPlaceCode
+
+	encoded, err := encodeGobProduct(product)
+	if err != nil {
+		return err
+	}
+	// the availability is stored to evaluate the visibility rules at the time of the request
+	availability := availabilityOf(product)
+	// the id is kept on updates, it is the rowid of the full-text index
+	_, err = tx.ExecContext(ctx, `INSERT INTO products (marketplace_code, title, data, saleable, saleable_from, saleable_to, in_stock) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (marketplace_code) DO UPDATE SET title = excluded.title, data = excluded.data, saleable = excluded.saleable,
+			saleable_from = excluded.saleable_from, saleable_to = excluded.saleable_to, in_stock = excluded.in_stock`,
+		marketPlaceCode, data.Title, encoded, availability.Saleable, sqliteUnixTime(availability.SaleableFrom), sqliteUnixTime(availability.SaleableTo), availability.InStock)
+	if err != nil {
+		return err
+	}
+	var id int64
+	err = tx.QueryRowContext(ctx, "SELECT id FROM products WHERE marketplace_code = ?", marketPlaceCode).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	for _, statement := range []string{
+		"DELETE FROM product_variants WHERE marketplace_code = ?",
+		"DELETE FROM product_attributes WHERE marketplace_code = ?",
+		"DELETE FROM product_categories WHERE marketplace_code = ?",
+		"DELETE FROM product_sort_values WHERE marketplace_code = ?",
+	} {
+		_, err = tx.ExecContext(ctx, statement, marketPlaceCode)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM products_fts WHERE rowid = ?", id)
+	if err != nil {
+		return err
+	}
+
+	for _, variant := range productVariants(product) {
+		_, err = tx.ExecContext(ctx, "INSERT OR REPLACE INTO product_variants (variant_code, marketplace_code) VALUES (?, ?)", variant.MarketPlaceCode, marketPlaceCode)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, attributeCode := range productAttributeCodes(product) {
+		for _, value := range productFacetValues(product, attributeCode) {
+			_, err = tx.ExecContext(ctx, "INSERT OR IGNORE INTO product_attributes (marketplace_code, code, value, label) VALUES (?, ?, ?, ?)", marketPlaceCode, attributeCode, value.Value, value.Label)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	categoryCodes := make([]string, 0, len(data.Categories)+1)
+	for _, categoryTeaser := range append(data.Categories, data.MainCategory) {
+		// like the bleve repository, products can be assigned to the parent categories of their categories
+		for teaser := &categoryTeaser; teaser != nil && teaser.Code != ""; teaser = teaser.Parent {
+			categoryCodes = append(categoryCodes, teaser.Code)
+			if !r.assignProductsToParentCategories {
+				break
+			}
+		}
+	}
+	for _, categoryCode := range categoryCodes {
+		_, err = tx.ExecContext(ctx, "INSERT OR IGNORE INTO product_categories (marketplace_code, category_code) VALUES (?, ?)", marketPlaceCode, categoryCode)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, field := range append(indexedSortFields(r.sortConfig), sortField{AttributeCode: priceAttributeCode}) {
+		number, text, ok := sqliteSortValue(product, field)
+		if !ok {
+			continue
+		}
+		_, err = tx.ExecContext(ctx, "INSERT OR REPLACE INTO product_sort_values (marketplace_code, code, number_value, text_value) VALUES (?, ?, ?, ?)", marketPlaceCode, field.AttributeCode, number, text)
+		if err != nil {
+			return err
+		}
+	}
+
+	var attributeLabels []string
+	for _, attributeCode := range r.queryAttributes {
+		for _, value := range productFacetValues(product, attributeCode) {
+			attributeLabels = append(attributeLabels, value.Label)
+		}
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO products_fts (rowid, title, keywords, attributes, description) VALUES (?, ?, ?, ?, ?)",
+		id, data.Title, strings.Join(data.Keywords, " "), strings.Join(attributeLabels, " "), data.ShortDescription+" "+data.Description)
+	return err
+}
+
+// UpdateByCategoryTeasers adds or updates the categories of the teasers and their parents
+func (r *SQLiteRepository) UpdateByCategoryTeasers(ctx context.Context, categoryTeasers []productDomain.CategoryTeaser) error {
+	db, err := r.getDB()
+	if err != nil {
+		return err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, categoryTeaser := range categoryTeasers {
+		for teaser := &categoryTeaser; teaser != nil && teaser.Code != ""; teaser = teaser.Parent {
+			parentCode := ""
+			if teaser.Parent != nil {
+				parentCode = teaser.Parent.Code
+			}
+			// a known category keeps its name if the teaser has none
+			_, err = tx.ExecContext(ctx, `INSERT INTO categories (code, name, path, parent_code) VALUES (?, ?, ?, ?)
+				ON CONFLICT (code) DO UPDATE SET
+					name = CASE WHEN excluded.name != '' THEN excluded.name ELSE categories.name END,
+					path = CASE WHEN excluded.path != '' THEN excluded.path ELSE categories.path END,
+					parent_code = excluded.parent_code`, teaser.Code, teaser.Name, teaser.Path, parentCode)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// ClearCategories clears given ids from repo
+func (r *SQLiteRepository) ClearCategories(_ context.Context, _ []string) error {
+	return nil
+}
+
+// ClearProducts from Product Repository
+func (r *SQLiteRepository) ClearProducts(_ context.Context, _ []string) error {
+	return nil
+}
+
+// FindByMarketplaceCode returns the product, for variants the configurable product with the variant as active variant
+func (r *SQLiteRepository) FindByMarketplaceCode(ctx context.Context, marketplaceCode string) (productDomain.BasicProduct, error) {
+	db, err := r.getDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var parentMarketplaceCode string
+	err = db.QueryRowContext(ctx, "SELECT marketplace_code FROM product_variants WHERE variant_code = ?", marketplaceCode).Scan(&parentMarketplaceCode)
+	if err == nil {
+		parent, err := r.productByMarketplaceCode(ctx, db, parentMarketplaceCode)
+		if err != nil {
+			return nil, err
+		}
+		return configurableWithActiveVariant(parent, marketplaceCode)
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+	return r.productByMarketplaceCode(ctx, db, marketplaceCode)
+}
+
+func (r *SQLiteRepository) productByMarketplaceCode(ctx context.Context, db *sql.DB, marketplaceCode string) (productDomain.BasicProduct, error) {
+	var data []byte
+	err := db.QueryRowContext(ctx, "SELECT data FROM products WHERE marketplace_code = ?", marketplaceCode).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, productDomain.ProductNotFound{
+			MarketplaceCode: marketplaceCode,
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeGobProduct(data)
+}
+
+// Find returns the products matching the filters
+func (r *SQLiteRepository) Find(ctx context.Context, filters ...searchDomain.Filter) (*productDomain.SearchResult, error) {
+	db, err := r.getDB()
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize := 100
+	pageNumber := 1
+	var sortFilter *searchDomain.SortFilter
+	var userInput string
+	var matchedVariantsFilter *domain.MatchedVariantsFilter
+	var keyValueFilters []*searchDomain.KeyValueFilter
+	productQuery := &sqliteProductQuery{}
+	// the visibility and category filters also apply to the configurable products of collapsed variants
+	parentQuery := &sqliteProductQuery{}
+	for _, filter := range filters {
+		filterKey, filterValues := filter.Value()
+		switch f := filter.(type) {
+		case *searchDomain.KeyValueFilter:
+			// the values of a filter are alternatives, the filters all have to match
+			condition, args := r.valuesCondition(filterKey, filterValues)
+			productQuery.where(condition, args...)
+			if filterKey != sqliteCategoryFilterKey {
+				keyValueFilters = append(keyValueFilters, f)
+			} else {
+				parentQuery.where(condition, args...)
+			}
+		case *domain.ExcludeFilter:
+			condition, args := r.valuesCondition(filterKey, filterValues)
+			productQuery.where("NOT "+condition, args...)
+			if filterKey == sqliteCategoryFilterKey {
+				parentQuery.where("NOT "+condition, args...)
+			}
+		case categoryDomain.CategoryFacet, *categoryDomain.CategoryFacet:
+			condition, args := r.valuesCondition(sqliteCategoryFilterKey, filterValues)
+			productQuery.where(condition, args...)
+			parentQuery.where(condition, args...)
+		case *searchDomain.QueryFilter:
+			userInput = f.Query()
+			match := fullTextMatchExpression(queryTerms(userInput), r.queryConfig.Operator, false)
+			if match == "" {
+				continue
+			}
+			productQuery.hasQuery = true
+			productQuery.join("JOIN (SELECT rowid, bm25(products_fts, 3.0, 2.0, 1.5, 1.0) AS score FROM products_fts WHERE products_fts MATCH ?) fts ON fts.rowid = p.id", match)
+		case *searchDomain.PaginationPageSize:
+			pageSize = f.GetPageSize()
+		case *searchDomain.PaginationPage:
+			pageNumber = f.GetPage()
+		case *searchDomain.SortFilter:
+			sortFilter = configuredSortFilter(r.sortConfig, f)
+		case *domain.MatchedVariantsFilter:
+			matchedVariantsFilter = f
+			matchedVariantsFilter.Reset()
+		}
+	}
+
+	// visibility rules are evaluated at the time of the request
+	now := time.Now()
+	if r.visibilityConfig.hasRule(visibilityHide) {
+		productQuery.where(r.visibilityCondition(visibilityHide, now))
+		parentQuery.where(r.visibilityCondition(visibilityHide, now))
+	}
+
+	collapse := r.collapseVariants == collapseVariantsParent || r.collapseVariants == collapseVariantsVariant
+	countColumn := "COUNT(*)"
+	if collapse {
+		productQuery.join("LEFT JOIN product_variants pv ON pv.variant_code = p.marketplace_code")
+		countColumn = "COUNT(DISTINCT " + sqliteCollapseKey + ")"
+	}
+
+	var totalHits int
+	countStatement, countArgs := productQuery.statement("SELECT "+countColumn, "")
+	err = db.QueryRowContext(ctx, countStatement, countArgs...).Scan(&totalHits)
+	if err != nil {
+		return nil, err
+	}
+
+	if pageNumber < 1 {
+		pageNumber = 1
+	}
+	if pageSize < 0 {
+		pageSize = 0
+	}
+	productResults, err := r.hits(ctx, db, productQuery, parentQuery, r.orderBy(productQuery, selectedSortFields(r.sortConfig, sortFilter, productQuery.hasQuery), now), collapse, pageSize, (pageNumber-1)*pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// facets count all matching products, like the bleve repository
+	facets, err := r.facets(ctx, db, productQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	pageAmount := 0
+	if pageSize > 0 {
+		pageAmount = int(math.Ceil(float64(totalHits) / float64(pageSize)))
+	}
+
+	if matchedVariantsFilter != nil {
+		variantMatches := sortConfigVariantMatcher(r.sortConfig)
+		for _, product := range productResults {
+			matchedVariantsFilter.AddVariants(product.BaseData().MarketPlaceCode, matchedVariants(product, keyValueFilters, variantMatches))
+		}
+	}
+
+	sortOptions := configSortOptions(r.sortConfig)
+	markSelectedSortOption(sortOptions, sortFilter)
+
+	result := &productDomain.SearchResult{
+		Hits: productResults,
+		Result: searchDomain.Result{
+			Facets: facets,
+			SearchMeta: searchDomain.SearchMeta{
+				NumResults:  totalHits,
+				NumPages:    pageAmount,
+				Page:        pageNumber,
+				SortOptions: sortOptions,
+			}},
+	}
+	markActiveFacets(filters, result)
+	applyFacetPresentation(r.facetConfig, result)
+
+	if userInput != "" && totalHits <= r.queryConfig.SuggestionThreshold {
+		dictionary, err := r.spellingDictionary(ctx, db, queryTerms(userInput))
+		if err != nil {
+			return nil, err
+		}
+		result.Suggestion = spellingSuggestions(userInput, dictionary, r.queryConfig)
+	}
+	return result, nil
+}
+
+// hits returns the products of the requested page, collapsed products are grouped by their configurable product.
+// In parent mode the configurable product replaces the group if it matches the parent query
+func (r *SQLiteRepository) hits(ctx context.Context, db *sql.DB, productQuery *sqliteProductQuery, parentQuery *sqliteProductQuery, orderBy string, collapse bool, limit int, offset int) ([]productDomain.BasicProduct, error) {
+	statement, args := productQuery.statement("SELECT p.data", "ORDER BY "+orderBy+" LIMIT ? OFFSET ?")
+	if collapse {
+		groupQuery := productQuery.clone()
+		dataColumn := "p.data"
+		if r.collapseVariants == collapseVariantsParent {
+			// the configurable product replaces the best matching product of its group, unless it is hidden or
+			// filtered out by a category filter
+			parentStatement, parentArgs := parentQuery.statement("SELECT p.marketplace_code", "")
+			groupQuery.join("LEFT JOIN products parent ON parent.marketplace_code = pv.marketplace_code AND parent.marketplace_code IN ("+parentStatement+")", parentArgs...)
+			dataColumn = "COALESCE(parent.data, p.data)"
+		}
+		// the groups depend on the order of all products, the first product of each group is kept
+		var groupArgs []interface{}
+		statement, groupArgs = groupQuery.statement("SELECT "+dataColumn+" AS data, "+
+			"ROW_NUMBER() OVER (PARTITION BY "+sqliteCollapseKey+" ORDER BY "+orderBy+") AS group_position, "+
+			"ROW_NUMBER() OVER (ORDER BY "+orderBy+") AS position", "")
+		statement = "SELECT data FROM (" + statement + ") WHERE group_position = 1 ORDER BY position LIMIT ? OFFSET ?"
+		args = groupArgs
+	}
+
+	rows, err := db.QueryContext(ctx, statement, append(args, limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var products []productDomain.BasicProduct
+	for rows.Next() {
+		var data []byte
+		err = rows.Scan(&data)
+		if err != nil {
+			return nil, err
+		}
+		product, err := decodeGobProduct(data)
+		if err != nil {
+			r.logger.Error(err)
+			continue
+		}
+		products = append(products, product)
+	}
+	return products, rows.Err()
+}
+
+// visibilityCondition returns the condition for products that pass all visibility rules with the given mode
+func (r *SQLiteRepository) visibilityCondition(mode string, now time.Time) string {
+	// the time is part of the statement, so the condition can be used in ORDER BY clauses without arguments
+	unixNow := strconv.FormatInt(now.Unix(), 10)
+	conditions := []string{"1"}
+	if r.visibilityConfig.NonSaleable == mode {
+		conditions = append(conditions, "(p.saleable = 1 AND (p.saleable_from IS NULL OR p.saleable_from < "+unixNow+") AND (p.saleable_to IS NULL OR p.saleable_to > "+unixNow+"))")
+	}
+	if r.visibilityConfig.OutOfStock == mode {
+		conditions = append(conditions, "p.in_stock = 1")
+	}
+	return "(" + strings.Join(conditions, " AND ") + ")"
+}
+
+// spellingDictionary returns the indexed terms that can be suggested for the terms, only terms with the same typo prefix are read
+func (r *SQLiteRepository) spellingDictionary(ctx context.Context, db *sql.DB, terms []string) ([]dictionaryTerm, error) {
+	added := make(map[string]bool)
+	var dictionary []dictionaryTerm
+	for _, term := range terms {
+		prefix := typoPrefix(term, r.queryConfig.TypoPrefixLength)
+		rows, err := db.QueryContext(ctx, "SELECT term, doc FROM products_fts_terms WHERE term >= ? AND term < ?", prefix, prefix+sqliteMaxRune)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var entry dictionaryTerm
+			err = rows.Scan(&entry.Term, &entry.Count)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if !added[entry.Term] {
+				added[entry.Term] = true
+				dictionary = append(dictionary, entry)
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	// a stable order keeps the spelling suggestions stable for terms with the same frequency
+	sort.Slice(dictionary, func(i, j int) bool {
+		return dictionary[i].Term < dictionary[j].Term
+	})
+	return dictionary, nil
+}
+
+// valuesCondition returns the condition for products with any of the values, the key "category" matches category codes
+func (r *SQLiteRepository) valuesCondition(key string, values []string) (string, []interface{}) {
+	if len(values) == 0 {
+		return "0", nil
+	}
+	if key == sqliteCategoryFilterKey {
+		return "p.marketplace_code IN (SELECT marketplace_code FROM product_categories WHERE category_code IN (" + sqlPlaceholders(len(values)) + "))", stringArgs(values)
+	}
+
+	if dateField, ok := dateSortField(r.sortConfig, key); ok {
+		var rangeConditions []string
+		args := []interface{}{key}
+		for _, value := range values {
+			dateRange, err := parseDateRange(value, dateField.DateLayout)
+			if err != nil {
+				r.logger.Warn(err)
+				continue
+			}
+			bounds := []string{"1"}
+			if !dateRange.From.IsZero() {
+				bounds = append(bounds, "number_value >= ?")
+				args = append(args, float64(dateRange.From.Unix()))
+			}
+			if !dateRange.To.IsZero() {
+				bounds = append(bounds, "number_value < ?")
+				args = append(args, float64(dateRange.To.Unix()))
+			}
+			rangeConditions = append(rangeConditions, "("+strings.Join(bounds, " AND ")+")")
+		}
+		if len(rangeConditions) == 0 {
+			return "0", nil
+		}
+		return "p.marketplace_code IN (SELECT marketplace_code FROM product_sort_values WHERE code = ? AND (" + strings.Join(rangeConditions, " OR ") + "))", args
+	}
+
+	return "p.marketplace_code IN (SELECT marketplace_code FROM product_attributes WHERE code = ? AND value IN (" + sqlPlaceholders(len(values)) + "))", append([]interface{}{key}, stringArgs(values)...)
+}
+
+// orderBy returns the ORDER BY expressions and joins the sort values: visible products first (for "last" visibility rules),
+// then the sort fields with missing values last
+func (r *SQLiteRepository) orderBy(productQuery *sqliteProductQuery, sortFields []sortField, now time.Time) string {
+	var orderBy []string
+	if r.visibilityConfig.hasRule(visibilityLast) {
+		orderBy = append(orderBy, r.visibilityCondition(visibilityLast, now)+" DESC")
+	}
+	for i, field := range sortFields {
+		direction := "ASC"
+		if field.Desc {
+			direction = "DESC"
+		}
+		switch field.AttributeCode {
+		case sortFieldScore:
+			if productQuery.hasQuery {
+				// bm25 scores are negative, the best match has the lowest score, so ascending is the natural order of the relevance
+				orderBy = append(orderBy, "fts.score "+direction)
+			}
+			continue
+		case "title", "relevance":
+			orderBy = append(orderBy, "p.title "+direction)
+			continue
+		}
+
+		alias := "s" + strconv.Itoa(i)
+		productQuery.join("LEFT JOIN product_sort_values "+alias+" ON "+alias+".marketplace_code = p.marketplace_code AND "+alias+".code = ?", field.AttributeCode)
+		column := alias + ".number_value"
+		if field.AttributeCode != priceAttributeCode && (field.AttributeType == "" || field.AttributeType == attributeTypeText) {
+			column = alias + ".text_value"
+		}
+		orderBy = append(orderBy, column+" IS NULL", column+" "+direction)
+	}
+	// a stable order for pagination
+	orderBy = append(orderBy, "p.marketplace_code ASC")
+	return strings.Join(orderBy, ", ")
+}
+
+// facets returns the category tree facet and the configured list facets of the matching products
+func (r *SQLiteRepository) facets(ctx context.Context, db *sql.DB, productQuery *sqliteProductQuery) (searchDomain.FacetCollection, error) {
+	matchingStatement, matchingArgs := productQuery.statement("SELECT p.marketplace_code", "")
+
+	facetCollection := make(searchDomain.FacetCollection)
+	if r.enableCategoryFacet {
+		rootCategory, err := r.categoryTree(ctx, db)
+		if err != nil {
+			return nil, err
+		}
+		if rootCategory != nil {
+			rows, err := db.QueryContext(ctx, "SELECT category_code, marketplace_code FROM product_categories WHERE marketplace_code IN ("+matchingStatement+")", matchingArgs...)
+			if err != nil {
+				return nil, err
+			}
+			productsByCategory := make(map[string]sortedCodes)
+			matchingCodes := make(map[string]struct{})
+			for rows.Next() {
+				var categoryCode, marketPlaceCode string
+				err = rows.Scan(&categoryCode, &marketPlaceCode)
+				if err != nil {
+					rows.Close()
+					return nil, err
+				}
+				productsByCategory[categoryCode] = productsByCategory[categoryCode].add(marketPlaceCode)
+				matchingCodes[marketPlaceCode] = struct{}{}
+			}
+			rows.Close()
+			if err = rows.Err(); err != nil {
+				return nil, err
+			}
+			items, _ := categoryTreeFacetItems(rootCategory.SubTreesData, productsByCategory, matchingCodes)
+			facetCollection["category"] = searchDomain.Facet{
+				Type:     searchDomain.TreeFacet,
+				Name:     "category",
+				Label:    "category",
+				Items:    items,
+				Position: 0,
+			}
+		}
+	}
+
+	for _, facetConfig := range r.facetConfig {
+		statement := "SELECT value, MAX(label), COUNT(*) AS count FROM product_attributes WHERE code = ? AND marketplace_code IN (" + matchingStatement + ") GROUP BY value ORDER BY count DESC, value ASC"
+		args := append([]interface{}{facetConfig.AttributeCode}, matchingArgs...)
+		if facetConfig.Amount > 0 {
+			// like the term facets of bleve: the most frequent values up to the configured amount
+			statement += " LIMIT ?"
+			args = append(args, facetConfig.Amount)
+		}
+		rows, err := db.QueryContext(ctx, statement, args...)
+		if err != nil {
+			return nil, err
+		}
+		facet := searchDomain.Facet{
+			Type:     searchDomain.ListFacet,
+			Name:     facetConfig.AttributeCode,
+			Label:    facetConfig.AttributeCode,
+			Position: 0,
+		}
+		for rows.Next() {
+			item := &searchDomain.FacetItem{}
+			err = rows.Scan(&item.Value, &item.Label, &item.Count)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			facet.Items = append(facet.Items, item)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+		facetCollection[facetConfig.AttributeCode] = facet
+	}
+	return facetCollection, nil
+}
+
+// Suggest returns products, categories and query completions matching the given prefix (search-as-you-type)
+func (r *SQLiteRepository) Suggest(ctx context.Context, prefix string, limit int) (*domain.SuggestResult, error) {
+	terms := queryTerms(prefix)
+	for i, term := range terms {
+		terms[i] = truncateSuggestTerm(term)
+	}
+	if len(terms) == 0 {
+		return &domain.SuggestResult{}, nil
+	}
+	db, err := r.getDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT p.data FROM products p JOIN products_fts ON products_fts.rowid = p.id WHERE products_fts MATCH ? ORDER BY p.title LIMIT ?",
+		fullTextMatchExpression(terms, queryOperatorAnd, true), limit)
+	if err != nil {
+		return nil, err
+	}
+	var products []productDomain.BasicProduct
+	for rows.Next() {
+		var data []byte
+		err = rows.Scan(&data)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		product, err := decodeGobProduct(data)
+		if err != nil {
+			r.logger.Error(err)
+			continue
+		}
+		products = append(products, product)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var categories []categoryDomain.Category
+	rootCategory, err := r.categoryTree(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	var addMatchingCategories func(tree *categoryDomain.TreeData)
+	addMatchingCategories = func(tree *categoryDomain.TreeData) {
+		if categoryMatchesSuggestTerms(tree.CategoryName, terms) {
+			categories = append(categories, &categoryDomain.CategoryData{
+				CategoryCode: tree.CategoryCode,
+				CategoryName: tree.CategoryName,
+				CategoryPath: tree.CategoryPath,
+			})
+		}
+		for _, subTree := range tree.SubTreesData {
+			addMatchingCategories(subTree)
+		}
+	}
+	if rootCategory != nil {
+		addMatchingCategories(rootCategory)
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		return categories[i].Name() < categories[j].Name()
+	})
+	if len(categories) > limit {
+		categories = categories[:limit]
+	}
+
+	lastTerm := terms[len(terms)-1]
+	rows, err = db.QueryContext(ctx, "SELECT term, doc FROM products_fts_vocab WHERE col = 'title' AND term >= ? AND term < ?", lastTerm, lastTerm+sqliteMaxRune)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []dictionaryTerm
+	for rows.Next() {
+		var candidate dictionaryTerm
+		err = rows.Scan(&candidate.Term, &candidate.Count)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		candidates = append(candidates, candidate)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &domain.SuggestResult{
+		Products:    products,
+		Categories:  categories,
+		Completions: suggestCompletions(prefix, terms, candidates, limit),
+	}, nil
+}
+
+// CategoryTree returns tree - empty code returns RootNode
+func (r *SQLiteRepository) CategoryTree(ctx context.Context, code string) (categoryDomain.Tree, error) {
+	db, err := r.getDB()
+	if err != nil {
+		return nil, err
+	}
+	rootCategory, err := r.categoryTree(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	if rootCategory == nil {
+		return nil, errors.New("category " + code + " not found. No tree indexed")
+	}
+	if code == "" {
+		return rootCategory, nil
+	}
+	if tree := findCategoryTree(rootCategory, code); tree != nil {
+		return tree, nil
+	}
+	return nil, categoryDomain.ErrNotFound
+}
+
+// Category returns category - empty code returns root cat
+func (r *SQLiteRepository) Category(ctx context.Context, code string) (categoryDomain.Category, error) {
+	if code == "" {
+		tree, err := r.CategoryTree(ctx, code)
+		if err != nil {
+			return nil, err
+		}
+		return &categoryDomain.CategoryData{
+			CategoryCode: tree.Code(),
+			CategoryName: tree.Name(),
+			CategoryPath: tree.Path(),
+		}, nil
+	}
+
+	db, err := r.getDB()
+	if err != nil {
+		return nil, err
+	}
+	category := &categoryDomain.CategoryData{}
+	err = db.QueryRowContext(ctx, "SELECT code, name, path FROM categories WHERE code = ?", code).Scan(&category.CategoryCode, &category.CategoryName, &category.CategoryPath)
+	if err == sql.ErrNoRows {
+		return nil, categoryDomain.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return category, nil
+}
+
+// categoryTree builds the category tree, like the in-memory repository only the first root category is kept
+func (r *SQLiteRepository) categoryTree(ctx context.Context, db *sql.DB) (*categoryDomain.TreeData, error) {
+	rows, err := db.QueryContext(ctx, "SELECT code, name, path, parent_code FROM categories ORDER BY rowid")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	trees := make(map[string]*categoryDomain.TreeData)
+	parentCodes := make(map[string]string)
+	var codes []string
+	for rows.Next() {
+		var parentCode string
+		tree := &categoryDomain.TreeData{IsActive: true}
+		err = rows.Scan(&tree.CategoryCode, &tree.CategoryName, &tree.CategoryPath, &parentCode)
+		if err != nil {
+			return nil, err
+		}
+		trees[tree.CategoryCode] = tree
+		parentCodes[tree.CategoryCode] = parentCode
+		codes = append(codes, tree.CategoryCode)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var rootCategory *categoryDomain.TreeData
+	for _, code := range codes {
+		parent, ok := trees[parentCodes[code]]
+		if !ok {
+			if rootCategory == nil {
+				rootCategory = trees[code]
+			}
+			continue
+		}
+		parent.SubTreesData = append(parent.SubTreesData, trees[code])
+	}
+	return rootCategory, nil
+}
+
+// findCategoryTree returns the sub tree with the code
+func findCategoryTree(tree *categoryDomain.TreeData, code string) *categoryDomain.TreeData {
+	if tree.CategoryCode == code {
+		return tree
+	}
+	for _, subTree := range tree.SubTreesData {
+		if found := findCategoryTree(subTree, code); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// join adds a join with its arguments
+func (q *sqliteProductQuery) join(join string, args ...interface{}) {
+	q.joins = append(q.joins, join)
+	q.joinArgs = append(q.joinArgs, args...)
+}
+
+// clone returns a copy of the query that can be extended without changing the query
+func (q *sqliteProductQuery) clone() *sqliteProductQuery {
+	return &sqliteProductQuery{
+		joins:      append([]string(nil), q.joins...),
+		joinArgs:   append([]interface{}(nil), q.joinArgs...),
+		conditions: append([]string(nil), q.conditions...),
+		args:       append([]interface{}(nil), q.args...),
+		hasQuery:   q.hasQuery,
+	}
+}
+
+// where adds a condition with its arguments
+func (q *sqliteProductQuery) where(condition string, args ...interface{}) {
+	q.conditions = append(q.conditions, condition)
+	q.args = append(q.args, args...)
+}
+
+// statement returns the statement with the selected columns and the suffix (e.g. ORDER BY) together with its arguments
+func (q *sqliteProductQuery) statement(selectColumns string, suffix string) (string, []interface{}) {
+	statement := selectColumns + " FROM products p"
+	if len(q.joins) > 0 {
+		statement += " " + strings.Join(q.joins, " ")
+	}
+	if len(q.conditions) > 0 {
+		statement += " WHERE " + strings.Join(q.conditions, " AND ")
+	}
+	if suffix != "" {
+		statement += " " + suffix
+	}
+	args := make([]interface{}, 0, len(q.joinArgs)+len(q.args))
+	return statement, append(append(args, q.joinArgs...), q.args...)
+}
+
+// fullTextMatchExpression returns the FTS5 match expression of the terms, with prefix the terms match the beginning of title terms
+func fullTextMatchExpression(terms []string, operator string, prefix bool) string {
+	phrases := make([]string, 0, len(terms))
+	for _, term := range terms {
+		// terms are quoted to match them literally, e.g. "and" or "c++"
+		phrase := `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+		if prefix {
+			phrase = "title : " + phrase + " *"
+		}
+		phrases = append(phrases, phrase)
+	}
+	if operator == queryOperatorAnd {
+		return strings.Join(phrases, " AND ")
+	}
+	return strings.Join(phrases, " OR ")
+}
+
+// sqliteSortValue returns the sort value of the product for the field, numbers are used for all types but text
+func sqliteSortValue(product productDomain.BasicProduct, field sortField) (sql.NullFloat64, sql.NullString, bool) {
+	data := product.BaseData()
+	if field.AttributeCode == priceAttributeCode {
+		price, ok := numericValue(data, product.TeaserData().TeaserPrice, priceAttributeCode)
+		return sql.NullFloat64{Float64: price, Valid: ok}, sql.NullString{}, ok
+	}
+	switch field.AttributeType {
+	case attributeTypeDate:
+		date, err := parseDateValue(data.Attribute(field.AttributeCode).Value(), field.DateLayout)
+		if err != nil {
+			return sql.NullFloat64{}, sql.NullString{}, false
+		}
+		return sql.NullFloat64{Float64: float64(date.Unix()), Valid: true}, sql.NullString{}, true
+	case attributeTypeNumeric:
+		value, ok := numericValue(data, productDomain.PriceInfo{}, field.AttributeCode)
+		return sql.NullFloat64{Float64: value, Valid: ok}, sql.NullString{}, ok
+	case attributeTypeBool:
+		value, err := strconv.ParseBool(data.Attribute(field.AttributeCode).Value())
+		if err != nil {
+			return sql.NullFloat64{}, sql.NullString{}, false
+		}
+		if value {
+			return sql.NullFloat64{Float64: 1, Valid: true}, sql.NullString{}, true
+		}
+		return sql.NullFloat64{Float64: 0, Valid: true}, sql.NullString{}, true
+	}
+	value := data.Attribute(field.AttributeCode).Value()
+	return sql.NullFloat64{}, sql.NullString{String: value, Valid: value != ""}, value != ""
+}
+
+// productAttributeCodes returns the attribute codes of the product and its variants
+func productAttributeCodes(product productDomain.BasicProduct) []string {
+	added := make(map[string]bool)
+	var codes []string
+	addCodes := func(attributes productDomain.Attributes) {
+		for code := range attributes {
+			if !added[code] {
+				added[code] = true
+				codes = append(codes, code)
+			}
+		}
+	}
+	addCodes(product.BaseData().Attributes)
+	for _, variant := range productVariants(product) {
+		addCodes(variant.Attributes)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// sqliteUnixTime returns the time in unix seconds, NULL for the zero time
+func sqliteUnixTime(t time.Time) sql.NullInt64 {
+	return sql.NullInt64{Int64: t.Unix(), Valid: !t.IsZero()}
+}
+
+// sqlPlaceholders returns n comma separated placeholders
+func sqlPlaceholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func stringArgs(values []string) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+	return args
+}
@@ -0,0 +1,273 @@
+package commercesearch
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	categoryDomain "flamingo.me/flamingo-commerce/v3/category/domain"
+	"flamingo.me/flamingo-commerce/v3/product/domain"
+	searchDomain "flamingo.me/flamingo-commerce/v3/search/domain"
+	"flamingo.me/flamingo/v3/framework/config"
+	"flamingo.me/flamingo/v3/framework/flamingo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	commercesearchDomain "flamingo.me/flamingo-commerce-adapter-standalone/commercesearch/domain"
+	"flamingo.me/flamingo-commerce-adapter-standalone/commercesearch/domain/repositorytest"
+)
+
+type sqliteRepositoryConfig = struct {
+	DriverName                       string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.sqliteAdapter.driverName,optional"`
+	DataSourceName                   string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.sqliteAdapter.dataSourceName,optional"`
+	AssignProductsToParentCategories bool         `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.sqliteAdapter.productsToParentCategories,optional"`
+	EnableCategoryFacet              bool         `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.sqliteAdapter.enableCategoryFacet,optional"`
+	FacetConfig                      config.Slice `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.sqliteAdapter.facetConfig,optional"`
+	SortConfig                       config.Slice `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.sqliteAdapter.sortConfig,optional"`
+	QueryAttributes                  config.Slice `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.sqliteAdapter.query.attributes,optional"`
+	QueryOperator                    string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.sqliteAdapter.query.operator,optional"`
+	QuerySuggestionThreshold         float64      `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.sqliteAdapter.query.suggestionThreshold,optional"`
+	VisibilityNonSaleable            string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.visibility.nonSaleable,optional"`
+	VisibilityOutOfStock             string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.visibility.outOfStock,optional"`
+	CollapseVariants                 string       `inject:"config:flamingoCommerceAdapterStandalone.commercesearch.collapseVariants,optional"`
+}
+
+// newTestSQLiteRepository returns a repository with the config, on a new database file if no data source is configured
+func newTestSQLiteRepository(t *testing.T, repositoryConfig sqliteRepositoryConfig) *SQLiteRepository {
+	t.Helper()
+	if repositoryConfig.DataSourceName == "" {
+		repositoryConfig.DataSourceName = "file:" + filepath.Join(t.TempDir(), "commercesearch.db")
+	}
+	s := new(SQLiteRepository).Inject(flamingo.NullLogger{}, &repositoryConfig)
+	t.Cleanup(func() {
+		assert.NoError(t, s.Close())
+	})
+	return s
+}
+
+func TestSQLiteRepository_Conformance(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T, features repositorytest.Capabilities) (commercesearchDomain.ProductRepository, commercesearchDomain.CategoryRepository) {
+		s := newTestSQLiteRepository(t, sqliteRepositoryConfig{
+			AssignProductsToParentCategories: true,
+			EnableCategoryFacet:              true,
+			FacetConfig:                      conformanceFacetConfig(features),
+			SortConfig:                       conformanceSortConfig,
+			VisibilityNonSaleable:            conformanceVisibility(features),
+			CollapseVariants:                 conformanceCollapseVariants(features),
+		})
+		return s, s
+	}, repositorytest.Capabilities{
+		ParentCategories: true,
+		Visibility:       true,
+		CollapseVariants: true,
+	})
+}
+
+func TestSQLiteRepository_Persistence(t *testing.T) {
+	repositoryConfig := sqliteRepositoryConfig{
+		DataSourceName:  "file:" + filepath.Join(t.TempDir(), "commercesearch.db"),
+		QueryAttributes: config.Slice{"color"},
+	}
+	indexed := newTestSQLiteRepository(t, repositoryConfig)
+
+	shirt := domain.SimpleProduct{
+		Identifier: "shirt",
+		BasicProductData: domain.BasicProductData{
+			MarketPlaceCode: "shirt",
+			Title:           "Running Shirt",
+			Categories:      []domain.CategoryTeaser{{Code: "shirts", Name: "Shirts", Parent: &domain.CategoryTeaser{Code: "root"}}},
+			Attributes:      domain.Attributes{"color": domain.Attribute{Code: "color", Label: "Red", RawValue: "red"}},
+		},
+	}
+	require.NoError(t, indexed.UpdateProducts(context.Background(), []domain.BasicProduct{shirt}))
+	require.NoError(t, indexed.UpdateByCategoryTeasers(context.Background(), shirt.Categories))
+	require.NoError(t, indexed.Close())
+
+	s := newTestSQLiteRepository(t, repositoryConfig)
+	assert.Equal(t, int64(1), s.DocumentsCount(), "the products are kept in the database")
+
+	product, err := s.FindByMarketplaceCode(context.Background(), "shirt")
+	require.NoError(t, err)
+	assert.Equal(t, shirt, product)
+
+	result, err := s.Find(context.Background(), searchDomain.NewQueryFilter("red"))
+	require.NoError(t, err)
+	require.Len(t, result.Hits, 1, "the query attributes are searchable")
+
+	suggestions, err := s.Suggest(context.Background(), "run", 5)
+	require.NoError(t, err)
+	assert.Len(t, suggestions.Products, 1)
+	assert.Contains(t, suggestions.Completions, "running")
+
+	category, err := s.Category(context.Background(), "shirts")
+	require.NoError(t, err)
+	assert.Equal(t, "Shirts", category.Name())
+}
+
+func TestSQLiteRepository_Visibility(t *testing.T) {
+	s := newTestSQLiteRepository(t, sqliteRepositoryConfig{
+		SortConfig:            config.Slice{config.Map{"attributeCode": "name", "asc": true}},
+		VisibilityNonSaleable: "hide",
+		VisibilityOutOfStock:  "last",
+	})
+
+	now := time.Now()
+	newProduct := func(title string, saleable domain.Saleable, stockLevel string) domain.SimpleProduct {
+		return domain.SimpleProduct{
+			Identifier: title,
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: title,
+				Title:           title,
+				StockLevel:      stockLevel,
+				Attributes: domain.Attributes{
+					"name": domain.Attribute{Code: "name", RawValue: title},
+				},
+			},
+			Saleable: saleable,
+		}
+	}
+	require.NoError(t, s.UpdateProducts(context.Background(), []domain.BasicProduct{
+		newProduct("a", domain.Saleable{IsSaleable: true}, domain.StockLevelInStock),
+		newProduct("b", domain.Saleable{IsSaleable: true}, domain.StockLevelOutOfStock),
+		newProduct("c", domain.Saleable{IsSaleable: true, SaleableFrom: now.Add(-time.Hour), SaleableTo: now.Add(time.Hour)}, ""),
+		newProduct("d", domain.Saleable{IsSaleable: false}, domain.StockLevelInStock),
+		newProduct("e", domain.Saleable{IsSaleable: true, SaleableFrom: now.Add(time.Hour)}, domain.StockLevelInStock),
+		newProduct("f", domain.Saleable{IsSaleable: true, SaleableTo: now.Add(time.Hour)}, domain.StockLevelOutOfStock),
+	}))
+
+	result, err := s.Find(context.Background(), searchDomain.NewSortFilter("name", "A"), searchDomain.NewPaginationPageSizeFilter(3))
+	require.NoError(t, err)
+	assert.Equal(t, 4, result.SearchMeta.NumResults, "products that are not saleable now are hidden")
+	assert.Equal(t, []string{"a", "c", "b"}, sqliteHitCodes(result), "out of stock products are listed last")
+
+	result, err = s.Find(context.Background(), searchDomain.NewSortFilter("name", "A"), searchDomain.NewPaginationPageSizeFilter(3), searchDomain.NewPaginationPageFilter(2))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"f"}, sqliteHitCodes(result))
+}
+
+func TestSQLiteRepository_Variants(t *testing.T) {
+	newProduct := func(marketPlaceCode string, name string, attributes domain.Attributes) domain.SimpleProduct {
+		attributes["name"] = domain.Attribute{Code: "name", RawValue: name}
+		return domain.SimpleProduct{
+			Identifier: marketPlaceCode,
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: marketPlaceCode,
+				Title:           marketPlaceCode,
+				Attributes:      attributes,
+			},
+		}
+	}
+	newVariant := func(marketPlaceCode string, color string) domain.Variant {
+		return domain.Variant{
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: marketPlaceCode,
+				Title:           marketPlaceCode,
+				Attributes:      domain.Attributes{"color": domain.Attribute{Code: "color", Label: color, RawValue: color}},
+			},
+		}
+	}
+	products := []domain.BasicProduct{
+		domain.ConfigurableProduct{
+			Identifier: "shirt",
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: "shirt",
+				Title:           "shirt",
+				Attributes:      domain.Attributes{"name": domain.Attribute{Code: "name", RawValue: "c"}},
+			},
+			Variants: []domain.Variant{newVariant("shirt-red", "red"), newVariant("shirt-blue", "blue")},
+		},
+		newProduct("shirt-red", "a", domain.Attributes{}),
+		newProduct("shirt-blue", "b", domain.Attributes{"size": domain.Attribute{Code: "size", RawValue: "xl"}}),
+		newProduct("socks", "d", domain.Attributes{}),
+	}
+	newRepository := func(collapseVariants string) *SQLiteRepository {
+		s := newTestSQLiteRepository(t, sqliteRepositoryConfig{
+			SortConfig:       config.Slice{config.Map{"attributeCode": "name", "asc": true}},
+			CollapseVariants: collapseVariants,
+		})
+		require.NoError(t, s.UpdateProducts(context.Background(), products))
+		return s
+	}
+
+	s := newRepository("none")
+	matchedVariantsFilter := commercesearchDomain.NewMatchedVariantsFilter()
+	result, err := s.Find(context.Background(), searchDomain.NewKeyValueFilter("color", []string{"blue"}), matchedVariantsFilter)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"shirt"}, sqliteHitCodes(result))
+	assert.Equal(t, []string{"shirt-blue"}, matchedVariantsFilter.MatchedVariants().Variants("shirt"))
+
+	s = newRepository("variant")
+	result, err = s.Find(context.Background(), searchDomain.NewSortFilter("name", "A"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.SearchMeta.NumResults, "the configurable product and its variants count once")
+	assert.Equal(t, []string{"shirt-red", "socks"}, sqliteHitCodes(result), "the best matching variant is returned")
+
+	result, err = s.Find(context.Background(), searchDomain.NewSortFilter("name", "A"), searchDomain.NewPaginationPageSizeFilter(1), searchDomain.NewPaginationPageFilter(2))
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.SearchMeta.NumPages)
+	assert.Equal(t, []string{"socks"}, sqliteHitCodes(result))
+
+	s = newRepository("parent")
+	result, err = s.Find(context.Background(), searchDomain.NewSortFilter("name", "A"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"shirt", "socks"}, sqliteHitCodes(result), "the configurable product replaces its variants")
+
+	result, err = s.Find(context.Background(), searchDomain.NewKeyValueFilter("size", []string{"xl"}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"shirt"}, sqliteHitCodes(result), "the configurable product is returned if only a variant matches")
+}
+
+func TestSQLiteRepository_SpellingAndCategories(t *testing.T) {
+	s := newTestSQLiteRepository(t, sqliteRepositoryConfig{
+		AssignProductsToParentCategories: true,
+		QuerySuggestionThreshold:         0,
+	})
+	require.NoError(t, s.UpdateProducts(context.Background(), []domain.BasicProduct{
+		domain.SimpleProduct{
+			Identifier: "boots",
+			BasicProductData: domain.BasicProductData{
+				MarketPlaceCode: "boots",
+				Title:           "Hiking Boots",
+				Categories:      []domain.CategoryTeaser{{Code: "shoes", Parent: &domain.CategoryTeaser{Code: "clothing"}}},
+			},
+		},
+	}))
+
+	result, err := s.Find(context.Background(), searchDomain.NewQueryFilter("hikimg"))
+	require.NoError(t, err)
+	assert.Empty(t, result.Hits)
+	require.Len(t, result.Suggestion, 1)
+	assert.Equal(t, "hiking", result.Suggestion[0].Text)
+
+	categoryFacet := categoryDomain.NewCategoryFacet("clothing")
+	result, err = s.Find(context.Background(), &categoryFacet)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"boots"}, sqliteHitCodes(result), "products are assigned to the parent categories")
+}
+
+func TestSQLiteRepository_UnsupportedFacets(t *testing.T) {
+	s := newTestSQLiteRepository(t, sqliteRepositoryConfig{
+		FacetConfig: config.Slice{
+			config.Map{"attributeCode": "price", "type": "range"},
+			config.Map{"attributeCode": "color", "amount": 10.0, "multiSelect": true},
+		},
+	})
+	require.Len(t, s.facetConfig, 1, "range facets are skipped")
+	assert.Equal(t, "color", s.facetConfig[0].AttributeCode)
+	assert.False(t, s.facetConfig[0].MultiSelect, "multi-select facets are counted as list facets")
+}
+
+func TestSQLiteRepository_RequiresDataSourceName(t *testing.T) {
+	assert.Panics(t, func() {
+		new(SQLiteRepository).Inject(flamingo.NullLogger{}, &sqliteRepositoryConfig{})
+	})
+}
+
+func sqliteHitCodes(result *domain.SearchResult) []string {
+	var marketPlaceCodes []string
+	for _, hit := range result.Hits {
+		marketPlaceCodes = append(marketPlaceCodes, hit.BaseData().MarketPlaceCode)
+	}
+	return marketPlaceCodes
+}
@@ -0,0 +1,49 @@
+package commercesearch
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// suggestMaxPrefixLength is the longest prefix (in runes) that is indexed for search-as-you-type
+const suggestMaxPrefixLength = 20
+
+// truncateSuggestTerm cuts the term to the longest indexed prefix
+func truncateSuggestTerm(term string) string {
+	runes := []rune(term)
+	if len(runes) > suggestMaxPrefixLength {
+		return string(runes[:suggestMaxPrefixLength])
+	}
+	return term
+}
+
+// suggestCompletions returns query completions for the last term of the prefix
+// candidates are the dictionary terms starting with the last term, the most frequent ones are returned first
+func suggestCompletions(prefix string, terms []string, candidates []dictionaryTerm, limit int) []string {
+	if len(terms) == 0 || strings.IndexFunc(prefix[len(prefix)-1:], unicode.IsSpace) == 0 {
+		// the last term is already completed by the user
+		return nil
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Count != candidates[j].Count {
+			return candidates[i].Count > candidates[j].Count
+		}
+		return candidates[i].Term < candidates[j].Term
+	})
+
+	leadingTerms := strings.Join(terms[:len(terms)-1], " ")
+	var completions []string
+	for _, candidate := range candidates {
+		if len(completions) >= limit {
+			break
+		}
+		if leadingTerms == "" {
+			completions = append(completions, candidate.Term)
+			continue
+		}
+		completions = append(completions, leadingTerms+" "+candidate.Term)
+	}
+	return completions
+}
@@ -0,0 +1,225 @@
+package commercesearch
+
+import (
+	"strconv"
+	"sync"
+
+	productDomain "flamingo.me/flamingo-commerce/v3/product/domain"
+	searchDomain "flamingo.me/flamingo-commerce/v3/search/domain"
+)
+
+type (
+	// variantParentIndex index to get the configurable product of a variant, e.g. the configurable 'shirt' for the variant 'shirt-red'
+	variantParentIndex struct {
+		mutex sync.RWMutex
+		// parents marketplace code of the configurable product by variant marketplace code
+		parents map[string]string
+		// variants marketplace codes of the variants by configurable marketplace code, used to remove stale entries
+		variants map[string][]string
+	}
+)
+
+const (
+	// collapseVariantsNone lists variants and configurable products side by side
+	collapseVariantsNone = "none"
+	// collapseVariantsParent replaces the hits of a configurable product and its variants with the configurable product
+	collapseVariantsParent = "parent"
+	// collapseVariantsVariant keeps the best hit of a configurable product and its variants
+	collapseVariantsVariant = "variant"
+)
+
+// add the variants of a configurable product, the previous variants of the product are replaced
+func (i *variantParentIndex) add(product productDomain.BasicProduct) {
+	variants := productVariants(product)
+	marketPlaceCode := product.BaseData().MarketPlaceCode
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if i.parents == nil {
+		i.parents = make(map[string]string)
+		i.variants = make(map[string][]string)
+	}
+	for _, variantCode := range i.variants[marketPlaceCode] {
+		if i.parents[variantCode] == marketPlaceCode {
+			delete(i.parents, variantCode)
+		}
+	}
+	delete(i.variants, marketPlaceCode)
+	for _, variant := range variants {
+		i.parents[variant.MarketPlaceCode] = marketPlaceCode
+		i.variants[marketPlaceCode] = append(i.variants[marketPlaceCode], variant.MarketPlaceCode)
+	}
+}
+
+// parent returns the marketplace code of the configurable product of the variant
+func (i *variantParentIndex) parent(variantMarketPlaceCode string) (string, bool) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	parent, ok := i.parents[variantMarketPlaceCode]
+	return parent, ok
+}
+
+// collapseKey returns the marketplace code of the configurable product for variants, the marketplace code itself otherwise
+func (i *variantParentIndex) collapseKey(marketPlaceCode string) string {
+	if parent, ok := i.parent(marketPlaceCode); ok {
+		return parent
+	}
+	return marketPlaceCode
+}
+
+// productVariants returns the variants of a configurable product, nil for other products
+func productVariants(product productDomain.BasicProduct) []productDomain.Variant {
+	switch p := product.(type) {
+	case productDomain.ConfigurableProduct:
+		return p.Variants
+	case *productDomain.ConfigurableProduct:
+		return p.Variants
+	}
+	return nil
+}
+
+// configurableWithActiveVariant returns the configurable product with the given variant as active variant
+func configurableWithActiveVariant(product productDomain.BasicProduct, variantMarketPlaceCode string) (productDomain.BasicProduct, error) {
+	var configurable productDomain.ConfigurableProduct
+	switch p := product.(type) {
+	case productDomain.ConfigurableProduct:
+		configurable = p
+	case *productDomain.ConfigurableProduct:
+		configurable = *p
+	default:
+		return nil, productDomain.ProductNotFound{MarketplaceCode: variantMarketPlaceCode}
+	}
+
+	configurableWithActiveVariant, err := configurable.GetConfigurableWithActiveVariant(variantMarketPlaceCode)
+	if err != nil {
+		return nil, err
+	}
+	return configurableWithActiveVariant, nil
+}
+
+// productFacetValues returns the values of the attribute of the product and its variants, each value once
+func productFacetValues(product productDomain.BasicProduct, attributeCode string) []facetValue {
+	var facetValues []facetValue
+	added := make(map[string]bool)
+	addValues := func(data productDomain.BasicProductData) {
+		if !data.HasAttribute(attributeCode) {
+			return
+		}
+		for _, value := range attributeFacetValues(data.Attribute(attributeCode)) {
+			if added[value.Value] {
+				continue
+			}
+			added[value.Value] = true
+			facetValues = append(facetValues, value)
+		}
+	}
+
+	addValues(product.BaseData())
+	for _, variant := range productVariants(product) {
+		addValues(variant.BasicProductData)
+	}
+	return facetValues
+}
+
+// productNumericValues returns the numeric values of the attribute (the final price for "price") of the product and its variants
+func productNumericValues(product productDomain.BasicProduct, attributeCode string) []float64 {
+	var values []float64
+	if value, ok := numericValue(product.BaseData(), product.TeaserData().TeaserPrice, attributeCode); ok {
+		values = append(values, value)
+	}
+	for _, variant := range productVariants(product) {
+		if value, ok := numericValue(variant.BasicProductData, variant.ActivePrice, attributeCode); ok {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// numericValue returns the numeric attribute value or the final price
+func numericValue(data productDomain.BasicProductData, price productDomain.PriceInfo, attributeCode string) (float64, bool) {
+	if attributeCode == priceAttributeCode {
+		finalPrice := price.GetFinalPrice()
+		if finalPrice.IsZero() {
+			return 0, false
+		}
+		return finalPrice.FloatAmount(), true
+	}
+	if !data.HasAttribute(attributeCode) {
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(data.Attribute(attributeCode).Value(), 64)
+	if err != nil {
+		return 0, false
+	}
+	return value, true
+}
+
+// numericBounds returns the lowest and highest value
+func numericBounds(values []float64) (min float64, max float64) {
+	for i, value := range values {
+		if i == 0 || value < min {
+			min = value
+		}
+		if i == 0 || value > max {
+			max = value
+		}
+	}
+	return min, max
+}
+
+// matchedVariants returns the marketplace codes of the variants that match all key value filters,
+// attributes that are not set on the variant are taken from the configurable product
+func matchedVariants(product productDomain.BasicProduct, filters []*searchDomain.KeyValueFilter, matches func(data productDomain.BasicProductData, price productDomain.PriceInfo, key string, values []string) bool) []string {
+	var variantCodes []string
+	for _, variant := range productVariants(product) {
+		matchesAll := true
+		for _, filter := range filters {
+			data := variant.BasicProductData
+			if filter.Key() != priceAttributeCode && !data.HasAttribute(filter.Key()) {
+				data = product.BaseData()
+			}
+			if !matches(data, variant.ActivePrice, filter.Key(), filter.KeyValues()) {
+				matchesAll = false
+				break
+			}
+		}
+		if matchesAll {
+			variantCodes = append(variantCodes, variant.MarketPlaceCode)
+		}
+	}
+	return variantCodes
+}
+
+// sortConfigVariantMatcher returns a matcher for matchedVariants, date attributes of the sort config are matched by date range
+func sortConfigVariantMatcher(sortConfigs []sortConfig) func(data productDomain.BasicProductData, price productDomain.PriceInfo, key string, values []string) bool {
+	return func(data productDomain.BasicProductData, _ productDomain.PriceInfo, key string, values []string) bool {
+		if dateField, ok := dateSortField(sortConfigs, key); ok {
+			date, err := parseDateValue(data.Attribute(key).Value(), dateField.DateLayout)
+			if err != nil {
+				return false
+			}
+			for _, value := range values {
+				if dateRange, err := parseDateRange(value, dateField.DateLayout); err == nil && dateRange.contains(date) {
+					return true
+				}
+			}
+			return false
+		}
+		return hasFacetValue(data, key, values)
+	}
+}
+
+// hasFacetValue checks if the attribute has any of the values
+func hasFacetValue(data productDomain.BasicProductData, attributeCode string, values []string) bool {
+	if !data.HasAttribute(attributeCode) {
+		return false
+	}
+	for _, facetValue := range attributeFacetValues(data.Attribute(attributeCode)) {
+		for _, value := range values {
+			if facetValue.Value == value {
+				return true
+			}
+		}
+	}
+	return false
+}
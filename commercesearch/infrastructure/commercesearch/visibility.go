@@ -0,0 +1,110 @@
+package commercesearch
+
+import (
+	"time"
+
+	productDomain "flamingo.me/flamingo-commerce/v3/product/domain"
+)
+
+type (
+	// visibilityConfig defines how Find treats products that are not saleable at the time of the request or out of stock
+	visibilityConfig struct {
+		NonSaleable string
+		OutOfStock  string
+	}
+
+	// productAvailability is the saleability and stock of a product, configurable products are available if any variant
+	// is and the configurable product itself is
+	productAvailability struct {
+		Saleable     bool
+		SaleableFrom time.Time
+		SaleableTo   time.Time
+		InStock      bool
+	}
+)
+
+const (
+	// visibilityShow lists the products like all others
+	visibilityShow = "show"
+	// visibilityHide removes the products from the result
+	visibilityHide = "hide"
+	// visibilityLast lists the products after all other products
+	visibilityLast = "last"
+)
+
+// availabilityOf returns the availability of the product
+func availabilityOf(product productDomain.BasicProduct) productAvailability {
+	variants := productVariants(product)
+	if variants == nil {
+		return productAvailability{
+			Saleable:     product.SaleableData().IsSaleable,
+			SaleableFrom: product.SaleableData().SaleableFrom,
+			SaleableTo:   product.SaleableData().SaleableTo,
+			InStock:      product.BaseData().StockLevel != productDomain.StockLevelOutOfStock,
+		}
+	}
+
+	// the configurable product is saleable during the combined saleable windows of its variants
+	var availability productAvailability
+	for _, variant := range variants {
+		if variant.StockLevel != productDomain.StockLevelOutOfStock {
+			availability.InStock = true
+		}
+		if !variant.IsSaleable {
+			continue
+		}
+		if !availability.Saleable || (!availability.SaleableFrom.IsZero() && (variant.SaleableFrom.IsZero() || variant.SaleableFrom.Before(availability.SaleableFrom))) {
+			availability.SaleableFrom = variant.SaleableFrom
+		}
+		if !availability.Saleable || (!availability.SaleableTo.IsZero() && (variant.SaleableTo.IsZero() || variant.SaleableTo.After(availability.SaleableTo))) {
+			availability.SaleableTo = variant.SaleableTo
+		}
+		availability.Saleable = true
+	}
+	return availability.boundedBy(product.SaleableData())
+}
+
+// boundedBy restricts the availability to the saleable flag and window of the product itself, e.g. of a configurable
+// product that is not saleable although its variants are. Empty saleable data (like the one of the flamingo-commerce
+// ConfigurableProduct) does not restrict the availability
+func (a productAvailability) boundedBy(saleable productDomain.Saleable) productAvailability {
+	if !saleable.IsSaleable && saleable.SaleableFrom.IsZero() && saleable.SaleableTo.IsZero() {
+		return a
+	}
+	if !saleable.IsSaleable {
+		a.Saleable = false
+	}
+	if !saleable.SaleableFrom.IsZero() && (a.SaleableFrom.IsZero() || saleable.SaleableFrom.After(a.SaleableFrom)) {
+		a.SaleableFrom = saleable.SaleableFrom
+	}
+	if !saleable.SaleableTo.IsZero() && (a.SaleableTo.IsZero() || saleable.SaleableTo.Before(a.SaleableTo)) {
+		a.SaleableTo = saleable.SaleableTo
+	}
+	if !a.SaleableFrom.IsZero() && !a.SaleableTo.IsZero() && !a.SaleableFrom.Before(a.SaleableTo) {
+		a.Saleable = false
+	}
+	return a
+}
+
+// saleableAt checks if the product is saleable at the given time
+func (a productAvailability) saleableAt(now time.Time) bool {
+	return a.Saleable &&
+		(a.SaleableFrom.IsZero() || a.SaleableFrom.Before(now)) &&
+		(a.SaleableTo.IsZero() || a.SaleableTo.After(now))
+}
+
+// matches checks if the product passes all rules with the given mode
+func (v visibilityConfig) matches(mode string, availability productAvailability, now time.Time) bool {
+	if v.NonSaleable == mode && !availability.saleableAt(now) {
+		return false
+	}
+	if v.OutOfStock == mode && !availability.InStock {
+		return false
+	}
+	return true
+}
+
+// hasRule checks if any rule uses the given mode
+func (v visibilityConfig) hasRule(mode string) bool {
+	return v.NonSaleable == mode || v.OutOfStock == mode
+}
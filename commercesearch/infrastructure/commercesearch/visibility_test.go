@@ -0,0 +1,49 @@
+package commercesearch
+
+import (
+	"testing"
+	"time"
+
+	"flamingo.me/flamingo-commerce/v3/product/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAvailabilityOf(t *testing.T) {
+	now := time.Now()
+	newVariant := func(saleable domain.Saleable, stockLevel string) domain.Variant {
+		return domain.Variant{BasicProductData: domain.BasicProductData{StockLevel: stockLevel}, Saleable: saleable}
+	}
+
+	availability := availabilityOf(domain.ConfigurableProduct{
+		Variants: []domain.Variant{
+			newVariant(domain.Saleable{IsSaleable: true, SaleableFrom: now.Add(time.Hour), SaleableTo: now.Add(2 * time.Hour)}, domain.StockLevelOutOfStock),
+			newVariant(domain.Saleable{IsSaleable: true, SaleableFrom: now.Add(-time.Hour), SaleableTo: now.Add(time.Hour)}, domain.StockLevelInStock),
+			newVariant(domain.Saleable{IsSaleable: false}, domain.StockLevelInStock),
+		},
+	})
+	assert.True(t, availability.saleableAt(now), "the configurable is saleable if a variant is")
+	assert.True(t, availability.saleableAt(now.Add(90*time.Minute)), "the saleable windows of the variants are combined")
+	assert.False(t, availability.saleableAt(now.Add(3*time.Hour)))
+	assert.True(t, availability.InStock)
+
+	availability = availabilityOf(domain.ConfigurableProduct{
+		Variants: []domain.Variant{
+			newVariant(domain.Saleable{IsSaleable: true}, domain.StockLevelOutOfStock),
+		},
+	})
+	assert.True(t, availability.saleableAt(now), "open saleable window")
+	assert.False(t, availability.InStock)
+
+	variantAvailability := productAvailability{Saleable: true, SaleableFrom: now.Add(-2 * time.Hour), SaleableTo: now.Add(2 * time.Hour), InStock: true}
+	assert.Equal(t, variantAvailability, variantAvailability.boundedBy(domain.Saleable{}), "empty saleable data does not restrict")
+	assert.False(t, variantAvailability.boundedBy(domain.Saleable{IsSaleable: false, SaleableTo: now.Add(time.Hour)}).saleableAt(now), "the configurable itself is not saleable")
+	bounded := variantAvailability.boundedBy(domain.Saleable{IsSaleable: true, SaleableFrom: now.Add(-3 * time.Hour), SaleableTo: now.Add(time.Hour)})
+	assert.True(t, bounded.saleableAt(now))
+	assert.False(t, bounded.saleableAt(now.Add(90*time.Minute)), "the window of the configurable bounds the windows of the variants")
+	assert.True(t, bounded.SaleableFrom.Equal(now.Add(-2*time.Hour)))
+	assert.False(t, variantAvailability.boundedBy(domain.Saleable{IsSaleable: true, SaleableFrom: now.Add(3 * time.Hour)}).Saleable, "windows without overlap")
+
+	config := visibilityConfig{NonSaleable: visibilityHide, OutOfStock: visibilityLast}
+	assert.True(t, config.matches(visibilityHide, availability, now))
+	assert.False(t, config.matches(visibilityLast, availability, now))
+}
@@ -20,7 +20,7 @@ func (ps *ServiceAdapter) Inject(productRepository domain.ProductRepository) {
 	ps.productRepository = productRepository
 }
 
-// Get returns a product struct
+// Get returns a product struct, for variant marketplace codes the configurable product with the active variant
 func (ps *ServiceAdapter) Get(ctx context.Context, marketplaceCode string) (productDomain.BasicProduct, error) {
 	return ps.productRepository.FindByMarketplaceCode(ctx, marketplaceCode)
 }
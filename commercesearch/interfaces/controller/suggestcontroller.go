@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"context"
+	"strconv"
+
+	"flamingo.me/flamingo/v3/framework/flamingo"
+	"flamingo.me/flamingo/v3/framework/web"
+
+	"flamingo.me/flamingo-commerce-adapter-standalone/commercesearch/domain"
+)
+
+type (
+	// SuggestController serves search-as-you-type suggestions as JSON
+	SuggestController struct {
+		responder      *web.Responder
+		logger         flamingo.Logger
+		suggestService *domain.SuggestService
+	}
+
+	// suggestResponse is the JSON representation of the suggestions
+	suggestResponse struct {
+		Products    []suggestProduct  `json:"products"`
+		Categories  []suggestCategory `json:"categories"`
+		Completions []string          `json:"completions"`
+	}
+
+	suggestProduct struct {
+		MarketPlaceCode string `json:"marketPlaceCode"`
+		Title           string `json:"title"`
+		Type            string `json:"type"`
+	}
+
+	suggestCategory struct {
+		Code string `json:"code"`
+		Name string `json:"name"`
+		Path string `json:"path"`
+	}
+)
+
+// Inject dependencies
+func (c *SuggestController) Inject(responder *web.Responder, logger flamingo.Logger, suggestService *domain.SuggestService) *SuggestController {
+	c.responder = responder
+	c.logger = logger.WithField(flamingo.LogKeyModule, "flamingo-commerce-adapter-standalone.commercesearch").WithField(flamingo.LogKeyCategory, "SuggestController")
+	c.suggestService = suggestService
+
+	return c
+}
+
+// Suggest returns the suggestions for the query parameter "q", the optional parameter "limit" limits the results per type
+func (c *SuggestController) Suggest(ctx context.Context, r *web.Request) web.Result {
+	prefix, _ := r.Query1("q")
+	limit := 0
+	if limitParam, err := r.Query1("limit"); err == nil {
+		limit, _ = strconv.Atoi(limitParam)
+	}
+
+	result, err := c.suggestService.Suggest(ctx, prefix, limit)
+	if err != nil {
+		c.logger.WithContext(ctx).Error(err)
+		return c.responder.ServerError(err)
+	}
+
+	response := suggestResponse{
+		Products:    make([]suggestProduct, 0, len(result.Products)),
+		Categories:  make([]suggestCategory, 0, len(result.Categories)),
+		Completions: make([]string, 0, len(result.Completions)),
+	}
+	for _, product := range result.Products {
+		response.Products = append(response.Products, suggestProduct{
+			MarketPlaceCode: product.BaseData().MarketPlaceCode,
+			Title:           product.BaseData().Title,
+			Type:            product.Type(),
+		})
+	}
+	for _, category := range result.Categories {
+		response.Categories = append(response.Categories, suggestCategory{
+			Code: category.Code(),
+			Name: category.Name(),
+			Path: category.Path(),
+		})
+	}
+	response.Completions = append(response.Completions, result.Completions...)
+
+	return c.responder.Data(response)
+}
@@ -0,0 +1,29 @@
+package graphql
+
+import (
+	"context"
+
+	"flamingo.me/flamingo-commerce-adapter-standalone/commercesearch/domain"
+)
+
+// SuggestResolver resolves the search-as-you-type query
+type SuggestResolver struct {
+	suggestService *domain.SuggestService
+}
+
+// Inject dependencies
+func (r *SuggestResolver) Inject(suggestService *domain.SuggestService) *SuggestResolver {
+	r.suggestService = suggestService
+
+	return r
+}
+
+// Suggest returns products, categories and completions for the given prefix
+func (r *SuggestResolver) Suggest(ctx context.Context, prefix string, limit *int) (*domain.SuggestResult, error) {
+	resultLimit := 0
+	if limit != nil {
+		resultLimit = *limit
+	}
+
+	return r.suggestService.Suggest(ctx, prefix, resultLimit)
+}
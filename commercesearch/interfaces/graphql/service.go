@@ -0,0 +1,29 @@
+package graphql
+
+import (
+	// embed schema.graphql
+	_ "embed"
+
+	"flamingo.me/graphql"
+
+	"flamingo.me/flamingo-commerce-adapter-standalone/commercesearch/domain"
+)
+
+// Service describes the standalone commercesearch GraphQL Service
+type Service struct{}
+
+var _ graphql.Service = new(Service)
+
+//go:embed schema.graphql
+var schema []byte
+
+// Schema for the suggest query
+func (*Service) Schema() []byte {
+	return schema
+}
+
+// Types configures the GraphQL to Go resolvers
+func (*Service) Types(types *graphql.Types) {
+	types.Map("CommerceAdapterStandalone_SuggestResult", domain.SuggestResult{})
+	types.Resolve("Query", "CommerceAdapterStandalone_suggest", SuggestResolver{}, "Suggest")
+}
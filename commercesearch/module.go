@@ -5,17 +5,21 @@ import (
 
 	"flamingo.me/dingo"
 	commerceCategoryDomain "flamingo.me/flamingo-commerce/v3/category/domain"
+	commerceGraphql "flamingo.me/flamingo-commerce/v3/graphql"
 	commerceProduct "flamingo.me/flamingo-commerce/v3/product"
 	commerceProductDomain "flamingo.me/flamingo-commerce/v3/product/domain"
 	commerceSearchDomain "flamingo.me/flamingo-commerce/v3/search/domain"
 	"flamingo.me/flamingo/v3/framework/flamingo"
 	"flamingo.me/flamingo/v3/framework/web"
+	"flamingo.me/graphql"
 
 	"flamingo.me/flamingo-commerce-adapter-standalone/commercesearch/domain"
 	"flamingo.me/flamingo-commerce-adapter-standalone/commercesearch/infrastructure/category"
 	"flamingo.me/flamingo-commerce-adapter-standalone/commercesearch/infrastructure/commercesearch"
 	"flamingo.me/flamingo-commerce-adapter-standalone/commercesearch/infrastructure/product"
 	"flamingo.me/flamingo-commerce-adapter-standalone/commercesearch/infrastructure/search"
+	"flamingo.me/flamingo-commerce-adapter-standalone/commercesearch/interfaces/controller"
+	commercesearchGraphql "flamingo.me/flamingo-commerce-adapter-standalone/commercesearch/interfaces/graphql"
 )
 
 type (
@@ -35,6 +39,13 @@ type (
 
 	// SearchModule registers the Category Adapter that uses the productRepositry
 	SearchModule struct{}
+
+	// SuggestModule registers the search-as-you-type endpoint and GraphQL query
+	SuggestModule struct{}
+
+	suggestRoutes struct {
+		controller *controller.SuggestController
+	}
 )
 
 // Inject for subscriber
@@ -83,9 +94,15 @@ func (m *Module) Configure(injector *dingo.Injector) {
 	case "bleve":
 		injector.Bind((*domain.ProductRepository)(nil)).To(commercesearch.BleveRepository{}).In(dingo.ChildSingleton)
 		injector.Bind((*domain.CategoryRepository)(nil)).To(commercesearch.BleveRepository{}).In(dingo.ChildSingleton)
+		injector.Bind((*domain.SuggestRepository)(nil)).To(commercesearch.BleveRepository{}).In(dingo.ChildSingleton)
+	case "sqlite":
+		injector.Bind((*domain.ProductRepository)(nil)).To(commercesearch.SQLiteRepository{}).In(dingo.ChildSingleton)
+		injector.Bind((*domain.CategoryRepository)(nil)).To(commercesearch.SQLiteRepository{}).In(dingo.ChildSingleton)
+		injector.Bind((*domain.SuggestRepository)(nil)).To(commercesearch.SQLiteRepository{}).In(dingo.ChildSingleton)
 	default:
 		injector.Bind((*domain.ProductRepository)(nil)).To(commercesearch.InMemoryProductRepository{}).In(dingo.ChildSingleton)
 		injector.Bind((*domain.CategoryRepository)(nil)).To(commercesearch.InMemoryProductRepository{}).In(dingo.ChildSingleton)
+		injector.Bind((*domain.SuggestRepository)(nil)).To(commercesearch.InMemoryProductRepository{}).In(dingo.ChildSingleton)
 	}
 }
 
@@ -106,18 +123,182 @@ func (module *SearchModule) Configure(injector *dingo.Injector) {
 	injector.Bind(new(commerceSearchDomain.SearchService)).To(search.ServiceAdapter{})
 }
 
+// Configure DI
+func (module *SuggestModule) Configure(injector *dingo.Injector) {
+	web.BindRoutes(injector, new(suggestRoutes))
+	injector.BindMulti(new(graphql.Service)).To(commercesearchGraphql.Service{})
+}
+
+// Depends on other modules
+func (module *SuggestModule) Depends() []dingo.Module {
+	return []dingo.Module{
+		new(Module),
+		new(graphql.Module),
+		// provides the Commerce_Product and Commerce_Category types used by the suggest schema
+		new(commerceGraphql.Module),
+	}
+}
+
+func (r *suggestRoutes) Inject(controller *controller.SuggestController) {
+	r.controller = controller
+}
+
+func (r *suggestRoutes) Routes(registry *web.RouterRegistry) {
+	registry.HandleGet("commercesearch.suggest", r.controller.Suggest)
+	registry.MustRoute("/commercesearch/suggest", `commercesearch.suggest`)
+}
+
 // CueConfig defines the cart module configuration
 func (*Module) CueConfig() string {
 	return `
 flamingoCommerceAdapterStandalone: {
 	commercesearch: {
 		enableIndexing: bool | *true
-		repositoryAdapter: "bleve" | *"inmemory"
+		repositoryAdapter: "bleve" | "sqlite" | *"inmemory"
+		suggest: {
+			minPrefixLength: number | *2
+			defaultLimit: number | *5
+			maxLimit: number | *20
+		}
+		visibility: {
+			nonSaleable: *"show" | "hide" | "last"
+			outOfStock: *"show" | "hide" | "last"
+		}
+		collapseVariants: *"none" | "parent" | "variant"
+		inMemoryAdapter: {
+			sortConfig: [...{
+				attributeCode?: string
+				attributeType: "numeric" | "bool" | "date" | *"text"
+				dateLayout?: string
+				asc: bool
+				desc: bool
+				name?: string
+				label?: string
+				fields: [...{attributeCode: string, attributeType: "numeric" | "bool" | "date" | *"text", dateLayout?: string, desc: bool | *false}]
+			}]
+			enableCategoryFacet: bool | *false
+			facetConfig: [...{
+				attributeCode: string
+				amount: number | *10
+				type: *"list" | "range"
+				ranges?: [...{from?: number, to?: number, label?: string}]
+				buckets: number | *5
+				multiSelect: bool | *false
+				label?: string
+				position: number | *0
+				sort: *"count" | "alpha" | "custom"
+				order: [...string]
+				minCount: number | *0
+				hideSingleValue: bool | *false
+			}]
+			query: {
+				attributes: [...string]
+				operator: "and" | *"or"
+				suggestionThreshold: number | *0
+			}
+			snapshot: {
+				file?: string
+				restore: bool | *true
+				saveAfterIndexing: bool | *true
+			}
+		}
+		sqliteAdapter: {
+			driverName: string | *"sqlite"
+			dataSourceName?: string
+			productsToParentCategories: bool | *true
+			enableCategoryFacet: bool | *false
+			facetConfig: [...{
+				attributeCode: string
+				amount: number | *10
+				type: *"list" | "range"
+				ranges?: [...{from?: number, to?: number, label?: string}]
+				buckets: number | *5
+				multiSelect: bool | *false
+				label?: string
+				position: number | *0
+				sort: *"count" | "alpha" | "custom"
+				order: [...string]
+				minCount: number | *0
+				hideSingleValue: bool | *false
+			}]
+			sortConfig: [...{
+				attributeCode?: string
+				attributeType: "numeric" | "bool" | "date" | *"text"
+				dateLayout?: string
+				asc: bool
+				desc: bool
+				name?: string
+				label?: string
+				fields: [...{attributeCode: string, attributeType: "numeric" | "bool" | "date" | *"text", dateLayout?: string, desc: bool | *false}]
+			}]
+			query: {
+				attributes: [...string]
+				operator: "and" | *"or"
+				suggestionThreshold: number | *0
+			}
+		}
 		bleveAdapter: {
 			productsToParentCategories: bool | *true
 			enableCategoryFacet: bool | *false
-			facetConfig: [...{attributeCode: string, amount: number}]
-			sortConfig:[...{attributeCode: string, attributeType: "numeric"|"bool"|*"text", asc: bool, desc: bool}]
+			categoryFacetSize: number | *100
+			facetConfig: [...{
+				attributeCode: string
+				amount: number | *10
+				type: *"list" | "range"
+				ranges?: [...{from?: number, to?: number, label?: string}]
+				buckets: number | *5
+				multiSelect: bool | *false
+				label?: string
+				position: number | *0
+				sort: *"count" | "alpha" | "custom"
+				order: [...string]
+				minCount: number | *0
+				hideSingleValue: bool | *false
+			}]
+			categoryFacetConfig: [...{
+				categoryCode: string
+				mode: *"extend" | "override"
+				facets: [...{
+					attributeCode: string
+					amount: number | *10
+					type: *"list" | "range"
+					ranges?: [...{from?: number, to?: number, label?: string}]
+					buckets: number | *5
+					multiSelect: bool | *false
+					label?: string
+					position: number | *0
+					sort: *"count" | "alpha" | "custom"
+					order: [...string]
+					minCount: number | *0
+					hideSingleValue: bool | *false
+				}]
+			}]
+			sortConfig: [...{
+				attributeCode?: string
+				attributeType: "numeric" | "bool" | "date" | *"text"
+				dateLayout?: string
+				asc: bool
+				desc: bool
+				name?: string
+				label?: string
+				fields: [...{attributeCode: string, attributeType: "numeric" | "bool" | "date" | *"text", dateLayout?: string, desc: bool | *false}]
+			}]
+			query: {
+				operator: "and" | *"or"
+				minimumShouldMatch: number | *1
+				typoTolerance: bool | *true
+				typoPrefixLength: number | *1
+				minTermLengthOneTypo: number | *4
+				minTermLengthTwoTypos: number | *8
+				suggestionThreshold: number | *0
+			}
+			highlight: {
+				attributes: [...string]
+				fragmentSize: number | *100
+				maxFragments: number | *1
+				markupBefore: string | *"<mark>"
+				markupAfter: string | *"</mark>"
+			}
 		}
 	}
 }`
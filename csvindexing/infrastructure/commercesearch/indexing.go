@@ -2,8 +2,11 @@ package commercesearch
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -37,7 +40,8 @@ type (
 )
 
 var (
-	_ commerceSearchDomain.IndexUpdater = &IndexUpdater{}
+	_ commerceSearchDomain.IndexUpdater        = &IndexUpdater{}
+	_ commerceSearchDomain.SourceFingerprinter = &IndexUpdater{}
 )
 
 // Inject method to inject dependencies
@@ -87,6 +91,24 @@ func (u *IndexUpdater) Inject(
 	return u
 }
 
+// SourceFingerprint identifies the CSV files by path, size and modification time
+func (u *IndexUpdater) SourceFingerprint(_ context.Context) (string, error) {
+	hash := sha256.New()
+	// the configuration is part of the fingerprint, it changes the indexed products as well
+	fmt.Fprintf(hash, "%s|%s|%s|%v\n", u.locale, u.currency, string(u.productCsvDelimiter), u.productAttributesToSplit)
+	for _, file := range []string{u.productCsvFile, u.categoryCsvFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hash, "%s|%d|%d\n", file, info.Size(), info.ModTime().UnixNano())
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
 // Index starts index process
 func (u *IndexUpdater) Index(ctx context.Context, indexer *commerceSearchDomain.Indexer) error {
 	u.logger.Info(fmt.Sprintf("Start loading CSV file: %v  with locale: %v and currency %v", u.productCsvFile, u.locale, u.currency))
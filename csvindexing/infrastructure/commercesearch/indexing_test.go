@@ -232,3 +232,43 @@ func benchmarkIndexer(b *testing.B, productsCsv string, categoriesCsv string, ch
 		assert.NoError(b, err)
 	}
 }
+
+func TestIndexUpdater_SourceFingerprint(t *testing.T) {
+	productsCsv := t.TempDir() + "/products.csv"
+	require.NoError(t, os.WriteFile(productsCsv, []byte("marketplaceCode\n1\n"), 0o644))
+
+	indexUpdater := commercesearch.IndexUpdater{}
+	indexUpdater.Inject(flamingo.NullLogger{},
+		&domain.CategoryTreeBuilder{},
+		nil,
+		nil,
+		&struct {
+			ProductCsvFile           string       `inject:"config:flamingoCommerceAdapterStandalone.csvindexing.products.file.path"`
+			ProductCsvDelimiter      string       `inject:"config:flamingoCommerceAdapterStandalone.csvindexing.products.file.delimiter"`
+			ProductAttributesToSplit config.Slice `inject:"config:flamingoCommerceAdapterStandalone.csvindexing.products.attributesToSplit"`
+			CategoryCsvFile          string       `inject:"config:flamingoCommerceAdapterStandalone.csvindexing.categories.file.path,optional"`
+			CategoryCsvDelimiter     string       `inject:"config:flamingoCommerceAdapterStandalone.csvindexing.categories.file.delimiter,optional"`
+			Locale                   string       `inject:"config:flamingoCommerceAdapterStandalone.csvindexing.locale"`
+			Currency                 string       `inject:"config:flamingoCommerceAdapterStandalone.csvindexing.currency"`
+		}{
+			Currency:       "GBP",
+			Locale:         "en_GB",
+			ProductCsvFile: productsCsv,
+		},
+	)
+
+	fingerprint, err := indexUpdater.SourceFingerprint(context.Background())
+	require.NoError(t, err)
+	unchanged, err := indexUpdater.SourceFingerprint(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, fingerprint, unchanged)
+
+	require.NoError(t, os.WriteFile(productsCsv, []byte("marketplaceCode\n1\n2\n"), 0o644))
+	changed, err := indexUpdater.SourceFingerprint(context.Background())
+	require.NoError(t, err)
+	assert.NotEqual(t, fingerprint, changed, "an edited CSV file changes the fingerprint")
+
+	require.NoError(t, os.Remove(productsCsv))
+	_, err = indexUpdater.SourceFingerprint(context.Background())
+	assert.Error(t, err)
+}